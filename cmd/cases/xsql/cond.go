@@ -0,0 +1,172 @@
+package xsql
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/zyguan/mytest/mystmt"
+)
+
+// condOps are operators of conditions, an operator is matched before the ones
+// following it at the same position.
+var condOps = []string{"=~", "!~", "==", "!=", "<=", ">=", "<", ">"}
+
+// applies tells whether a statement enclosed by blocks of conds runs on the
+// source of the task. Inner conditions are not evaluated once an outer one
+// fails.
+func (t *sqlTask) applies(conds []mystmt.Cond, version string) (bool, error) {
+	for _, c := range conds {
+		ok, err := t.eval(c.Expr, version)
+		if err != nil {
+			return false, errors.Annotatef(err, "if %s", c.Expr)
+		}
+		if ok == c.Not {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// eval evaluates conditions like "version >= 8.0", "label == tidb" or "$n > 1".
+// The operands version and label refer to the server version and the label of
+// the source, variables are substituted in others. A condition without any
+// operator holds if its value is neither empty nor "0", and "!" negates it.
+func (t *sqlTask) eval(expr string, version string) (bool, error) {
+	lhs, op, rhs := splitCond(expr)
+	if len(op) == 0 {
+		not := strings.HasPrefix(lhs, "!")
+		v, err := t.operand(strings.TrimPrefix(lhs, "!"), version)
+		if err != nil {
+			return false, err
+		}
+		return (len(v) > 0 && v != "0") != not, nil
+	}
+	a, err := t.operand(lhs, version)
+	if err != nil {
+		return false, err
+	}
+	b, err := t.operand(rhs, version)
+	if err != nil {
+		return false, err
+	}
+	switch op {
+	case "=~", "!~":
+		re, err := regexp.Compile(b)
+		if err != nil {
+			return false, errors.Annotate(err, "compile pattern")
+		}
+		return re.MatchString(a) == (op == "=~"), nil
+	case "==":
+		return a == b, nil
+	case "!=":
+		return a != b, nil
+	}
+	var c int
+	if lhs == "version" || rhs == "version" {
+		c = compareVersions(a, b)
+	} else if x, err1 := strconv.ParseFloat(a, 64); err1 == nil {
+		if y, err2 := strconv.ParseFloat(b, 64); err2 == nil {
+			c = compareFloats(x, y)
+		} else {
+			c = strings.Compare(a, b)
+		}
+	} else {
+		c = strings.Compare(a, b)
+	}
+	switch op {
+	case "<=":
+		return c <= 0, nil
+	case ">=":
+		return c >= 0, nil
+	case "<":
+		return c < 0, nil
+	default:
+		return c > 0, nil
+	}
+}
+
+func (t *sqlTask) operand(s string, version string) (string, error) {
+	switch s {
+	case "version":
+		return version, nil
+	case "label":
+		return t.source, nil
+	}
+	v, err := t.vars.ExpandArg(s)
+	if err != nil {
+		return "", err
+	}
+	if len(v) > 1 && (v[0] == '\'' || v[0] == '"') && v[len(v)-1] == v[0] {
+		v = v[1 : len(v)-1]
+	}
+	return v, nil
+}
+
+// splitCond splits the condition at the first operator out of quoted
+// operands.
+func splitCond(expr string) (string, string, string) {
+	for i := 0; i < len(expr); i++ {
+		if q := expr[i]; q == '\'' || q == '"' {
+			if end := strings.IndexByte(expr[i+1:], q); end >= 0 {
+				i += end + 1
+				continue
+			}
+		}
+		for _, op := range condOps {
+			if strings.HasPrefix(expr[i:], op) {
+				return strings.TrimSpace(expr[:i]), op, strings.TrimSpace(expr[i+len(op):])
+			}
+		}
+	}
+	return strings.TrimSpace(expr), "", ""
+}
+
+// compareVersions compares leading numbers separated by dots of versions like
+// "8.0.28-log", missing numbers are taken as 0.
+func compareVersions(a string, b string) int {
+	x, y := versionNumbers(a), versionNumbers(b)
+	for i := 0; i < len(x) || i < len(y); i++ {
+		var m, n int
+		if i < len(x) {
+			m = x[i]
+		}
+		if i < len(y) {
+			n = y[i]
+		}
+		if m != n {
+			return compareFloats(float64(m), float64(n))
+		}
+	}
+	return 0
+}
+
+func versionNumbers(v string) []int {
+	var ns []int
+	for _, s := range strings.Split(v, ".") {
+		i := 0
+		for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			break
+		}
+		n, _ := strconv.Atoi(s[:i])
+		ns = append(ns, n)
+		if i < len(s) {
+			break
+		}
+	}
+	return ns
+}
+
+func compareFloats(x float64, y float64) int {
+	if x < y {
+		return -1
+	}
+	if x > y {
+		return 1
+	}
+	return 0
+}
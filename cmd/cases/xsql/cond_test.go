@@ -0,0 +1,128 @@
+package xsql
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zyguan/mytest/internal/testmysql"
+	"github.com/zyguan/mytest/mycase"
+	"github.com/zyguan/mytest/mystmt"
+)
+
+func TestEval(t *testing.T) {
+	task := &sqlTask{source: "tidb", vars: mystmt.Vars{"n": "1000", "s": "abc", "z": "0"}}
+	for _, tt := range []struct {
+		expr    string
+		version string
+		ok      bool
+	}{
+		{"version >= 8.0", "8.0.28", true},
+		{"version >= 8.0", "5.7.25-TiDB-v6.1.0", false},
+		{"version < 8.0.10", "8.0.9-log", true},
+		{"version > 5.7", "5.7", false},
+		{"version =~ TiDB", "5.7.25-TiDB-v6.1.0", true},
+		{"version !~ TiDB", "8.0.28", true},
+		{"label == tidb", "", true},
+		{"label != 'tidb'", "", false},
+		{"$n > 999", "", true},
+		{"${n}<=99", "", false},
+		{"$s > abb", "", true},
+		{"$s == \"abc\"", "", true},
+		{"'a<b' != label", "", true},
+		{"'x==y' == \"x==y\"", "", true},
+		{"\"a=~b\" =~ '^a=~'", "", true},
+		{"$n", "", true},
+		{"$z", "", false},
+		{"!$z", "", true},
+	} {
+		ok, err := task.eval(tt.expr, tt.version)
+		assert.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.ok, ok, "%s on %s", tt.expr, tt.version)
+	}
+
+	for _, expr := range []string{"$x == 1", "version =~ (", "$n > $x"} {
+		_, err := task.eval(expr, "8.0")
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestApplies(t *testing.T) {
+	task := &sqlTask{source: "mysql", vars: mystmt.Vars{}}
+	ok, err := task.applies(nil, "8.0")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = task.applies([]mystmt.Cond{{Expr: "version >= 8.0"}, {Expr: "label == tidb", Not: true}}, "8.0.28")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// inner conditions are skipped once an outer one fails.
+	ok, err = task.applies([]mystmt.Cond{{Expr: "label == tidb"}, {Expr: "$undefined"}}, "8.0.28")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = task.applies([]mystmt.Cond{{Expr: "$undefined"}}, "8.0.28")
+	assert.Error(t, err)
+}
+
+func TestRun_PartialSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xsql")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cfg, dropDB := testmysql.DB(t)
+	defer dropDB()
+	text := `--if label == a
+--query k1
+select 1;
+--endif
+--query k2
+select 2;
+--if label == a
+--query k3
+select 3;
+--else
+--query k3
+select 4;
+--endif
+`
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, "test.sql"), []byte(text), 0644))
+	x := &XSQLCase{Name: "partial", home: dir, DSNs: []string{cfg.FormatDSN(), cfg.FormatDSN()}, Labels: []string{"a", "b"}}
+	x.Stages.Test = []string{"test.sql"}
+	x.CheckerList = map[string]Checker{"k1": {}, "k2": {}, "k3": {}}
+	assert.NoError(t, validateAndSetDefault(x))
+
+	rc := mycase.NewMemResultStore()
+	err = mycase.Run(x, rc)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"k3"}, err.(*mycase.RunErrors).DiffKeys)
+	baseID := rc.CurrentTask.ID
+	qrs, err := rc.Read("k1")
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(qrs)) {
+		assert.Equal(t, "a", qrs[0].Source)
+	}
+	for key, state := range map[string]string{"k1": "", "k2": mycase.StateOK, "k3": mycase.StateFail} {
+		cs, err := rc.KeyChecks(key)
+		assert.NoError(t, err)
+		if len(state) == 0 {
+			// a key of a single source is not checked without a baseline.
+			assert.Empty(t, cs, key)
+		} else if assert.Equal(t, 1, len(cs), key) {
+			assert.Equal(t, state, cs[0].State, key)
+		}
+	}
+
+	// the key of a single source is compared against the baseline.
+	err = mycase.Run(x, rc, mycase.WithBaseline(baseID))
+	assert.Error(t, err)
+	assert.Equal(t, []string{"k3"}, err.(*mycase.RunErrors).DiffKeys)
+	cs, err := rc.KeyChecks("k1")
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(cs)) {
+		assert.Equal(t, mycase.StateOK, cs[0].State)
+		assert.Equal(t, []string{"a"}, cs[0].Sources)
+	}
+}
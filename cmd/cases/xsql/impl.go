@@ -1,18 +1,21 @@
 package xsql
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"os"
 	"path"
+	"strconv"
 	"time"
 
 	"github.com/go-logr/logr"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 	"github.com/pingcap/errors"
 	"github.com/zyguan/mytest/mycase"
+	"github.com/zyguan/mytest/mysqltest"
 	"github.com/zyguan/mytest/mystmt"
 	"github.com/zyguan/mytest/resultset"
 	"github.com/zyguan/zapglog/log"
@@ -33,7 +36,10 @@ type Checker struct {
 	Assertions     []Assertion `json:"assertions"`
 }
 
-var _ mycase.MyCase = &XSQLCase{}
+var (
+	_ mycase.MyCase   = &XSQLCase{}
+	_ mycase.Rerunner = &XSQLCase{}
+)
 
 type XSQLCase struct {
 	Name   string          `json:"name"`
@@ -44,12 +50,21 @@ type XSQLCase struct {
 		Teardown []string `json:"teardown"`
 	} `json:"stages"`
 	CheckerList map[string]Checker `json:"checkers"`
+	// Vars are initial values of variables of statements on every dsn, they can
+	// be overridden by args of Setup. Variables defined by let commands last
+	// until the end of the task, they are kept per source across stages.
+	Vars map[string]string `json:"vars"`
 
 	DSNs []string
+	// Labels names the sources of DSNs by index, a label derived from the dsn is
+	// used if it's absent. They can be overridden by args of Setup.
+	Labels []string `json:"labels"`
 
 	home     string
 	checkers map[string]resultset.Checker
 	current  *mycase.TaskInfo
+	vars     map[string]mystmt.Vars
+	replays  map[string]map[string]replay
 	log      logr.Logger
 }
 
@@ -61,6 +76,8 @@ func (x *XSQLCase) NewTask() mycase.TaskInfo {
 		Meta: x.Meta,
 	}
 	x.log = logger.WithName(x.Name).WithValues("id", x.current.ID)
+	x.vars = make(map[string]mystmt.Vars)
+	x.replays = make(map[string]map[string]replay)
 	return *x.current
 }
 
@@ -77,19 +94,15 @@ func (x *XSQLCase) Setup(args json.RawMessage) error {
 		return nil
 	}
 	if args != nil {
-		var spec struct {
-			File string `json:"file"`
-		}
-
-		if err := json.Unmarshal(args, &spec); err != nil {
-			x.log.Error(err, "parse case spec")
-			return err
-		}
-		if err := x.loadFromFile(spec.File); err != nil {
-			x.log.Error(err, "load case spec", "file", spec.File)
+		if err := x.applyArgs(args); err != nil {
+			x.log.Error(err, "apply case spec")
 			return err
 		}
 	}
+	if err := x.checkLabels(); err != nil {
+		x.log.Error(err, "check labels")
+		return err
+	}
 
 	done := make(chan struct{})
 	errs := make(chan error, len(x.DSNs)+1)
@@ -97,8 +110,10 @@ func (x *XSQLCase) Setup(args json.RawMessage) error {
 	tasks := make([]sqlTask, len(x.DSNs))
 	for i, dsn := range x.DSNs {
 		tasks[i].dsn = dsn
+		tasks[i].source = x.label(i)
 		tasks[i].log = x.log.WithValues("dsn", dsn)
 		tasks[i].stmtCh = make(chan mystmt.Stmt, 64)
+		tasks[i].vars = x.varsOf(tasks[i].source)
 		go func(t *sqlTask) {
 			errs <- t.Run()
 		}(&tasks[i])
@@ -117,7 +132,7 @@ func (x *XSQLCase) Setup(args json.RawMessage) error {
 		}()
 		for _, p := range x.Stages.Setup {
 			f := path.Join(x.home, p)
-			it, err = mystmt.SplitFile(f)
+			it, err = splitFile(f)
 			if err != nil {
 				x.log.Error(err, "load and split setup file", "file", f)
 				return
@@ -132,6 +147,10 @@ func (x *XSQLCase) Setup(args json.RawMessage) error {
 					}
 				}
 			}
+			if err = it.Err(); err != nil {
+				x.log.Error(err, "split setup file", "file", f)
+				return
+			}
 		}
 	}()
 
@@ -155,6 +174,10 @@ func (x *XSQLCase) Test(rc mycase.ResultStore) error {
 		x.log.Info("no dsn provided")
 		return nil
 	}
+	if err := x.checkLabels(); err != nil {
+		x.log.Error(err, "check labels")
+		return err
+	}
 
 	done := make(chan struct{})
 	errs := make(chan error, len(x.DSNs)+1)
@@ -162,10 +185,13 @@ func (x *XSQLCase) Test(rc mycase.ResultStore) error {
 	tasks := make([]sqlTask, len(x.DSNs))
 	for i, dsn := range x.DSNs {
 		tasks[i].dsn = dsn
+		tasks[i].source = x.label(i)
 		tasks[i].log = x.log.WithValues("dsn", dsn)
 		tasks[i].stmtCh = make(chan mystmt.Stmt, 64)
+		tasks[i].vars = x.varsOf(tasks[i].source)
 		tasks[i].rc = rc
 		tasks[i].availCMDs = []string{cmdQuery, cmdExecute}
+		tasks[i].replays = x.replaysOf(tasks[i].source)
 		go func(t *sqlTask) {
 			errs <- t.Run()
 		}(&tasks[i])
@@ -184,7 +210,7 @@ func (x *XSQLCase) Test(rc mycase.ResultStore) error {
 		}()
 		for _, p := range x.Stages.Test {
 			f := path.Join(x.home, p)
-			it, err = mystmt.SplitFile(f)
+			it, err = splitFile(f)
 			if err != nil {
 				x.log.Error(err, "load and split test file", "file", f)
 				return
@@ -199,6 +225,10 @@ func (x *XSQLCase) Test(rc mycase.ResultStore) error {
 					}
 				}
 			}
+			if err = it.Err(); err != nil {
+				x.log.Error(err, "split test file", "file", f)
+				return
+			}
 		}
 	}()
 
@@ -209,6 +239,60 @@ func (x *XSQLCase) Test(rc mycase.ResultStore) error {
 			close(done)
 		}
 	}
+	if fstErr != nil {
+		return fstErr
+	}
+	return x.checkResults(rc, tasks)
+}
+
+// Rerun executes the query of key again on every dsn, statements executed by
+// the execute command are not retried since they are likely to modify data.
+// The statement is replayed as it was run by Test, with its modifiers, bench
+// settings and variables. Results of the rerun replace earlier ones of the
+// key. The query is executed on a fresh connection, session states like
+// variables set or temporary tables created by earlier statements are not
+// restored, so keys depending on them should not be retried.
+func (x *XSQLCase) Rerun(rc mycase.ResultStore, key string) error {
+	if x.current == nil {
+		return errors.New("task is uninitialized")
+	}
+
+	tasks := make([]sqlTask, 0, len(x.DSNs))
+	for i, dsn := range x.DSNs {
+		r, ok := x.replays[x.label(i)][key]
+		if !ok {
+			// the query doesn't apply to the source.
+			continue
+		}
+		t := sqlTask{
+			dsn:       dsn,
+			source:    x.label(i),
+			log:       x.log.WithValues("dsn", dsn, "key", key),
+			stmtCh:    make(chan mystmt.Stmt, 1),
+			vars:      r.vars,
+			mods:      r.mods,
+			rc:        rc,
+			replace:   true,
+			availCMDs: []string{cmdQuery},
+		}
+		t.stmtCh <- r.stmt
+		close(t.stmtCh)
+		tasks = append(tasks, t)
+	}
+
+	errs := make(chan error, len(tasks))
+	for i := range tasks {
+		go func(t *sqlTask) {
+			errs <- t.Run()
+		}(&tasks[i])
+	}
+
+	var fstErr error
+	for i := 0; i < len(tasks); i++ {
+		if err := <-errs; err != nil && fstErr == nil {
+			fstErr = err
+		}
+	}
 	return fstErr
 }
 
@@ -216,7 +300,11 @@ func (x *XSQLCase) Teardown() error {
 	if x.current == nil {
 		return nil
 	}
-	defer func() { x.current, x.log = nil, nil }()
+	defer func() { x.current, x.vars, x.replays, x.log = nil, nil, nil, nil }()
+	if err := x.checkLabels(); err != nil {
+		x.log.Error(err, "check labels")
+		return err
+	}
 
 	done := make(chan struct{})
 	errs := make(chan error, len(x.DSNs)+1)
@@ -224,8 +312,10 @@ func (x *XSQLCase) Teardown() error {
 	tasks := make([]sqlTask, len(x.DSNs))
 	for i, dsn := range x.DSNs {
 		tasks[i].dsn = dsn
+		tasks[i].source = x.label(i)
 		tasks[i].log = x.log.WithValues("dsn", dsn)
 		tasks[i].stmtCh = make(chan mystmt.Stmt, 64)
+		tasks[i].vars = x.varsOf(tasks[i].source)
 		go func(t *sqlTask) {
 			errs <- t.Run()
 		}(&tasks[i])
@@ -244,7 +334,7 @@ func (x *XSQLCase) Teardown() error {
 		}()
 		for _, p := range x.Stages.Teardown {
 			f := path.Join(x.home, p)
-			it, err = mystmt.SplitFile(f)
+			it, err = splitFile(f)
 			if err != nil {
 				x.log.Error(err, "load and split teardown file", "file", f)
 				return
@@ -259,6 +349,10 @@ func (x *XSQLCase) Teardown() error {
 					}
 				}
 			}
+			if err = it.Err(); err != nil {
+				x.log.Error(err, "split teardown file", "file", f)
+				return
+			}
 		}
 	}()
 
@@ -272,6 +366,97 @@ func (x *XSQLCase) Teardown() error {
 	return fstErr
 }
 
+// varsOf returns variables of the source in the current task, which start as a
+// copy of initial variables.
+func (x *XSQLCase) varsOf(source string) mystmt.Vars {
+	if vars, ok := x.vars[source]; ok {
+		return vars
+	}
+	vars := make(mystmt.Vars, len(x.Vars))
+	for k, v := range x.Vars {
+		vars[k] = v
+	}
+	if x.vars == nil {
+		x.vars = make(map[string]mystmt.Vars)
+	}
+	x.vars[source] = vars
+	return vars
+}
+
+// replaysOf returns statements of the source run by the query command in the
+// current task, which are replayed by Rerun.
+func (x *XSQLCase) replaysOf(source string) map[string]replay {
+	if replays, ok := x.replays[source]; ok {
+		return replays
+	}
+	replays := make(map[string]replay)
+	if x.replays == nil {
+		x.replays = make(map[string]map[string]replay)
+	}
+	x.replays[source] = replays
+	return replays
+}
+
+func (x *XSQLCase) label(i int) string {
+	if i < len(x.Labels) && len(x.Labels[i]) > 0 {
+		return x.Labels[i]
+	}
+	return dsnLabel(x.DSNs[i])
+}
+
+// checkLabels fails if DSNs share a label, since results, variables and replays
+// are kept per source and tasks of a source must not run concurrently.
+func (x *XSQLCase) checkLabels() error {
+	seen := make(map[string]int, len(x.DSNs))
+	for i := range x.DSNs {
+		l := x.label(i)
+		if j, ok := seen[l]; ok {
+			return errors.Errorf("dsn #%d and #%d share the label %q", j, i, l)
+		}
+		seen[l] = i
+	}
+	return nil
+}
+
+// dsnLabel derives a label from the dsn without its password, so that dsns
+// differing in user or params are labeled differently.
+func dsnLabel(dsn string) string {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return dsn
+	}
+	cfg.Passwd = ""
+	return cfg.FormatDSN()
+}
+
+// applyArgs applies args of Setup, which may load the case from a file and then
+// override its variables and labels.
+func (x *XSQLCase) applyArgs(args json.RawMessage) error {
+	var spec struct {
+		File   string            `json:"file"`
+		Vars   map[string]string `json:"vars"`
+		Labels []string          `json:"labels"`
+	}
+	if err := json.Unmarshal(args, &spec); err != nil {
+		return errors.Annotate(err, "parse case spec")
+	}
+	if len(spec.File) > 0 {
+		if err := x.loadFromFile(spec.File); err != nil {
+			return errors.Annotatef(err, "load case spec from %s", spec.File)
+		}
+	}
+	if len(spec.Labels) > 0 {
+		x.Labels = spec.Labels
+	}
+	if len(spec.Vars) > 0 && x.Vars == nil {
+		x.Vars = make(map[string]string, len(spec.Vars))
+	}
+	for k, v := range spec.Vars {
+		x.Vars[k] = v
+	}
+	return nil
+}
+
 func (x *XSQLCase) loadFromFile(file string) error {
 	f, err := os.Open(file)
 	if err != nil {
@@ -301,6 +486,17 @@ func Load(file string) (*XSQLCase, error) {
 	return x, nil
 }
 
+func Loader(dsns []string) mycase.CaseLoader {
+	return func(file string) (mycase.MyCase, error) {
+		x, err := Load(file)
+		if err != nil {
+			return nil, err
+		}
+		x.DSNs = dsns
+		return x, nil
+	}
+}
+
 func validateAndSetDefault(x *XSQLCase) error {
 	if len(x.Stages.Test) == 0 {
 		return errors.New("$.stages.test is required")
@@ -365,14 +561,57 @@ const (
 	cmdIgnoreErrors = "ignore_errors"
 	cmdExecute      = "execute"
 	cmdQuery        = "query"
+	// cmdBench makes the following query run `N [WARMUP]` times after WARMUP
+	// runs (1 by default), its data is recorded once with stats of durations.
+	cmdBench = "bench"
 )
 
 type sqlTask struct {
 	dsn       string
+	source    string
 	availCMDs []string
 	stmtCh    chan mystmt.Stmt
 	rc        mycase.ResultStore
+	replace   bool
 	log       logr.Logger
+
+	// states of mysqltest directives.
+	conn    *sql.Conn
+	conns   map[string]*sql.Conn
+	dbs     []*sql.DB
+	mods    mysqltest.Modifiers
+	writers map[string]*mysqltest.Writer
+	outputs map[string]*bytes.Buffer
+	sources map[string]string
+	vars    mystmt.Vars
+
+	// replays records statements run by the query command by keys if it's
+	// not nil.
+	replays map[string]replay
+}
+
+// replay is a statement run by the query command with states it depends on.
+type replay struct {
+	stmt mystmt.Stmt
+	mods mysqltest.Modifiers
+	vars mystmt.Vars
+}
+
+// replayOf returns the replay of stmt, which is run with mods set by earlier
+// statements. Commands other than modifiers, bench, ignore_errors and the
+// query command itself are dropped since they change states of the task.
+func (t *sqlTask) replayOf(stmt mystmt.Stmt, mods mysqltest.Modifiers) replay {
+	r := replay{stmt: stmt, mods: mods, vars: make(mystmt.Vars, len(t.vars))}
+	r.stmt.Conds, r.stmt.Commands = nil, nil
+	for _, cmd := range stmt.Commands {
+		if ok, _ := new(mysqltest.Modifiers).Add(cmd); ok || cmd.Name == cmdQuery || cmd.Name == cmdBench || cmd.Name == cmdIgnoreErrors {
+			r.stmt.Commands = append(r.stmt.Commands, cmd)
+		}
+	}
+	for k, v := range t.vars {
+		r.vars[k] = v
+	}
+	return r
 }
 
 func (t *sqlTask) Run() error {
@@ -387,32 +626,69 @@ func (t *sqlTask) Run() error {
 		return errors.Annotate(err, "conn db")
 	}
 	defer conn.Close()
+	t.conn, t.conns = conn, map[string]*sql.Conn{defaultConn: conn}
+	t.writers, t.outputs, t.sources = make(map[string]*mysqltest.Writer), make(map[string]*bytes.Buffer), make(map[string]string)
+	defer t.disconnectAll()
 
-	var version string
-	db.QueryRow("select version()").Scan(&version)
+	var version, comment string
+	if err = conn.QueryRowContext(ctx, "select version(), @@version_comment").Scan(&version, &comment); err != nil {
+		return errors.Annotate(err, "query version")
+	}
+	meta, _ := json.Marshal(map[string]string{"version_comment": comment})
 
 	for stmt := range t.stmtCh {
+		if ok, e := t.applies(stmt.Conds, version); e != nil {
+			return errors.Annotatef(e, "check condition at %s", stmt.Location())
+		} else if !ok {
+			continue
+		}
+		out := t.output(stmt.Root())
+		orig, mods := stmt, t.mods
 		ignoreErr := false
-		lastRunCmd := mystmt.Command{}
+		lastRunCmd, bench := mystmt.Command{}, mystmt.Command{}
 		for _, cmd := range stmt.Commands {
+			if cmd, err = t.expandArgs(cmd); err != nil {
+				return errors.Annotatef(err, "%s at %s", cmd.Name, stmt.Location())
+			}
 			if !ignoreErr && cmd.Name == cmdIgnoreErrors {
 				ignoreErr = true
 			}
+			if cmd.Name == cmdBench {
+				bench = cmd
+			}
 			for i := 0; i < len(t.availCMDs); i++ {
 				if t.availCMDs[i] == cmd.Name {
 					lastRunCmd = cmd
 				}
 			}
+			if err = t.directive(ctx, cmd, out); err != nil {
+				return errors.Annotatef(err, "%s at %s", cmd.Name, stmt.Location())
+			}
+		}
+		if len(bench.Name) > 0 && lastRunCmd.Name != cmdQuery {
+			return errors.Errorf("bench at %s: only statements run by --%s can be benchmarked", stmt.Location(), cmdQuery)
+		}
+		if len(stmt.Text) == 0 {
+			continue
+		}
+		if stmt.Text, err = t.expandText(stmt); err != nil {
+			return errors.Annotatef(err, "expand statement at %s", stmt.Location())
+		}
+		if isTestFile(stmt.Root()) {
+			text, err := t.echo(stmt)
+			if err != nil {
+				return errors.Annotatef(err, "expand statement at %s", stmt.Location())
+			}
+			out.Query(text)
 		}
 		var (
-			res  sql.Result
-			rows *sql.Rows
-			rs   *resultset.ResultSet
+			res sql.Result
+			rs  *resultset.ResultSet
 		)
 		t0 := time.Now()
 		switch lastRunCmd.Name {
 		case cmdExecute:
-			res, err = conn.ExecContext(ctx, stmt.Text)
+			res, err = t.conn.ExecContext(ctx, stmt.Text)
 			if err != nil {
 				break
 			}
@@ -422,50 +698,152 @@ func (t *sqlTask) Run() error {
 			if len(lastRunCmd.Args) > 0 {
 				key = lastRunCmd.Args[0]
 			}
-			w := t.rc.Write(mycase.QueryResult{
-				Time:      t0,
-				Duration:  float64(t1.Sub(t0)) / float64(time.Second),
-				Key:       key,
-				SQL:       stmt.Text,
-				Version:   version,
-				ResultSet: rs,
+			w := t.write(mycase.QueryResult{
+				Time:       t0,
+				Duration:   float64(t1.Sub(t0)) / float64(time.Second),
+				Key:        key,
+				SQL:        stmt.Text,
+				Source:     t.source,
+				Version:    version,
+				SourceMeta: meta,
+				ResultSet:  rs,
 			})
 			if w != nil {
-				t.log.Info("write execute result", "err", w.Error())
+				return errors.Annotate(w, "write execute result")
 			}
 		case cmdQuery:
-			rows, err = conn.QueryContext(ctx, stmt.Text)
-			if err != nil {
-				break
+			if t.replays != nil && len(lastRunCmd.Args) > 0 {
+				t.replays[lastRunCmd.Args[0]] = t.replayOf(orig, mods)
+			}
+			runs, warmup := 1, 0
+			if len(bench.Name) > 0 {
+				if runs, warmup, err = parseBench(bench.Args); err != nil {
+					return errors.Annotatef(err, "bench %q", stmt.Text)
+				}
+			}
+			var durs []float64
+			for i := 0; i < warmup+runs && err == nil; i++ {
+				t1 := time.Now()
+				if i == warmup {
+					t0 = t1
+				}
+				rs, err = queryResultSet(ctx, t.conn, stmt.Text)
+				if err == nil && i >= warmup {
+					durs = append(durs, float64(time.Since(t1))/float64(time.Second))
+				}
 			}
-			rs, err = resultset.ReadFromRows(rows)
-			rows.Close()
 			if err != nil {
 				break
 			}
-			t1 := time.Now()
+			t.mods.Apply(rs)
 			key := ""
 			if len(lastRunCmd.Args) > 0 {
 				key = lastRunCmd.Args[0]
 			}
-			w := t.rc.Write(mycase.QueryResult{
-				Time:      t0,
-				Duration:  float64(t1.Sub(t0)) / float64(time.Second),
-				Key:       key,
-				SQL:       stmt.Text,
-				Version:   version,
-				ResultSet: rs,
-			})
-			if w != nil {
-				t.log.Info("write query result", "err", w.Error())
+			qr := mycase.QueryResult{
+				Time:       t0,
+				Duration:   durs[0],
+				Key:        key,
+				SQL:        stmt.Text,
+				Source:     t.source,
+				Version:    version,
+				SourceMeta: meta,
+				ResultSet:  rs,
+			}
+			if len(bench.Name) > 0 {
+				qr.Bench = mycase.NewBenchStats(durs)
+				qr.Duration = qr.Bench.Median
+			}
+			if w := t.write(qr); w != nil {
+				return errors.Annotate(w, "write query result")
 			}
 		default:
-			_, err = conn.ExecContext(ctx, stmt.Text)
+			if !isTestFile(stmt.Root()) {
+				_, err = t.conn.ExecContext(ctx, stmt.Text)
+				break
+			}
+			if rs, err = queryResultSet(ctx, t.conn, stmt.Text); err == nil {
+				t.mods.Apply(rs)
+			}
 		}
+		if e := t.mods.CheckError(err); e != nil {
+			err = e
+		} else if err != nil {
+			out.Error(err)
+			err = nil
+		} else if rs != nil {
+			out.Result(rs)
+			if e := t.showWarnings(ctx, stmt, out); e != nil {
+				return errors.Annotatef(e, "show warnings at %s", stmt.Location())
+			}
+		}
+		t.mods.Reset()
 		if err != nil && !ignoreErr {
-			t.log.Info("unexpected error", "sql", stmt.Text, "err", err.Error())
-			return err
+			t.log.Info("unexpected error", "at", stmt.Location(), "sql", stmt.Text, "err", err.Error())
+			return errors.Annotatef(err, "execute statement at %s", stmt.Location())
 		}
 	}
 	return nil
 }
+
+// write writes the result, or replaces earlier ones if the task is a rerun.
+func (t *sqlTask) write(qr mycase.QueryResult) error {
+	if t.replace {
+		return t.rc.Replace(qr)
+	}
+	return t.rc.Write(qr)
+}
+
+func queryResultSet(ctx context.Context, conn *sql.Conn, query string) (*resultset.ResultSet, error) {
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return resultset.ReadFromRows(rows)
+}
+
+// expandText substitutes variables in the text of stmt, those in quoted
+// literals are substituted only if stmt is from a .test file, see Vars.Expand.
+func (t *sqlTask) expandText(stmt mystmt.Stmt) (string, error) {
+	if isTestFile(stmt.Root()) {
+		return t.vars.ExpandArg(stmt.Text)
+	}
+	return t.vars.Expand(stmt.Text)
+}
+
+// expandArgs substitutes variables in args of the command except the let
+// command, whose name and value are handled by itself.
+func (t *sqlTask) expandArgs(cmd mystmt.Command) (mystmt.Command, error) {
+	if cmd.Name == mystmt.CmdLet || len(cmd.Args) == 0 {
+		return cmd, nil
+	}
+	args := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		var err error
+		if args[i], err = t.vars.ExpandArg(arg); err != nil {
+			return cmd, err
+		}
+	}
+	cmd.Args = args
+	return cmd, nil
+}
+
+// parseBench parses args of the bench command, which are the number of timed
+// runs and optionally the number of warm-up runs.
+func parseBench(args []string) (int, int, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return 0, 0, errors.New("usage: --bench N [WARMUP]")
+	}
+	runs, err := strconv.Atoi(args[0])
+	if err != nil || runs < 1 {
+		return 0, 0, errors.Errorf("invalid number of runs: %s", args[0])
+	}
+	warmup := 1
+	if len(args) > 1 {
+		if warmup, err = strconv.Atoi(args[1]); err != nil || warmup < 0 {
+			return 0, 0, errors.Errorf("invalid number of warm-up runs: %s", args[1])
+		}
+	}
+	return runs, warmup, nil
+}
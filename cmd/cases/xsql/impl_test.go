@@ -2,9 +2,16 @@ package xsql
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/zyguan/mytest/internal/testmysql"
+	"github.com/zyguan/mytest/mycase"
+	"github.com/zyguan/mytest/mysqltest"
+	"github.com/zyguan/mytest/mystmt"
 	"github.com/zyguan/mytest/resultset"
 )
 
@@ -30,6 +37,34 @@ func TestLoad(t *testing.T) {
 		assert.Equal(t, json.RawMessage(`{"tags": ["a", "bb"]}`), x.Meta)
 	})
 
+	t.Run("with_vars", func(t *testing.T) {
+		x, err := Load("fixtures/ok_with_vars.json")
+		assert.NoError(t, err)
+		vars := x.varsOf("a")
+		assert.Equal(t, mystmt.Vars{"n": "1000", "db": "test"}, vars)
+		vars["n"] = "10"
+		assert.Equal(t, "1000", x.Vars["n"])
+		// variables of a source are kept until a new task
+		assert.Equal(t, "10", x.varsOf("a")["n"])
+		assert.Equal(t, "1000", x.varsOf("b")["n"])
+		x.NewTask()
+		assert.Equal(t, "1000", x.varsOf("a")["n"])
+	})
+
+	t.Run("with_labels", func(t *testing.T) {
+		x, err := Load("fixtures/ok_with_vars.json")
+		assert.NoError(t, err)
+		x.DSNs = []string{"root:@tcp(127.0.0.1:3306)/test", "root:@tcp(127.0.0.1:4000)/test", "root:@tcp(127.0.0.1:4001)/test"}
+		assert.Equal(t, []string{"mysql", "tidb"}, x.Labels)
+		assert.Equal(t, "tidb", x.label(1))
+		assert.Equal(t, "root@tcp(127.0.0.1:4001)/test", x.label(2))
+
+		assert.NoError(t, x.applyArgs(json.RawMessage(`{"labels": ["a", "b", "c"], "vars": {"n": "10"}}`)))
+		assert.Equal(t, []string{"a", "b", "c"}, x.Labels)
+		assert.Equal(t, map[string]string{"n": "10", "db": "test"}, x.Vars)
+		assert.Error(t, x.applyArgs(json.RawMessage(`{"file": "fixtures/not_found.json"}`)))
+	})
+
 	t.Run("with_checkers", func(t *testing.T) {
 		x, err := Load("fixtures/ok_with_checkers.json")
 		assert.NoError(t, err)
@@ -57,3 +92,131 @@ func TestLoad(t *testing.T) {
 		assert.Equal(t, []int{0, 1, 3}, x.checkers["k8"].Assertions[0].(resultset.FloatAssertion).Columns)
 	})
 }
+
+func TestLabel(t *testing.T) {
+	x := &XSQLCase{
+		DSNs:   []string{"root:@tcp(127.0.0.1:4000)/test", "root:pass@tcp(127.0.0.1:3306)/test?parseTime=true", "oops"},
+		Labels: []string{"", "mysql"},
+	}
+	assert.Equal(t, "root@tcp(127.0.0.1:4000)/test", x.label(0))
+	assert.Equal(t, "mysql", x.label(1))
+	assert.Equal(t, "oops", x.label(2))
+	assert.NoError(t, x.checkLabels())
+
+	// dsns differing in user or params are labeled differently.
+	x = &XSQLCase{DSNs: []string{
+		"root:pass@tcp(127.0.0.1:4000)/test",
+		"root:pass@tcp(127.0.0.1:4000)/test?tidb_isolation_read_engines=tiflash",
+		"u1:pass@tcp(127.0.0.1:4000)/test",
+	}}
+	assert.Equal(t, "root@tcp(127.0.0.1:4000)/test", x.label(0))
+	assert.Equal(t, "root@tcp(127.0.0.1:4000)/test?tidb_isolation_read_engines=tiflash", x.label(1))
+	assert.Equal(t, "u1@tcp(127.0.0.1:4000)/test", x.label(2))
+	assert.NoError(t, x.checkLabels())
+
+	// sources sharing a label are rejected before running anything.
+	x.Labels = []string{"a", "b", "a"}
+	assert.EqualError(t, x.checkLabels(), `dsn #0 and #2 share the label "a"`)
+	x.NewTask()
+	assert.Error(t, x.Setup(nil))
+	assert.Error(t, x.Test(mycase.NewMemResultStore()))
+	assert.Error(t, x.Teardown())
+}
+
+func TestParseBench(t *testing.T) {
+	runs, warmup, err := parseBench([]string{"10"})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, runs)
+	assert.Equal(t, 1, warmup)
+	runs, warmup, err = parseBench([]string{"5", "0"})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, runs)
+	assert.Equal(t, 0, warmup)
+	for _, args := range [][]string{nil, {"0"}, {"x"}, {"3", "-1"}, {"3", "1", "1"}} {
+		_, _, err = parseBench(args)
+		assert.Error(t, err, "%v", args)
+	}
+}
+
+func TestReplayOf(t *testing.T) {
+	task := &sqlTask{vars: mystmt.Vars{"k": "k1"}}
+	stmt := mystmt.Stmt{
+		Text:  "select * from t where a = ${k};",
+		Conds: []mystmt.Cond{{Expr: "mysql"}},
+		Commands: []mystmt.Command{
+			{Name: "connection", Args: []string{"con1"}},
+			{Name: "sorted_result"},
+			{Name: cmdBench, Args: []string{"3"}},
+			{Name: mystmt.CmdLet, Args: []string{"$x = 1"}},
+			{Name: cmdQuery, Args: []string{"$k"}},
+		},
+	}
+	mods := mysqltest.Modifiers{Columns: map[int]string{1: "#"}}
+	r := task.replayOf(stmt, mods)
+	assert.Equal(t, stmt.Text, r.stmt.Text)
+	assert.Empty(t, r.stmt.Conds)
+	assert.Equal(t, []mystmt.Command{stmt.Commands[1], stmt.Commands[2], stmt.Commands[4]}, r.stmt.Commands)
+	assert.Equal(t, mods, r.mods)
+	// variables are kept as they were when the statement ran.
+	task.vars["k"] = "k2"
+	assert.Equal(t, mystmt.Vars{"k": "k1"}, r.vars)
+}
+
+func TestExpandText(t *testing.T) {
+	task := &sqlTask{vars: mystmt.Vars{"n": "1"}}
+	text := "select ${n}, '${n}', $n;"
+	for _, tt := range []struct {
+		stmt mystmt.Stmt
+		text string
+	}{
+		{mystmt.Stmt{Text: text, File: "a.sql"}, "select 1, '${n}', $n;"},
+		{mystmt.Stmt{Text: text, File: "a.test"}, "select 1, '1', 1;"},
+		{mystmt.Stmt{Text: text, File: "a.inc", IncludedFrom: []string{"a.test"}}, "select 1, '1', 1;"},
+	} {
+		s, err := task.expandText(tt.stmt)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.text, s, tt.stmt.File)
+	}
+}
+
+func TestVarsAcrossStages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xsql")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cfg, dropDB := testmysql.DB(t)
+	defer dropDB()
+	files := map[string]string{
+		"setup.sql": "--let $n = `select 2`\nselect 1;\n",
+		"test.sql":  "--query k\nselect ${n} + ${m};\n",
+	}
+	for f, text := range files {
+		assert.NoError(t, ioutil.WriteFile(path.Join(dir, f), []byte(text), 0644))
+	}
+	x := &XSQLCase{Name: "vars", home: dir, DSNs: []string{cfg.FormatDSN()}, Labels: []string{"a"}, Vars: map[string]string{"m": "1"}}
+	x.Stages.Setup, x.Stages.Test = []string{"setup.sql"}, []string{"test.sql"}
+
+	rc := mycase.NewMemResultStore()
+	assert.NoError(t, rc.Setup(x.NewTask()))
+	assert.NoError(t, x.Setup(nil))
+	assert.NoError(t, x.Test(rc))
+	qrs, err := rc.Read("k")
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(qrs)) {
+		assert.Equal(t, "select 2 + 1;", qrs[0].SQL)
+	}
+	// reruns replay the statement with variables it ran with.
+	assert.NoError(t, x.Rerun(rc, "k"))
+	qrs, err = rc.Read("k")
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(qrs)) {
+		assert.Equal(t, "select 2 + 1;", qrs[0].SQL)
+	}
+	assert.NoError(t, x.Teardown())
+
+	// variables are reset by a new task
+	x.Stages.Setup = nil
+	assert.NoError(t, rc.Setup(x.NewTask()))
+	err = x.Test(rc)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "undefined variable: n")
+}
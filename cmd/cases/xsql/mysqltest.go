@@ -0,0 +1,305 @@
+package xsql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/errors"
+	"github.com/zyguan/mytest/mycase"
+	"github.com/zyguan/mytest/mysqltest"
+	"github.com/zyguan/mytest/mystmt"
+)
+
+// defaultConn is the name of the connection a task starts with.
+const defaultConn = "default"
+
+// isTestFile tells whether the file is a mysqltest .test file, whose outputs
+// are compared with its .result file.
+func isTestFile(file string) bool { return path.Ext(file) == ".test" }
+
+func splitFile(file string) (mystmt.Iterator, error) {
+	if isTestFile(file) {
+		return mystmt.SplitTestFile(file)
+	}
+	return mystmt.SplitFile(file)
+}
+
+// resultFileOf returns the .result file of the test, which is either next to
+// it or in the "r" directory next to its "t" directory like the mysql-test
+// suite, or an empty string if there is none.
+func resultFileOf(file string) string {
+	dir, name := path.Split(file)
+	name = strings.TrimSuffix(name, ".test") + ".result"
+	candidates := []string{path.Join(dir, name)}
+	if path.Base(dir) == "t" {
+		candidates = append(candidates, path.Join(path.Dir(path.Clean(dir)), "r", name))
+	}
+	for _, f := range candidates {
+		if _, err := os.Stat(f); err == nil {
+			return f
+		}
+	}
+	return ""
+}
+
+// directive applies the mysqltest directive, other commands are ignored.
+func (t *sqlTask) directive(ctx context.Context, cmd mystmt.Command, out *mysqltest.Writer) error {
+	if ok, err := t.mods.Add(cmd); ok {
+		return err
+	}
+	switch cmd.Name {
+	case "echo":
+		// mystmt keeps the rest of the line as the only arg of echo.
+		text := ""
+		if len(cmd.Args) > 0 {
+			text = cmd.Args[0]
+		}
+		out.Echo(text)
+	case "enable_query_log", "disable_query_log":
+		out.QueryLog = cmd.Name == "enable_query_log"
+	case "enable_result_log", "disable_result_log":
+		out.ResultLog = cmd.Name == "enable_result_log"
+	case "enable_warnings", "disable_warnings":
+		out.Warnings = cmd.Name == "enable_warnings"
+	case "connect":
+		return t.connect(ctx, cmd.Args)
+	case "connection":
+		if len(cmd.Args) == 0 || t.conns[cmd.Args[0]] == nil {
+			return errors.Errorf("unknown connection %v", cmd.Args)
+		}
+		t.conn = t.conns[cmd.Args[0]]
+	case "disconnect":
+		if len(cmd.Args) == 0 || t.conns[cmd.Args[0]] == nil || cmd.Args[0] == defaultConn {
+			return errors.Errorf("cannot disconnect %v", cmd.Args)
+		}
+		if t.conn == t.conns[cmd.Args[0]] {
+			t.conn = t.conns[defaultConn]
+		}
+		t.conns[cmd.Args[0]].Close()
+		delete(t.conns, cmd.Args[0])
+	case mystmt.CmdLet:
+		return t.let(ctx, cmd.Args)
+	}
+	return nil
+}
+
+// showWarnings writes warnings of the statement just run unless it's not from
+// a .test file, or warnings or the result log are disabled.
+func (t *sqlTask) showWarnings(ctx context.Context, stmt mystmt.Stmt, out *mysqltest.Writer) error {
+	if !isTestFile(stmt.Root()) || !out.Warnings || !out.ResultLog {
+		return nil
+	}
+	rs, err := queryResultSet(ctx, t.conn, "show warnings")
+	if err != nil {
+		return err
+	}
+	out.ShowWarnings(rs)
+	return nil
+}
+
+// let defines a variable. A value quoted by backticks is a query like mysqltest,
+// the first value of its result is assigned, which is empty if there is none.
+func (t *sqlTask) let(ctx context.Context, args []string) error {
+	name, value, err := mystmt.ParseLet(args)
+	if err != nil {
+		return err
+	}
+	if value, err = t.vars.ExpandArg(value); err != nil {
+		return err
+	}
+	if len(value) > 1 && value[0] == '`' && value[len(value)-1] == '`' {
+		rs, err := queryResultSet(ctx, t.conn, value[1:len(value)-1])
+		if err != nil {
+			return errors.Annotatef(err, "query value of $%s", name)
+		}
+		value = ""
+		if rs.NRows() > 0 && rs.NCols() > 0 {
+			value = "NULL"
+			if raw, _ := rs.RawValue(0, 0); raw != nil {
+				value = string(raw)
+			}
+		}
+	}
+	t.vars[name] = value
+	return nil
+}
+
+// connect opens a connection by args like "(name, host, user, password, db,
+// port)", omitted or empty ones but the name default to those of the dsn.
+func (t *sqlTask) connect(ctx context.Context, args []string) error {
+	ss, err := parseConnectArgs(args)
+	if err != nil {
+		return err
+	}
+	if t.conns[ss[0]] != nil {
+		return errors.Errorf("connection %s exists", ss[0])
+	}
+	cfg, err := connectConfig(t.dsn, ss)
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return errors.Annotate(err, "open db")
+	}
+	t.dbs = append(t.dbs, db)
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return errors.Annotate(err, "conn db")
+	}
+	t.conns[ss[0]], t.conn = conn, conn
+	return nil
+}
+
+// parseConnectArgs splits the parenthesized, comma separated args of connect,
+// spaces around every arg are trimmed.
+func parseConnectArgs(args []string) ([]string, error) {
+	s := strings.TrimSpace(strings.Join(args, " "))
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		return nil, errors.Errorf("connect args should be parenthesized: %q", s)
+	}
+	ss := strings.Split(s[1:len(s)-1], ",")
+	for i := range ss {
+		ss[i] = strings.TrimSpace(ss[i])
+	}
+	if len(ss[0]) == 0 {
+		return nil, errors.New("missing connection name")
+	}
+	return ss, nil
+}
+
+// connectConfig returns the config of the dsn overridden by args of connect.
+func connectConfig(dsn string, ss []string) (*mysql.Config, error) {
+	arg := func(i int) string {
+		if i < len(ss) {
+			return ss[i]
+		}
+		return ""
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, errors.Annotate(err, "parse dsn")
+	}
+	if host, port := arg(1), arg(5); len(host) > 0 || len(port) > 0 {
+		if cfg.Net != "tcp" {
+			return nil, errors.Errorf("cannot connect to %s:%s over %s of the dsn", host, port, cfg.Net)
+		}
+		h, p, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			return nil, errors.Annotate(err, "parse address of dsn")
+		}
+		if len(host) > 0 {
+			h = host
+		}
+		if len(port) > 0 {
+			p = port
+		}
+		cfg.Addr = net.JoinHostPort(h, p)
+	}
+	if user := arg(2); len(user) > 0 {
+		cfg.User, cfg.Passwd = user, arg(3)
+	}
+	if db := arg(4); len(db) > 0 {
+		cfg.DBName = db
+	}
+	return cfg, nil
+}
+
+// disconnectAll closes connections opened by connect directives.
+func (t *sqlTask) disconnectAll() {
+	for name, conn := range t.conns {
+		if name != defaultConn {
+			conn.Close()
+		}
+	}
+	for _, db := range t.dbs {
+		db.Close()
+	}
+}
+
+// output returns the writer of outputs of statements of the file, outputs are
+// only kept for mysqltest files.
+func (t *sqlTask) output(file string) *mysqltest.Writer {
+	w, ok := t.writers[file]
+	if ok {
+		return w
+	}
+	if isTestFile(file) {
+		t.outputs[file] = new(bytes.Buffer)
+		w = mysqltest.NewWriter(t.outputs[file])
+	} else {
+		w = mysqltest.NewWriter(ioutil.Discard)
+	}
+	t.writers[file] = w
+	return w
+}
+
+// echo returns the statement as it's written in the file, including comments
+// and the delimiter, with variables expanded, which is what mysqltest echoes.
+func (t *sqlTask) echo(stmt mystmt.Stmt) (string, error) {
+	src, ok := t.sources[stmt.File]
+	if !ok {
+		if raw, err := ioutil.ReadFile(stmt.File); err == nil {
+			src = string(raw)
+		}
+		t.sources[stmt.File] = src
+	}
+	if stmt.Start.Offset < stmt.End.Offset && stmt.End.Offset <= len(src) {
+		return t.vars.ExpandArg(src[stmt.Start.Offset:stmt.End.Offset])
+	}
+	return stmt.Text, nil
+}
+
+// checkResults compares outputs of mysqltest files of the test stage on every
+// dsn with their .result files, tests without .result files are not checked.
+// Every checked file is marked as a key of its path, it fails if any output
+// differs from the .result file.
+func (x *XSQLCase) checkResults(rc mycase.ResultStore, tasks []sqlTask) error {
+	for _, p := range x.Stages.Test {
+		f := path.Join(x.home, p)
+		if !isTestFile(f) {
+			continue
+		}
+		rf := resultFileOf(f)
+		if len(rf) == 0 {
+			continue
+		}
+		expected, err := ioutil.ReadFile(rf)
+		if err != nil {
+			return errors.Annotate(err, "read result file")
+		}
+		check := mycase.KeyCheck{Key: p, State: mycase.StateOK, Checker: "mysqltest", Time: time.Now()}
+		for i := range tasks {
+			if w := tasks[i].writers[f]; w != nil && w.Err() != nil {
+				return errors.Annotatef(w.Err(), "write output of %s on %s", p, tasks[i].source)
+			}
+			check.Sources = append(check.Sources, tasks[i].source)
+			actual := ""
+			if out := tasks[i].outputs[f]; out != nil {
+				actual = out.String()
+			}
+			if diff := mysqltest.Diff(string(expected), actual); len(diff) > 0 {
+				x.log.Info("unexpected output", "file", f, "result", rf, "source", tasks[i].source)
+				check.State = mycase.StateFail
+				check.Diffs = append(check.Diffs, mycase.DiffDetail{
+					Reference: rf,
+					Source:    tasks[i].source,
+					Message:   fmt.Sprintf("output of %s differs:\n%s", p, diff),
+				})
+			}
+		}
+		if err = rc.MarkCheck(check); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package xsql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zyguan/mytest/internal/testmysql"
+	"github.com/zyguan/mytest/mycase"
+	"github.com/zyguan/mytest/mysqltest"
+	"github.com/zyguan/mytest/mystmt"
+)
+
+func TestResultFileOf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xsql")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, f := range []string{"t/a.test", "r/a.result", "t/b.test", "t/b.result", "t/c.test"} {
+		assert.NoError(t, os.MkdirAll(path.Join(dir, path.Dir(f)), 0755))
+		assert.NoError(t, ioutil.WriteFile(path.Join(dir, f), nil, 0644))
+	}
+	assert.Equal(t, path.Join(dir, "r/a.result"), resultFileOf(path.Join(dir, "t/a.test")))
+	assert.Equal(t, path.Join(dir, "t/b.result"), resultFileOf(path.Join(dir, "t/b.test")))
+	assert.Equal(t, "", resultFileOf(path.Join(dir, "t/c.test")))
+}
+
+func TestCheckResults(t *testing.T) {
+	x := &XSQLCase{home: "fixtures/mysqltest", log: logger}
+	x.Stages.Test = []string{"t/basic.test"}
+	f := path.Join(x.home, "t/basic.test")
+	expected, err := ioutil.ReadFile(path.Join(x.home, "r/basic.result"))
+	assert.NoError(t, err)
+
+	tasks := make([]sqlTask, 2)
+	tasks[0].source, tasks[0].outputs = "a", map[string]*bytes.Buffer{f: bytes.NewBuffer(expected)}
+	tasks[1].source, tasks[1].outputs = "b", map[string]*bytes.Buffer{f: bytes.NewBufferString(strings.Replace(string(expected), "2\tx", "2\ty", 1))}
+	rc := mycase.NewMemResultStore()
+	assert.NoError(t, rc.Setup(mycase.TaskInfo{ID: "t1"}))
+	assert.NoError(t, x.checkResults(rc, tasks[:1]))
+	cs, err := rc.KeyChecks("t/basic.test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(cs))
+	assert.Equal(t, mycase.StateOK, cs[0].State)
+	assert.Equal(t, []string{"a"}, cs[0].Sources)
+
+	// mismatches fail the key of the file instead of the test.
+	assert.NoError(t, x.checkResults(rc, tasks))
+	cs, err = rc.KeyChecks("t/basic.test")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(cs))
+	assert.Equal(t, mycase.StateFail, cs[1].State)
+	assert.Equal(t, []string{"a", "b"}, cs[1].Sources)
+	if assert.Equal(t, 1, len(cs[1].Diffs)) {
+		d := cs[1].Diffs[0]
+		assert.Equal(t, "fixtures/mysqltest/r/basic.result", d.Reference)
+		assert.Equal(t, "b", d.Source)
+		assert.Contains(t, d.Message, "output of t/basic.test differs:\n")
+		assert.Contains(t, d.Message, "-2\tx\n+2\ty\n")
+	}
+
+	// outputs failed to be written are never compared.
+	w := mysqltest.NewWriter(failingWriter{})
+	w.Echo("x")
+	tasks[1].writers = map[string]*mysqltest.Writer{f: w}
+	err = x.checkResults(rc, tasks)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "write output of t/basic.test on b: disk full")
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestDirective(t *testing.T) {
+	task := &sqlTask{dsn: "root:@tcp(127.0.0.1:3306)/test", log: logger, conns: map[string]*sql.Conn{}, vars: mystmt.Vars{"w": "world"}}
+	var buf bytes.Buffer
+	out := mysqltest.NewWriter(&buf)
+	ctx := context.Background()
+	it := mystmt.SplitTestText("--echo hello  $w\n--disable_query_log\n--disable_warnings\n--sorted_result\n--error 1146\nlet $a = 1;\nlet $b = ${a}0 $w;\n")
+	for it.Scan() {
+		for _, cmd := range it.Stmt().Commands {
+			cmd, err := task.expandArgs(cmd)
+			assert.NoError(t, err)
+			assert.NoError(t, task.directive(ctx, cmd, out))
+		}
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, "hello  world\n", buf.String())
+	assert.False(t, out.QueryLog)
+	assert.False(t, out.Warnings)
+	assert.True(t, task.mods.Sorted)
+	assert.Equal(t, []mysqltest.ExpectedError{{Code: 1146}}, task.mods.Errors)
+	assert.Equal(t, mystmt.Vars{"w": "world", "a": "1", "b": "10 world"}, task.vars)
+
+	for _, cmd := range []mystmt.Command{
+		{Name: "error", Args: []string{"oops"}},
+		{Name: "connection", Args: []string{"con1"}},
+		{Name: "disconnect", Args: []string{defaultConn}},
+		{Name: "connect", Args: []string{"()"}},
+		{Name: "connect", Args: []string{"con1"}},
+		{Name: "connect", Args: []string{"(con1, host, root, , test"}},
+		{Name: "let", Args: []string{"$c"}},
+		{Name: "let", Args: []string{"$c", "=", "$undefined"}},
+	} {
+		assert.Error(t, task.directive(ctx, cmd, out), "%v", cmd)
+	}
+}
+
+func TestConnectConfig(t *testing.T) {
+	ss, err := parseConnectArgs([]string{"(con1, localhost, u 1,  p  w ,)"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"con1", "localhost", "u 1", "p  w", ""}, ss)
+
+	for _, tt := range []struct {
+		args string
+		dsn  string
+	}{
+		{"(con1)", "root:pw@tcp(127.0.0.1:4000)/test"},
+		{"(con1,,,,)", "root:pw@tcp(127.0.0.1:4000)/test"},
+		{"(con1, localhost)", "root:pw@tcp(localhost:4000)/test"},
+		{"(con1, , , , , 4001)", "root:pw@tcp(127.0.0.1:4001)/test"},
+		{"(con1, ::1, u1)", "u1@tcp([::1]:4000)/test"},
+		{"(con1, , u1, p1, db1)", "u1:p1@tcp(127.0.0.1:4000)/db1"},
+	} {
+		ss, err := parseConnectArgs([]string{tt.args})
+		assert.NoError(t, err, tt.args)
+		cfg, err := connectConfig("root:pw@tcp(127.0.0.1:4000)/test", ss)
+		assert.NoError(t, err, tt.args)
+		assert.Equal(t, tt.dsn, cfg.FormatDSN(), tt.args)
+	}
+
+	// hosts can't be honoured by dsns of unix sockets.
+	_, err = connectConfig("root@unix(/tmp/mysql.sock)/test", []string{"con1", "localhost"})
+	assert.Error(t, err)
+	cfg, err := connectConfig("root@unix(/tmp/mysql.sock)/test", []string{"con1", "", "u1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "u1@unix(/tmp/mysql.sock)/test", cfg.FormatDSN())
+}
+
+func TestRunTestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xsql")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cfg, dropDB := testmysql.DB(t)
+	defer dropDB()
+
+	run := func(text string) (*sqlTask, error) {
+		f := path.Join(dir, "a.test")
+		assert.NoError(t, ioutil.WriteFile(f, []byte(text), 0644))
+		task := &sqlTask{
+			dsn:       cfg.FormatDSN(),
+			source:    "a",
+			log:       logger,
+			stmtCh:    make(chan mystmt.Stmt, 64),
+			rc:        mycase.NewMemResultStore(),
+			availCMDs: []string{cmdQuery, cmdExecute},
+			vars:      mystmt.Vars{},
+		}
+		assert.NoError(t, task.rc.Setup(mycase.TaskInfo{ID: "t1"}))
+		it, err := splitFile(f)
+		assert.NoError(t, err)
+		for it.Scan() {
+			task.stmtCh <- it.Stmt()
+		}
+		assert.NoError(t, it.Err())
+		close(task.stmtCh)
+		return task, task.Run()
+	}
+
+	task, err := run(`create table t1 (a int, b varchar(8));
+insert into t1 values (2, 'x'), (1, null);
+--sorted_result
+select * from t1;
+--error ER_NO_SUCH_TABLE
+select * from t2;
+let $n = ` + "`select count(*) from t1`" + `;
+--echo # $n  rows
+select $n as n, '${n}' as s;
+--disable_query_log
+--query q1
+select a from t1 where a > 1;
+--enable_query_log
+drop table t1;
+`)
+	assert.NoError(t, err)
+	f := path.Join(dir, "a.test")
+	assert.Equal(t, `create table t1 (a int, b varchar(8));
+insert into t1 values (2, 'x'), (1, null);
+select * from t1;
+a	b
+1	NULL
+2	x
+select * from t2;
+ERROR 42S02: Table '`+cfg.DBName+`.t2' doesn't exist
+# 2  rows
+select 2 as n, '2' as s;
+n	s
+2	2
+a
+2
+drop table t1;
+`, task.outputs[f].String())
+	qrs, err := task.rc.Read("q1")
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(qrs)) {
+		v, _ := qrs[0].ResultSet.RawValue(0, 0)
+		assert.Equal(t, "2", string(v))
+		assert.Equal(t, "a", qrs[0].Source)
+	}
+
+	_, err = run("--execute e1\n--bench 3\ncreate table t2 (a int);\n")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "only statements run by --query can be benchmarked")
+}
+
+func TestRunTestFile_Warnings(t *testing.T) {
+	cfg, dropDB := testmysql.DB(t)
+	defer dropDB()
+	f := "fixtures/mysqltest/t/warnings.test"
+	expected, err := ioutil.ReadFile("fixtures/mysqltest/r/warnings.result")
+	assert.NoError(t, err)
+
+	task := &sqlTask{
+		dsn:    cfg.FormatDSN(),
+		source: "a",
+		log:    logger,
+		stmtCh: make(chan mystmt.Stmt, 64),
+		rc:     mycase.NewMemResultStore(),
+		vars:   mystmt.Vars{},
+	}
+	it, err := splitFile(f)
+	assert.NoError(t, err)
+	for it.Scan() {
+		task.stmtCh <- it.Stmt()
+	}
+	assert.NoError(t, it.Err())
+	close(task.stmtCh)
+	assert.NoError(t, task.Run())
+	assert.Equal(t, string(expected), task.outputs[f].String())
+}
@@ -4,7 +4,7 @@ go 1.13
 
 require (
 	github.com/go-logr/logr v0.1.0
-	github.com/go-sql-driver/mysql v1.4.1
+	github.com/go-sql-driver/mysql v1.7.0
 	github.com/google/uuid v1.1.1
 	github.com/pingcap/errors v0.11.4
 	github.com/stretchr/testify v1.4.0
@@ -0,0 +1,39 @@
+// Package testmysql provides fresh databases on the mysql server given by the
+// -mysql-dsn flag to tests, tests depending on it are skipped if it's empty.
+package testmysql
+
+import (
+	"database/sql"
+	"flag"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var dsn = flag.String("mysql-dsn", "", "mysql dsn for tests depending on mysql, they are skipped if it's empty")
+
+// DB creates a database and returns its config and a function dropping it.
+func DB(t testing.TB) (*mysql.Config, func()) {
+	if len(*dsn) == 0 {
+		t.Skip("no mysql dsn provided")
+	}
+	cfg, err := mysql.ParseDSN(*dsn)
+	if err != nil {
+		t.Fatalf("invalid mysql dsn: %v", err)
+	}
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		t.Fatalf("open mysql: dsn=%s, err=%v", *dsn, err)
+	}
+	cfg.DBName = "mytest_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if _, err = db.Exec("create database `" + cfg.DBName + "`"); err != nil {
+		db.Close()
+		t.Fatalf("create database: dsn=%s, err=%v", *dsn, err)
+	}
+	return cfg, func() {
+		db.Exec("drop database if exists `" + cfg.DBName + "`")
+		db.Close()
+	}
+}
@@ -0,0 +1,142 @@
+package mycase
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+
+	"github.com/zyguan/mytest/resultset"
+)
+
+// KnownFailure describes an accepted diff of a case. Key is a regular expression
+// matching keys of the case, and if Fingerprint is given, the diff must have the
+// same fingerprint as well.
+type KnownFailure struct {
+	Case        string `json:"case"`
+	Key         string `json:"key"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Ticket      string `json:"ticket,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+
+	key *regexp.Regexp
+}
+
+type Allowlist struct {
+	Entries []KnownFailure
+
+	mu    sync.Mutex
+	hits  map[int]int
+	cases map[string]bool
+}
+
+func NewAllowlist(entries ...KnownFailure) (*Allowlist, error) {
+	a := &Allowlist{Entries: entries, hits: make(map[int]int), cases: make(map[string]bool)}
+	for i := range a.Entries {
+		e := &a.Entries[i]
+		if len(e.Case) == 0 {
+			return nil, errors.New("allowlist: case is required")
+		}
+		p, err := regexp.Compile(e.Key)
+		if err != nil {
+			return nil, errors.New("allowlist: bad key pattern of " + e.Case + ": " + err.Error())
+		}
+		e.key = p
+	}
+	return a, nil
+}
+
+func LoadAllowlist(file string) (*Allowlist, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []KnownFailure
+	if err = json.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, errors.New("decode allowlist: " + err.Error())
+	}
+	return NewAllowlist(entries...)
+}
+
+// Match returns the entry accepting the diffs of the key.
+func (a *Allowlist) Match(name string, key string, diffs []error) (KnownFailure, bool) {
+	fp := DiffFingerprint(diffs)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cases[name] = true
+	for i, e := range a.Entries {
+		if e.Case != name || !e.key.MatchString(key) {
+			continue
+		}
+		if len(e.Fingerprint) > 0 && e.Fingerprint != fp {
+			continue
+		}
+		a.hits[i]++
+		return e, true
+	}
+	return KnownFailure{}, false
+}
+
+// Visit records that the case has been checked.
+func (a *Allowlist) Visit(name string) {
+	a.mu.Lock()
+	a.cases[name] = true
+	a.mu.Unlock()
+}
+
+// Stale returns entries of checked cases that matched nothing, which means the
+// failures they describe are gone.
+func (a *Allowlist) Stale() []KnownFailure {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var stale []KnownFailure
+	for i, e := range a.Entries {
+		if a.cases[e.Case] && a.hits[i] == 0 {
+			stale = append(stale, e)
+		}
+	}
+	return stale
+}
+
+// DiffFingerprint identifies a list of diff errors by what mismatches: reasons
+// of shape mismatches, positions and values of mismatched cells. Sources and
+// baselines involved are not taken into account.
+func DiffFingerprint(diffs []error) string {
+	h := sha1.New()
+	for _, err := range diffs {
+		for errors.Unwrap(err) != nil {
+			err = errors.Unwrap(err)
+		}
+		switch e := err.(type) {
+		case resultset.ShapeMismatch:
+			fmt.Fprintf(h, "shape %q", e.Reason)
+		case resultset.CellMismatch:
+			writeCell(h, e)
+		case resultset.DataMismatch:
+			for _, cm := range e {
+				writeCell(h, cm)
+			}
+		default:
+			fmt.Fprintf(h, "error %q", err.Error())
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+func writeCell(w io.Writer, cm resultset.CellMismatch) {
+	fmt.Fprintf(w, "cell %d:%d", cm.Pos[0], cm.Pos[1])
+	for _, v := range [][]byte{cm.Val1, cm.Val2} {
+		if v == nil {
+			fmt.Fprint(w, " NULL")
+		} else {
+			fmt.Fprintf(w, " %q", v)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package mycase
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zyguan/mytest/resultset"
+)
+
+func TestLoadAllowlist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mycase")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "allowlist.json")
+	assert.NoError(t, ioutil.WriteFile(f, []byte(`[{"case": "c", "key": "^k[12]$", "ticket": "#42"}]`), 0644))
+	a, err := LoadAllowlist(f)
+	assert.NoError(t, err)
+	e, ok := a.Match("c", "k1", []error{errors.New("oops")})
+	assert.True(t, ok)
+	assert.Equal(t, "#42", e.Ticket)
+	_, ok = a.Match("c", "k3", []error{errors.New("oops")})
+	assert.False(t, ok)
+
+	_, err = LoadAllowlist(filepath.Join(dir, "not_found.json"))
+	assert.Error(t, err)
+	assert.NoError(t, ioutil.WriteFile(f, []byte(`[{"case": "c", "key": "("}]`), 0644))
+	_, err = LoadAllowlist(f)
+	assert.Error(t, err)
+	_, err = NewAllowlist(KnownFailure{Key: "k"})
+	assert.Error(t, err)
+}
+
+func TestRun_Allowlist(t *testing.T) {
+	store, err := NewSQLiteResultStore(":memory:")
+	assert.NoError(t, err)
+	defer store.Close()
+
+	mc := &fakeCase{name: "c", results: map[string][]string{"k1": {"a", "b"}, "k2": {"a", "c"}, "k3": {"a", "b"}}}
+	err = Run(mc, store)
+	assert.Error(t, err)
+	var fp string
+	for i, key := range err.(*RunErrors).DiffKeys {
+		if key == "k3" {
+			fp = DiffFingerprint(err.(*RunErrors).DiffErrs[i : i+1])
+		}
+	}
+
+	a, err := NewAllowlist(
+		KnownFailure{Case: "c", Key: "^k[12]$", Fingerprint: fp},
+		KnownFailure{Case: "c", Key: "^k3$", Fingerprint: fp},
+		KnownFailure{Case: "c", Key: "^k4$"},
+		KnownFailure{Case: "other", Key: ".*"},
+	)
+	assert.NoError(t, err)
+	err = Run(mc, store, WithAllowlist(a))
+	assert.Error(t, err)
+	errs := err.(*RunErrors)
+	assert.Equal(t, []string{"k2"}, errs.DiffKeys)
+	ks, err := store.KeysByState(StateKnown)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"k1", "k3"}, ks)
+	stale := a.Stale()
+	assert.Equal(t, 1, len(stale))
+	assert.Equal(t, "^k4$", stale[0].Key)
+}
+
+func TestDiffFingerprint(t *testing.T) {
+	cells := resultset.DataMismatch{{Pos: [2]int{0, 1}, Val1: []byte("1"), Val2: nil, Assertion: resultset.RawBytesAssertion{}}}
+	fp := DiffFingerprint([]error{&SourceMismatch{Reference: "a", Source: "b", Err: cells}})
+	assert.Equal(t, fp, DiffFingerprint([]error{&SourceMismatch{Reference: "x", Source: "y", Err: cells}}))
+	assert.Equal(t, fp, DiffFingerprint([]error{&BaselineMismatch{Baseline: "t0", Source: "c", Err: cells}}))
+	assert.NotEqual(t, fp, DiffFingerprint([]error{resultset.DataMismatch{{Pos: [2]int{0, 1}, Val1: []byte("1"), Val2: []byte("")}}}))
+	assert.NotEqual(t, fp, DiffFingerprint([]error{resultset.DataMismatch{{Pos: [2]int{1, 0}, Val1: []byte("1"), Val2: nil}}}))
+
+	shape := resultset.ShapeMismatch{NRows1: 1, NRows2: 2, Reason: "rows: 1 <> 2"}
+	assert.Equal(t, DiffFingerprint([]error{shape}), DiffFingerprint([]error{&SourceMismatch{Reference: "a", Source: "b", Err: shape}}))
+	assert.NotEqual(t, fp, DiffFingerprint([]error{shape}))
+}
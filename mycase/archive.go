@@ -0,0 +1,274 @@
+package mycase
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/zyguan/mytest/resultset"
+)
+
+// ConflictPolicy decides what Import does if the task already exists in the
+// target store.
+type ConflictPolicy int
+
+const (
+	// ConflictFail refuses to import the task.
+	ConflictFail ConflictPolicy = iota
+	// ConflictRename imports the task under a new id like "<id>.1".
+	ConflictRename
+	// ConflictReplace replaces the existing task once the archive is imported.
+	ConflictReplace
+)
+
+// archiveRecord is a line of an archive, exactly one of its fields is set. An
+// archive starts with the task, followed by results and checks of its keys.
+type archiveRecord struct {
+	Task   *archivedTask   `json:"task,omitempty"`
+	Result *archivedResult `json:"result,omitempty"`
+	Check  *KeyCheck       `json:"check,omitempty"`
+}
+
+// archivedTask stores meta as a string, since it is not necessarily valid json.
+type archivedTask struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Meta string `json:"meta,omitempty"`
+	Time int64  `json:"time"`
+}
+
+type archivedResult struct {
+	Key        string      `json:"key"`
+	SQL        string      `json:"sql"`
+	Source     string      `json:"source,omitempty"`
+	Version    string      `json:"version,omitempty"`
+	SourceMeta string      `json:"source_meta,omitempty"`
+	Time       int64       `json:"time"`
+	Duration   float64     `json:"duration"`
+	Bench      *BenchStats `json:"bench,omitempty"`
+	ResultSet  []byte      `json:"result_set"`
+}
+
+// Export writes the task with all its results and key checks to w as json
+// lines, the current task of the store is left as is.
+func Export(rc ResultStore, taskID string, w io.Writer) error {
+	keys, _, err := taskKeyStates(rc, taskID)
+	if err != nil {
+		return err
+	}
+	info, err := rc.GetTask(taskID)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	task := archivedTask{ID: info.ID, Name: info.Name, Meta: string(info.Meta), Time: info.Time.Unix()}
+	if err = enc.Encode(archiveRecord{Task: &task}); err != nil {
+		return errors.New("export task: " + err.Error())
+	}
+	for _, key := range keys {
+		it, err := rc.Stream(taskID, key)
+		if err != nil {
+			return err
+		}
+		for it.Next() {
+			qr := it.Result()
+			rs, err := it.ResultSet()
+			if err != nil {
+				it.Close()
+				return err
+			}
+			r := archivedResult{
+				Key:        qr.Key,
+				SQL:        qr.SQL,
+				Source:     qr.Source,
+				Version:    qr.Version,
+				SourceMeta: string(qr.SourceMeta),
+				Time:       qr.Time.Unix(),
+				Duration:   qr.Duration,
+				Bench:      qr.Bench,
+			}
+			if r.ResultSet, err = rs.Encode(); err != nil {
+				it.Close()
+				return errors.New("encode result set: " + err.Error())
+			}
+			if err = enc.Encode(archiveRecord{Result: &r}); err != nil {
+				it.Close()
+				return errors.New("export result: " + err.Error())
+			}
+		}
+		it.Close()
+		checks, err := rc.TaskKeyChecks(taskID, key)
+		if err != nil {
+			return err
+		}
+		for i := range checks {
+			if err = enc.Encode(archiveRecord{Check: &checks[i]}); err != nil {
+				return errors.New("export check: " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// taskKeyStates returns keys having results or states in the task and states
+// of them.
+func taskKeyStates(rc ResultStore, taskID string) ([]string, map[string]string, error) {
+	if _, err := rc.GetTask(taskID); err != nil {
+		return nil, nil, err
+	}
+	keys, err := rc.TaskKeys(taskID)
+	if err != nil {
+		return nil, nil, err
+	}
+	sum, err := rc.TaskSummary(taskID)
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		seen[k] = true
+	}
+	states := make(map[string]string)
+	for state := range sum.States {
+		ks, err := rc.TaskKeysByState(taskID, state)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, k := range ks {
+			states[k] = state
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys, states, nil
+}
+
+// Import reads a task exported by Export from r and writes it to rc, it
+// returns the imported task whose id may differ from the exported one. Results
+// are written through the current task, so Import switches rc to the imported
+// task as a side effect.
+//
+// A task partially imported is dropped if the import fails. With
+// ConflictReplace, the existing task is renamed aside until the archive is
+// imported and restored if the import fails.
+func Import(rc ResultStore, r io.Reader, policy ConflictPolicy) (TaskInfo, error) {
+	dec := json.NewDecoder(r)
+	var rec archiveRecord
+	if err := dec.Decode(&rec); err != nil {
+		return TaskInfo{}, errors.New("import task: " + err.Error())
+	}
+	if rec.Task == nil {
+		return TaskInfo{}, errors.New("import task: archive does not start with a task")
+	}
+	info := TaskInfo{ID: rec.Task.ID, Name: rec.Task.Name, Time: time.Unix(rec.Task.Time, 0)}
+	if len(rec.Task.Meta) > 0 {
+		info.Meta = json.RawMessage(rec.Task.Meta)
+	}
+	id, replace, err := resolveTaskID(rc, info.ID, policy)
+	if err != nil {
+		return info, err
+	}
+	info.ID = id
+	var aside string
+	if replace {
+		aside = id + ".replacing." + strconv.FormatInt(time.Now().UnixNano(), 36)
+		if err = rc.RenameTask(id, aside); err != nil {
+			return info, err
+		}
+	}
+	if err = rc.Setup(info); err == nil {
+		err = importRecords(rc, dec)
+	}
+	if err != nil {
+		if cerr := rc.DeleteTask(id); cerr != nil {
+			return info, errors.New(err.Error() + "; drop imported task: " + cerr.Error())
+		}
+		if !replace {
+			return info, err
+		}
+		if rerr := rc.RenameTask(aside, id); rerr != nil {
+			return info, errors.New(err.Error() + "; restore task " + id + " from " + aside + ": " + rerr.Error())
+		}
+		return info, err
+	}
+	if replace {
+		if err = rc.DeleteTask(aside); err != nil {
+			return info, errors.New("drop replaced task " + aside + ": " + err.Error())
+		}
+	}
+	return info, nil
+}
+
+// importRecords writes results and checks read from dec to the current task.
+func importRecords(rc ResultStore, dec *json.Decoder) error {
+	for {
+		var rec archiveRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.New("import record: " + err.Error())
+		}
+		switch {
+		case rec.Result != nil:
+			r := rec.Result
+			qr := QueryResult{
+				Time:      time.Unix(r.Time, 0),
+				Duration:  r.Duration,
+				Bench:     r.Bench,
+				Key:       r.Key,
+				SQL:       r.SQL,
+				Source:    r.Source,
+				Version:   r.Version,
+				ResultSet: &resultset.ResultSet{},
+			}
+			if len(r.SourceMeta) > 0 {
+				qr.SourceMeta = json.RawMessage(r.SourceMeta)
+			}
+			if err = qr.ResultSet.Decode(r.ResultSet); err != nil {
+				return errors.New("decode result set: " + err.Error())
+			}
+			err = rc.Write(qr)
+		case rec.Check != nil:
+			err = rc.MarkCheck(*rec.Check)
+		default:
+			err = errors.New("import record: unexpected record")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return rc.Flush()
+}
+
+// resolveTaskID returns the id to import the task as, and whether the existing
+// task of the id should be replaced.
+func resolveTaskID(rc ResultStore, id string, policy ConflictPolicy) (string, bool, error) {
+	_, err := rc.GetTask(id)
+	if err == ErrTaskNotFound {
+		return id, false, nil
+	} else if err != nil {
+		return id, false, err
+	}
+	switch policy {
+	case ConflictRename:
+		for i := 1; ; i++ {
+			newID := id + "." + strconv.Itoa(i)
+			if _, err = rc.GetTask(newID); err == ErrTaskNotFound {
+				return newID, false, nil
+			} else if err != nil {
+				return id, false, err
+			}
+		}
+	case ConflictReplace:
+		return id, true, nil
+	default:
+		return id, false, ErrTaskExists
+	}
+}
@@ -0,0 +1,157 @@
+package mycase
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImport(t *testing.T) {
+	src, err := NewSQLiteResultStore(":memory:")
+	assert.NoError(t, err)
+	defer src.Close()
+	task := TaskInfo{ID: "t1", Name: "foo", Meta: json.RawMessage("dummy"), Time: time.Unix(1573430400, 0)}
+	assert.NoError(t, src.Setup(task))
+	a := testResult("k1", "a", "1")
+	a.SourceMeta = json.RawMessage(`{"x":1}`)
+	for _, qr := range []QueryResult{a, testResult("k1", "b", "2"), testResult("k2", "a")} {
+		assert.NoError(t, src.Write(qr))
+	}
+	assert.NoError(t, src.Mark("k1", StateOK))
+	assert.NoError(t, src.MarkCheck(KeyCheck{Key: "k1", State: StateFail, Sources: []string{"a", "b"}, Diffs: []DiffDetail{{Message: "oops"}}, Time: time.Unix(1573430460, 0)}))
+	assert.NoError(t, src.Mark("k3", "CUSTOM"))
+
+	assert.NoError(t, src.Setup(TaskInfo{ID: "t0"}))
+
+	var buf bytes.Buffer
+	assert.NoError(t, Export(src, "t1", &buf))
+	// exporting leaves the current task as is.
+	assert.Equal(t, "t0", src.CurrentTask.ID)
+	archive := buf.Bytes()
+	assert.Contains(t, string(archive), `{"check":{"key":"k1","state":"FAIL","sources":["a","b"],"diffs":[{`)
+
+	for name, open := range storeOpeners {
+		t.Run(name, func(t *testing.T) {
+			dst, closeStore := open(t)
+			defer closeStore()
+			got, err := Import(dst, bytes.NewReader(archive), ConflictFail)
+			assert.NoError(t, err)
+			assert.Equal(t, task, got)
+			tImported(t, src, dst, "t1")
+
+			_, err = Import(dst, bytes.NewReader(archive), ConflictFail)
+			assert.Equal(t, ErrTaskExists, err)
+
+			got, err = Import(dst, bytes.NewReader(archive), ConflictRename)
+			assert.NoError(t, err)
+			assert.Equal(t, "t1.1", got.ID)
+			tImported(t, src, dst, "t1.1")
+			got, err = Import(dst, bytes.NewReader(archive), ConflictRename)
+			assert.NoError(t, err)
+			assert.Equal(t, "t1.2", got.ID)
+
+			got, err = Import(dst, bytes.NewReader(archive), ConflictReplace)
+			assert.NoError(t, err)
+			assert.Equal(t, "t1", got.ID)
+			tImported(t, src, dst, "t1")
+
+			// the existing task is kept if the import fails
+			broken := append(append([]byte(nil), archive...), "{\"result\":{\"key\":\"k9\",\"result_set\":\"AA==\"}}\n"...)
+			_, err = Import(dst, bytes.NewReader(broken), ConflictReplace)
+			assert.Error(t, err)
+			tImported(t, src, dst, "t1")
+			tasks, err := dst.ListTasks(TaskFilter{})
+			assert.NoError(t, err)
+			assert.Equal(t, 3, len(tasks))
+
+			// a new task is dropped if the import fails.
+			_, err = Import(dst, bytes.NewReader(broken), ConflictRename)
+			assert.Error(t, err)
+			_, err = dst.GetTask("t1.3")
+			assert.Equal(t, ErrTaskNotFound, err)
+			tasks, err = dst.ListTasks(TaskFilter{})
+			assert.NoError(t, err)
+			assert.Equal(t, 3, len(tasks))
+		})
+	}
+
+	t.Run("FailedRename", func(t *testing.T) {
+		dst := &renameFailingStore{ResultStore: NewMemResultStore()}
+		_, err := Import(dst, bytes.NewReader(archive), ConflictFail)
+		assert.NoError(t, err)
+
+		// the existing task is left as is if it can't be renamed aside.
+		dst.fail = func(id string, newID string) bool { return id == "t1" }
+		_, err = Import(dst, bytes.NewReader(archive), ConflictReplace)
+		assert.Error(t, err)
+		tImported(t, src, dst, "t1")
+
+		// the replaced task is reported if it can't be dropped.
+		dst.fail = func(id string, newID string) bool { return false }
+		dst.failDelete = true
+		got, err := Import(dst, bytes.NewReader(archive), ConflictReplace)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "drop replaced task t1.replacing.")
+		assert.Equal(t, "t1", got.ID)
+		tImported(t, src, dst, "t1")
+		tasks, err := dst.ListTasks(TaskFilter{})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(tasks))
+
+		// the new task is reported if it can't be dropped.
+		broken := append(append([]byte(nil), archive...), "{}\n"...)
+		_, err = Import(dst, bytes.NewReader(broken), ConflictRename)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "import record: unexpected record; drop imported task: delete task: injected")
+	})
+
+	_, err = Import(NewMemResultStore(), bytes.NewReader(archive[bytes.IndexByte(archive, '\n')+1:]), ConflictFail)
+	assert.Error(t, err)
+}
+
+func tImported(t *testing.T, src ResultStore, dst ResultStore, id string) {
+	assert.NoError(t, src.Setup(TaskInfo{ID: "t1"}))
+	assert.NoError(t, dst.Setup(TaskInfo{ID: id}))
+	sum1, err := src.TaskSummary("t1")
+	assert.NoError(t, err)
+	sum2, err := dst.TaskSummary(id)
+	assert.NoError(t, err)
+	sum1.Info.ID = id
+	assert.Equal(t, sum1, sum2)
+	for _, key := range []string{"k1", "k2", "k3"} {
+		qrs1, err := src.Read(key)
+		assert.NoError(t, err)
+		qrs2, err := dst.Read(key)
+		assert.NoError(t, err)
+		assert.Equal(t, qrs1, qrs2, key)
+		cs1, err := src.KeyChecks(key)
+		assert.NoError(t, err)
+		cs2, err := dst.KeyChecks(key)
+		assert.NoError(t, err)
+		assert.Equal(t, cs1, cs2, key)
+	}
+}
+
+type renameFailingStore struct {
+	ResultStore
+	fail       func(id string, newID string) bool
+	failDelete bool
+}
+
+func (s *renameFailingStore) RenameTask(id string, newID string) error {
+	if s.fail != nil && s.fail(id, newID) {
+		return errors.New("rename task: injected")
+	}
+	return s.ResultStore.RenameTask(id, newID)
+}
+
+func (s *renameFailingStore) DeleteTask(id string) error {
+	if s.failDelete {
+		return errors.New("delete task: injected")
+	}
+	return s.ResultStore.DeleteTask(id)
+}
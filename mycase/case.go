@@ -2,6 +2,7 @@ package mycase
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
 	"time"
@@ -17,14 +18,42 @@ type TaskInfo struct {
 }
 
 type QueryResult struct {
-	Time      time.Time
-	Duration  float64
-	Key       string
-	SQL       string
-	Version   string
+	Time       time.Time
+	Duration   float64
+	Key        string
+	SQL        string
+	Source     string
+	Version    string
+	SourceMeta json.RawMessage
+	// Bench summarizes repeated runs of a benchmarked query, Duration is the
+	// median of them then.
+	Bench     *BenchStats
 	ResultSet *resultset.ResultSet
 }
 
+// SourceID identifies the source which produced the result, it's the source
+// label if there is one, otherwise the server version.
+func (r QueryResult) SourceID() string {
+	if len(r.Source) > 0 {
+		return r.Source
+	}
+	return r.Version
+}
+
+// GroupBySource splits results into runs of the same source, results returned by
+// ResultStore.Read are already ordered by source.
+func GroupBySource(qrs []QueryResult) [][]QueryResult {
+	var groups [][]QueryResult
+	for i, qr := range qrs {
+		if i > 0 && qrs[i-1].SourceID() == qr.SourceID() {
+			groups[len(groups)-1] = append(groups[len(groups)-1], qr)
+			continue
+		}
+		groups = append(groups, []QueryResult{qr})
+	}
+	return groups
+}
+
 type MyCase interface {
 	NewTask() TaskInfo
 	Checkers() map[string]resultset.Checker
@@ -33,6 +62,12 @@ type MyCase interface {
 	Test(rc ResultStore) error
 }
 
+// Rerunner is implemented by cases able to execute the statement of a key again
+// and write new results of it to the store.
+type Rerunner interface {
+	Rerun(rc ResultStore, key string) error
+}
+
 type GlobalCheckMode string
 
 const (
@@ -86,12 +121,97 @@ func WithGlobalCheckers(checkers ...GlobalChecker) RunOption {
 	}
 }
 
+// WithBaseline additionally compares results of the current task against the
+// ones recorded by the given task.
+func WithBaseline(taskID string) RunOption {
+	return func(opts RunOptions) RunOptions {
+		opts.Baseline = taskID
+		return opts
+	}
+}
+
+// WithReference selects the first source whose label or version matches pattern
+// as the reference of cross-source checks, the first source is used if none matches.
+func WithReference(pattern string) RunOption {
+	match := func(QueryResult) bool { return false }
+	if p, err := regexp.Compile(pattern); err == nil {
+		match = func(r QueryResult) bool { return p.MatchString(r.Source) || p.MatchString(r.Version) }
+	}
+	return func(opts RunOptions) RunOptions {
+		opts.Reference = match
+		return opts
+	}
+}
+
+// WithRetry re-runs a failed key up to n times before marking it as failed, the
+// backoff doubles after every attempt. Keys passing after a retry are marked as
+// flaky. It only takes effect on cases implementing Rerunner.
+func WithRetry(n int, backoff time.Duration) RunOption {
+	return func(opts RunOptions) RunOptions {
+		opts.Retries = n
+		opts.RetryBackoff = backoff
+		return opts
+	}
+}
+
+// WithAllowlist marks failed keys accepted by the allowlist as known failures
+// instead of reporting their diffs.
+func WithAllowlist(a *Allowlist) RunOption {
+	return func(opts RunOptions) RunOptions {
+		opts.Allowlist = a
+		return opts
+	}
+}
+
+// WithPerfCheck compares durations of every checked key across sources and
+// against the baseline, the outcome is recorded in KeyCheck.Perf and does not
+// affect the state of the key.
+func WithPerfCheck(po PerfOptions) RunOption {
+	return func(opts RunOptions) RunOptions {
+		opts.Perf = &po
+		return opts
+	}
+}
+
 type RunOptions struct {
 	CaseArgs        json.RawMessage
 	GlobalCheckMode GlobalCheckMode
 	GlobalCheckers  []GlobalChecker
+	Baseline        string
+	Reference       func(QueryResult) bool
+	Retries         int
+	RetryBackoff    time.Duration
+	Allowlist       *Allowlist
+	Perf            *PerfOptions
+}
+
+type SourceMismatch struct {
+	Reference string
+	Source    string
+	Err       error
 }
 
+func (e *SourceMismatch) Error() string {
+	return fmt.Sprintf("%s <> %s: %s", e.Reference, e.Source, e.Err.Error())
+}
+
+func (e *SourceMismatch) Unwrap() error { return e.Err }
+
+// errNoResult is the error of baseline results without the counterpart.
+var errNoResult = errors.New("no result in the current task")
+
+type BaselineMismatch struct {
+	Baseline string
+	Source   string
+	Err      error
+}
+
+func (e *BaselineMismatch) Error() string {
+	return fmt.Sprintf("baseline %s (%s): %s", e.Baseline, e.Source, e.Err.Error())
+}
+
+func (e *BaselineMismatch) Unwrap() error { return e.Err }
+
 type RunErrors struct {
 	Info      TaskInfo
 	Stage     string
@@ -99,6 +219,9 @@ type RunErrors struct {
 	DiffKeys  []string
 	DiffErrs  []error
 	StoreErrs []error
+	// Classes groups the sources of every failed key into sets of sources that
+	// agree with each other, the class of the reference comes first.
+	Classes map[string][][]string
 }
 
 func (e *RunErrors) Error() string {
@@ -128,8 +251,10 @@ func (e *RunErrors) NoError() bool {
 }
 
 const (
-	StateOK   = "OK"
-	StateFail = "FAIL"
+	StateOK    = "OK"
+	StateFail  = "FAIL"
+	StateFlaky = "FLAKY"
+	StateKnown = "KNOWN"
 
 	StageSetup    = "SETUP"
 	StageTest     = "TEST"
@@ -161,30 +286,123 @@ func Run(mc MyCase, rc ResultStore, opts ...RunOption) error {
 		errs.ExecErr = err
 		return errs
 	}
+	if err := rc.Flush(); err != nil {
+		errs.StoreErrs = append(errs.StoreErrs, err)
+		return errs
+	}
 
 	errs.Stage = StageCheck
 	checked := make(map[string]bool)
+	if o.Allowlist != nil {
+		o.Allowlist.Visit(info.Name)
+	}
+
+	// keys failed by checks the case marked itself during the test fail the
+	// case like keys failed by checkers.
+	failed, err := rc.KeysByState(StateFail)
+	if err != nil {
+		errs.StoreErrs = append(errs.StoreErrs, err)
+		return errs
+	}
+	for _, key := range failed {
+		cs, err := rc.KeyChecks(key)
+		if err != nil {
+			errs.StoreErrs = append(errs.StoreErrs, err)
+			continue
+		}
+		checked[key] = true
+		var diffs []DiffDetail
+		if len(cs) > 0 {
+			diffs = cs[len(cs)-1].Diffs
+		}
+		if len(diffs) == 0 {
+			diffs = []DiffDetail{{Message: "marked as failed by the case"}}
+		}
+		for _, d := range diffs {
+			errs.DiffErrs = append(errs.DiffErrs, errors.New(d.String()))
+			errs.DiffKeys = append(errs.DiffKeys, key)
+		}
+	}
 
 	checkKey := func(checker resultset.Checker, key string) bool {
-		rs, err := rc.Read(key)
+		var (
+			base     []QueryResult
+			loadBase func(i int) (*resultset.ResultSet, error)
+		)
+		if len(o.Baseline) > 0 {
+			qrs, cur, err := streamKey(rc, o.Baseline, key)
+			if err != nil {
+				errs.StoreErrs = append(errs.StoreErrs, err)
+				return false
+			}
+			defer cur.Close()
+			base, loadBase = qrs, cur.Load
+		}
+		diff := func() (keyDiff, error) {
+			qrs, cur, err := streamKey(rc, info.ID, key)
+			if err != nil {
+				return keyDiff{}, err
+			}
+			defer cur.Close()
+			return diffKey(checker, qrs, cur.Load, base, loadBase, o)
+		}
+		kd, err := diff()
 		if err != nil {
 			errs.StoreErrs = append(errs.StoreErrs, err)
 			return false
 		}
-		if len(rs) <= 1 {
+		if len(kd.sources) <= 1 && len(base) == 0 {
 			return true
 		}
-		r0 := rs[0]
+		rr, canRerun := mc.(Rerunner)
 		state := StateOK
-		for i := 1; i < len(rs); i++ {
-			if err := checker.Diff(r0.ResultSet, rs[i].ResultSet); err != nil {
-				state = StateFail
+		for i := 0; len(kd.diffs) > 0 && canRerun && i < o.Retries; i++ {
+			time.Sleep(o.RetryBackoff << uint(i))
+			if err = rr.Rerun(rc, key); err == nil {
+				err = rc.Flush()
+			}
+			if err != nil {
+				errs.StoreErrs = append(errs.StoreErrs, err)
+				return false
+			}
+			if kd, err = diff(); err != nil {
+				errs.StoreErrs = append(errs.StoreErrs, err)
+				return false
+			}
+			if len(kd.diffs) == 0 {
+				state = StateFlaky
+			}
+		}
+		diffs, classes := kd.diffs, kd.classes
+		check := KeyCheck{Key: key, Checker: DescribeChecker(checker), Time: time.Now()}
+		check.Sources = kd.sources
+		if o.Perf != nil {
+			pc := AnalyzePerf(kd.results, kd.ref, base, o.Baseline, *o.Perf)
+			check.Perf = &pc
+		}
+		for _, err := range diffs {
+			check.Diffs = append(check.Diffs, DescribeDiff(err))
+		}
+		if len(diffs) > 0 && o.Allowlist != nil {
+			if _, ok := o.Allowlist.Match(info.Name, key, diffs); ok {
+				state, diffs = StateKnown, nil
+			}
+		}
+		if len(diffs) > 0 {
+			state = StateFail
+			for _, err := range diffs {
 				errs.DiffErrs = append(errs.DiffErrs, err)
 				errs.DiffKeys = append(errs.DiffKeys, key)
-				break
+			}
+			if len(classes) > 1 {
+				if errs.Classes == nil {
+					errs.Classes = make(map[string][][]string)
+				}
+				errs.Classes[key] = classes
 			}
 		}
-		if err = rc.Mark(key, state); err != nil {
+		check.State = state
+		if err = rc.MarkCheck(check); err != nil {
 			errs.StoreErrs = append(errs.StoreErrs, err)
 		}
 		return true
@@ -218,3 +436,140 @@ func Run(mc MyCase, rc ResultStore, opts ...RunOption) error {
 	}
 	return errs
 }
+
+// keyDiff is the outcome of comparing results of a key.
+type keyDiff struct {
+	sources []string
+	diffs   []error
+	classes [][]string
+	// results without result sets and index of the reference one.
+	results []QueryResult
+	ref     int
+}
+
+// diffKey compares results of a key across sources and against the baseline.
+// The reference is picked by metadata of results, then results are decoded by
+// load and compared with it one at a time, only the representatives of
+// equivalence classes are kept. Baseline results are loaded by loadBase.
+func diffKey(checker resultset.Checker, qrs []QueryResult, load func(i int) (*resultset.ResultSet, error), base []QueryResult, loadBase func(i int) (*resultset.ResultSet, error), o RunOptions) (keyDiff, error) {
+	kd := keyDiff{results: qrs}
+	pairs := newSourcePairs(base)
+	if len(qrs) == 0 {
+		kd.diffs = pairs.missing(o.Baseline)
+		return kd, nil
+	}
+	// fall back to the first result if none matches the reference.
+	for i, r := range qrs {
+		if o.Reference == nil || o.Reference(r) {
+			kd.ref = i
+			break
+		}
+	}
+	ref, err := load(kd.ref)
+	if err != nil {
+		return kd, err
+	}
+	reps, refID := []*resultset.ResultSet{ref}, qrs[kd.ref].SourceID()
+	kd.classes = [][]string{{refID}}
+	var baseErrs []error
+	for i, r := range qrs {
+		kd.sources = append(kd.sources, r.SourceID())
+		rs := ref
+		if i != kd.ref {
+			if rs, err = load(i); err != nil {
+				return kd, err
+			}
+		}
+		if j := pairs.next(r); j >= 0 {
+			b, err := loadBase(j)
+			if err != nil {
+				return kd, err
+			}
+			if err = diffResults(checker, b, rs); err != nil {
+				baseErrs = append(baseErrs, &BaselineMismatch{Baseline: o.Baseline, Source: r.SourceID(), Err: err})
+			}
+		}
+		if i == kd.ref {
+			continue
+		}
+		if err = diffResults(checker, ref, rs); err == nil {
+			kd.classes[0] = append(kd.classes[0], r.SourceID())
+			continue
+		}
+		kd.diffs = append(kd.diffs, &SourceMismatch{Reference: refID, Source: r.SourceID(), Err: err})
+		if k := classOf(checker, reps, rs); k > 0 {
+			kd.classes[k] = append(kd.classes[k], r.SourceID())
+		} else {
+			reps = append(reps, rs)
+			kd.classes = append(kd.classes, []string{r.SourceID()})
+		}
+	}
+	if len(kd.sources) <= 1 {
+		kd.classes = nil
+	}
+	kd.diffs = append(append(kd.diffs, baseErrs...), pairs.missing(o.Baseline)...)
+	return kd, nil
+}
+
+// classOf returns the index of the representative equal to rs except the
+// reference, or -1 if there is no such one.
+func classOf(checker resultset.Checker, reps []*resultset.ResultSet, rs *resultset.ResultSet) int {
+	for k := 1; k < len(reps); k++ {
+		if diffResults(checker, reps[k], rs) == nil {
+			return k
+		}
+	}
+	return -1
+}
+
+// diffResults compares rows of result sets by StreamDiff, results of
+// statements are compared by Diff.
+func diffResults(checker resultset.Checker, rs1 *resultset.ResultSet, rs2 *resultset.ResultSet) error {
+	if rs1.IsExecResult() || rs2.IsExecResult() {
+		return checker.Diff(rs1, rs2)
+	}
+	return checker.StreamDiff(rs1.Rows(), rs2.Rows())
+}
+
+// sourcePairs pairs results with baseline results of the same source, the n-th
+// result of a source is paired with the n-th baseline result of it. Results of
+// different sources are never paired.
+type sourcePairs struct {
+	base []QueryResult
+	nth  map[string]int
+	used []bool
+}
+
+func newSourcePairs(base []QueryResult) *sourcePairs {
+	return &sourcePairs{base: base, nth: make(map[string]int), used: make([]bool, len(base))}
+}
+
+// next returns the index of the baseline result paired with r, which follows
+// results paired before, or -1 if there is none.
+func (p *sourcePairs) next(r QueryResult) int {
+	id := r.SourceID()
+	n := p.nth[id]
+	p.nth[id]++
+	for j, b := range p.base {
+		if b.SourceID() != id {
+			continue
+		}
+		if n == 0 {
+			p.used[j] = true
+			return j
+		}
+		n--
+	}
+	return -1
+}
+
+// missing returns mismatches of baseline results which are not paired.
+func (p *sourcePairs) missing(baseline string) []error {
+	var errs []error
+	for j, b := range p.base {
+		if !p.used[j] {
+			errs = append(errs, &BaselineMismatch{Baseline: baseline, Source: b.SourceID(), Err: errNoResult})
+		}
+	}
+	return errs
+}
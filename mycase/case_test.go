@@ -0,0 +1,314 @@
+package mycase
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zyguan/mytest/resultset"
+)
+
+func TestRun_Baseline(t *testing.T) {
+	store, err := NewSQLiteResultStore(":memory:")
+	assert.NoError(t, err)
+	defer store.Close()
+
+	base := &fakeCase{name: "c", results: map[string][]string{"k1": {"a"}, "k2": {"b", "b"}}}
+	assert.NoError(t, Run(base, store))
+	baseID := store.CurrentTask.ID
+
+	// compare against itself
+	assert.NoError(t, Run(base, store, WithBaseline(baseID)))
+
+	// a single source is compared against the baseline
+	cur := &fakeCase{name: "c", results: map[string][]string{"k1": {"x"}, "k2": {"b", "b"}, "k3": {"c"}}}
+	err = Run(cur, store, WithBaseline(baseID))
+	assert.Error(t, err)
+	errs := err.(*RunErrors)
+	assert.Equal(t, []string{"k1"}, errs.DiffKeys)
+	var bm *BaselineMismatch
+	assert.True(t, errors.As(errs.DiffErrs[0], &bm))
+	assert.Equal(t, baseID, bm.Baseline)
+	assert.Equal(t, "v0", bm.Source)
+	assert.IsType(t, resultset.DataMismatch{}, bm.Err)
+	ks, err := store.KeysByState(StateFail)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"k1"}, ks)
+	ks, err = store.KeysByState(StateOK)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"k2"}, ks)
+
+	// no baseline, single source is not checked
+	assert.NoError(t, Run(cur, store))
+}
+
+// moreKeysCase checks keys the case may write no result of.
+type moreKeysCase struct {
+	*fakeCase
+	keys []string
+}
+
+func (c *moreKeysCase) Checkers() map[string]resultset.Checker {
+	cks := c.fakeCase.Checkers()
+	for _, k := range c.keys {
+		cks[k] = resultset.Checker{Assertions: []resultset.ValueAssertion{resultset.RawBytesAssertion{}}}
+	}
+	return cks
+}
+
+func TestRun_BaselineRegressions(t *testing.T) {
+	store, err := NewSQLiteResultStore(":memory:")
+	assert.NoError(t, err)
+	defer store.Close()
+
+	base := &fakeCase{name: "c", results: map[string][]string{"k1": {"a"}, "k2": {"a", "b", "c"}, "k3": {"a", "b"}}}
+	assert.Error(t, Run(base, store))
+	baseID := store.CurrentTask.ID
+
+	// k1 is missing, v1 and v2 of k2 regress, v1 of k3 is missing.
+	cur := &moreKeysCase{fakeCase: &fakeCase{name: "c", results: map[string][]string{"k2": {"a", "x", "y"}, "k3": {"a"}}}, keys: []string{"k1"}}
+	err = Run(cur, store, WithBaseline(baseID))
+	assert.Error(t, err)
+	errs := err.(*RunErrors)
+	byKey := make(map[string][]string)
+	for i, k := range errs.DiffKeys {
+		var bm *BaselineMismatch
+		if errors.As(errs.DiffErrs[i], &bm) {
+			byKey[k] = append(byKey[k], bm.Source+": "+bm.Err.Error())
+		}
+	}
+	assert.Equal(t, []string{"v0: no result in the current task"}, byKey["k1"])
+	assert.Equal(t, 2, len(byKey["k2"]))
+	assert.Contains(t, byKey["k2"][0], "v1: ")
+	assert.Contains(t, byKey["k2"][1], "v2: ")
+	assert.Equal(t, []string{"v1: no result in the current task"}, byKey["k3"])
+	ks, err := store.KeysByState(StateFail)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"k1", "k2", "k3"}, ks)
+}
+
+func TestRun_Reference(t *testing.T) {
+	store, err := NewSQLiteResultStore(":memory:")
+	assert.NoError(t, err)
+	defer store.Close()
+
+	mc := &fakeCase{name: "c", results: map[string][]string{"k1": {"a", "b", "b", "c"}, "k2": {"x", "x"}}}
+	err = Run(mc, store)
+	assert.Error(t, err)
+	errs := err.(*RunErrors)
+	assert.Equal(t, []string{"k1", "k1", "k1"}, errs.DiffKeys)
+	assert.Equal(t, [][]string{{"v0"}, {"v1", "v2"}, {"v3"}}, errs.Classes["k1"])
+	for i, v := range []string{"v1", "v2", "v3"} {
+		sm := errs.DiffErrs[i].(*SourceMismatch)
+		assert.Equal(t, "v0", sm.Reference)
+		assert.Equal(t, v, sm.Source)
+	}
+	assert.NotContains(t, errs.Classes, "k2")
+
+	err = Run(mc, store, WithReference("^v[12]$"))
+	assert.Error(t, err)
+	errs = err.(*RunErrors)
+	assert.Equal(t, 2, len(errs.DiffErrs))
+	assert.Equal(t, [][]string{{"v1", "v2"}, {"v0"}, {"v3"}}, errs.Classes["k1"])
+
+	// fallback to the first source
+	err = Run(mc, store, WithReference("("))
+	assert.Error(t, err)
+	assert.Equal(t, "v0", err.(*RunErrors).Classes["k1"][0][0])
+}
+
+// countingStore counts streams opened and result sets loaded of every task.
+type countingStore struct {
+	*SQLiteResultStore
+	streams map[string]int
+	loads   map[string]int
+}
+
+func (s *countingStore) Stream(taskID string, key string) (ResultIterator, error) {
+	it, err := s.SQLiteResultStore.Stream(taskID, key)
+	if err != nil {
+		return nil, err
+	}
+	s.streams[taskID]++
+	return &countingIter{ResultIterator: it, loads: s.loads, taskID: taskID}, nil
+}
+
+type countingIter struct {
+	ResultIterator
+	loads  map[string]int
+	taskID string
+}
+
+func (it *countingIter) ResultSet() (*resultset.ResultSet, error) {
+	it.loads[it.taskID]++
+	return it.ResultIterator.ResultSet()
+}
+
+func TestRun_Streaming(t *testing.T) {
+	sqlite, err := NewSQLiteResultStore(":memory:")
+	assert.NoError(t, err)
+	defer sqlite.Close()
+	store := &countingStore{SQLiteResultStore: sqlite, streams: make(map[string]int), loads: make(map[string]int)}
+
+	mc := &fakeCase{name: "c", results: map[string][]string{"k": {"a", "b", "b", "a"}}}
+	assert.Error(t, Run(mc, store))
+	baseID := store.CurrentTask.ID
+	assert.Equal(t, 2, store.streams[baseID])
+	assert.Equal(t, 4, store.loads[baseID])
+
+	err = Run(mc, store, WithBaseline(baseID), WithReference("^v2$"))
+	assert.Error(t, err)
+	errs := err.(*RunErrors)
+	assert.Equal(t, [][]string{{"v2", "v1"}, {"v0", "v3"}}, errs.Classes["k"])
+	// the stream is reopened once to load results before the reference.
+	assert.Equal(t, 3, store.streams[store.CurrentTask.ID])
+	assert.Equal(t, 4, store.loads[store.CurrentTask.ID])
+	assert.Equal(t, 4, store.streams[baseID])
+	assert.Equal(t, 8, store.loads[baseID])
+}
+
+type rerunCase struct {
+	fakeCase
+	reruns  map[string][][]string
+	nReruns int
+}
+
+func (c *rerunCase) Rerun(rc ResultStore, key string) error {
+	c.nReruns++
+	if len(c.reruns[key]) == 0 {
+		return nil
+	}
+	vs := c.reruns[key][0]
+	c.reruns[key] = c.reruns[key][1:]
+	return (&fakeCase{results: map[string][]string{key: vs}}).Test(replacingStore{rc})
+}
+
+// replacingStore writes results like reruns.
+type replacingStore struct{ ResultStore }
+
+func (s replacingStore) Write(res QueryResult) error { return s.Replace(res) }
+
+func TestRun_Retry(t *testing.T) {
+	store, err := NewSQLiteResultStore(":memory:")
+	assert.NoError(t, err)
+	defer store.Close()
+
+	mc := &rerunCase{
+		fakeCase: fakeCase{name: "c", results: map[string][]string{"k1": {"a", "b"}, "k2": {"a", "b"}, "k3": {"a", "a"}}},
+		reruns: map[string][][]string{
+			"k1": {{"a", "c"}, {"a", "a"}},
+			"k2": {{"a", "c"}, {"a", "d"}},
+		},
+	}
+	err = Run(mc, store, WithRetry(2, time.Millisecond))
+	assert.Error(t, err)
+	assert.Equal(t, 4, mc.nReruns)
+	errs := err.(*RunErrors)
+	assert.Equal(t, []string{"k2"}, errs.DiffKeys)
+	assert.Contains(t, errs.DiffErrs[0].Error(), "v0 <> v1")
+	for state, keys := range map[string][]string{StateOK: {"k3"}, StateFlaky: {"k1"}, StateFail: {"k2"}} {
+		ks, err := store.KeysByState(state)
+		assert.NoError(t, err)
+		assert.Equal(t, keys, ks, state)
+	}
+	// results of reruns replace earlier ones
+	for key, vs := range map[string][]string{"k1": {"a", "a"}, "k2": {"a", "d"}, "k3": {"a", "a"}} {
+		qrs, err := store.Read(key)
+		assert.NoError(t, err)
+		if assert.Equal(t, len(vs), len(qrs), key) {
+			for i, qr := range qrs {
+				v, _ := qr.ResultSet.RawValue(0, 0)
+				assert.Equal(t, vs[i], string(v), key)
+			}
+		}
+	}
+
+	// cases without Rerun are never retried
+	err = Run(&mc.fakeCase, store, WithRetry(2, time.Millisecond))
+	assert.Equal(t, []string{"k1", "k2"}, sorted(err.(*RunErrors).DiffKeys))
+}
+
+func sorted(xs []string) []string {
+	sort.Strings(xs)
+	return xs
+}
+
+func TestRun_KeyChecks(t *testing.T) {
+	store, err := NewSQLiteResultStore(":memory:")
+	assert.NoError(t, err)
+	defer store.Close()
+
+	mc := &fakeCase{name: "c", results: map[string][]string{"k1": {"a", "b", "a"}}}
+	assert.Error(t, Run(mc, store))
+	cs, err := store.KeyChecks("k1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(cs))
+	assert.Equal(t, StateFail, cs[0].State)
+	assert.Equal(t, []string{"v0", "v1", "v2"}, cs[0].Sources)
+	assert.Equal(t, DescribeChecker(mc.Checkers()["k1"]), cs[0].Checker)
+	assert.Equal(t, "schema=false precision=false fail_fast=false assertions=[raw]", cs[0].Checker)
+	assert.Equal(t, 1, len(cs[0].Diffs))
+	d := cs[0].Diffs[0]
+	assert.Equal(t, "v0", d.Reference)
+	assert.Equal(t, "v1", d.Source)
+	assert.Equal(t, 1, len(d.Cells))
+	assert.Equal(t, "a", *d.Cells[0].Val1)
+	assert.Equal(t, "b", *d.Cells[0].Val2)
+	assert.Equal(t, "v0 <> v1: 1 cells mismatch\n[0:0] \"a\" <> \"b\" by resultset.RawBytesAssertion", d.String())
+}
+
+// markingCase marks checks of keys itself during the test.
+type markingCase struct {
+	fakeCase
+	checks []KeyCheck
+}
+
+func (c *markingCase) Test(rc ResultStore) error {
+	if err := c.fakeCase.Test(rc); err != nil {
+		return err
+	}
+	for _, check := range c.checks {
+		if err := rc.MarkCheck(check); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestRun_MarkedChecks(t *testing.T) {
+	store, err := NewSQLiteResultStore(":memory:")
+	assert.NoError(t, err)
+	defer store.Close()
+
+	mc := &markingCase{
+		fakeCase: fakeCase{name: "c", results: map[string][]string{"k1": {"a", "a"}}},
+		checks: []KeyCheck{
+			{Key: "f1", State: StateOK, Time: time.Now()},
+			{Key: "f2", State: StateFail, Diffs: []DiffDetail{{Reference: "r", Source: "a", Message: "oops"}}, Time: time.Now()},
+			{Key: "f3", State: StateFail, Time: time.Now()},
+		},
+	}
+	err = Run(mc, store)
+	assert.Error(t, err)
+	assert.Equal(t, OutcomeFail, Outcome(err))
+	errs := err.(*RunErrors)
+	assert.Equal(t, []string{"f2", "f3"}, errs.DiffKeys)
+	assert.Equal(t, "r <> a: oops", errs.DiffErrs[0].Error())
+	assert.Equal(t, "marked as failed by the case", errs.DiffErrs[1].Error())
+	ks, err := store.KeysByState(StateOK)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"f1", "k1"}, ks)
+
+	mc.checks = mc.checks[:1]
+	assert.NoError(t, Run(mc, store))
+}
+
+func TestDescribeChecker(t *testing.T) {
+	c := resultset.Checker{CheckSchema: true, FailFast: true, Assertions: []resultset.ValueAssertion{
+		resultset.FloatAssertion{Columns: []int{1, 2}, TypeNames: []string{"DOUBLE", "FLOAT"}, Delta: 0.001},
+		resultset.RawBytesAssertion{},
+	}}
+	assert.Equal(t, "schema=true precision=false fail_fast=true assertions=[float(delta=0.001,columns=[1 2],types=DOUBLE|FLOAT) raw]", DescribeChecker(c))
+}
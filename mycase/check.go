@@ -0,0 +1,139 @@
+package mycase
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zyguan/mytest/resultset"
+)
+
+// KeyCheck records how a key was checked.
+type KeyCheck struct {
+	Key     string       `json:"key"`
+	State   string       `json:"state"`
+	Checker string       `json:"checker,omitempty"`
+	Sources []string     `json:"sources,omitempty"`
+	Diffs   []DiffDetail `json:"diffs,omitempty"`
+	Perf    *PerfCheck   `json:"perf,omitempty"`
+	Time    time.Time    `json:"time"`
+}
+
+// inState tells whether the check is in the state, see KeysByState.
+func (c KeyCheck) inState(state string) bool {
+	if state == StateSlow {
+		return c.Perf != nil && c.Perf.State == StateSlow
+	}
+	return c.State == state
+}
+
+type CellDiff struct {
+	Row       int     `json:"row"`
+	Col       int     `json:"col"`
+	Val1      *string `json:"val1"`
+	Val2      *string `json:"val2"`
+	Assertion string  `json:"assertion"`
+}
+
+// DiffDetail is the serializable form of a diff error.
+type DiffDetail struct {
+	Reference string     `json:"reference,omitempty"`
+	Source    string     `json:"source,omitempty"`
+	Baseline  string     `json:"baseline,omitempty"`
+	Message   string     `json:"message"`
+	Shape     string     `json:"shape,omitempty"`
+	Cells     []CellDiff `json:"cells,omitempty"`
+}
+
+func (d DiffDetail) String() string {
+	var b strings.Builder
+	if len(d.Baseline) > 0 {
+		fmt.Fprintf(&b, "baseline %s (%s): ", d.Baseline, d.Source)
+	} else if len(d.Reference) > 0 {
+		fmt.Fprintf(&b, "%s <> %s: ", d.Reference, d.Source)
+	}
+	if len(d.Shape) > 0 {
+		b.WriteString("shape mismatch: " + d.Shape)
+	} else {
+		b.WriteString(d.Message)
+	}
+	for _, c := range d.Cells {
+		fmt.Fprintf(&b, "\n[%d:%d] %s <> %s by %s", c.Row, c.Col, quoteVal(c.Val1), quoteVal(c.Val2), c.Assertion)
+	}
+	return b.String()
+}
+
+// DescribeDiff converts an error returned by Checker.Diff, maybe wrapped by
+// SourceMismatch or BaselineMismatch, to its serializable form.
+func DescribeDiff(err error) DiffDetail {
+	var d DiffDetail
+	var sm *SourceMismatch
+	if errors.As(err, &sm) {
+		d.Reference, d.Source = sm.Reference, sm.Source
+		err = sm.Err
+	}
+	var bm *BaselineMismatch
+	if errors.As(err, &bm) {
+		d.Baseline, d.Source = bm.Baseline, bm.Source
+		err = bm.Err
+	}
+	d.Message = err.Error()
+	switch e := err.(type) {
+	case resultset.ShapeMismatch:
+		d.Shape = e.Reason
+	case resultset.CellMismatch:
+		d.Cells = []CellDiff{cellDiff(e)}
+	case resultset.DataMismatch:
+		d.Cells = make([]CellDiff, len(e))
+		for i, cm := range e {
+			d.Cells[i] = cellDiff(cm)
+		}
+	}
+	return d
+}
+
+// DescribeChecker describes options and assertions of the checker explicitly,
+// so that descriptions stay the same as long as the checker behaves the same.
+func DescribeChecker(c resultset.Checker) string {
+	as := make([]string, len(c.Assertions))
+	for i, a := range c.Assertions {
+		switch a := a.(type) {
+		case resultset.RawBytesAssertion:
+			as[i] = "raw"
+		case resultset.FloatAssertion:
+			as[i] = fmt.Sprintf("float(delta=%s,columns=%v,types=%s)",
+				strconv.FormatFloat(a.Delta, 'g', -1, 64), a.Columns, strings.Join(a.TypeNames, "|"))
+		default:
+			as[i] = fmt.Sprintf("%T", a)
+		}
+	}
+	return fmt.Sprintf("schema=%t precision=%t fail_fast=%t assertions=[%s]",
+		c.CheckSchema, c.CheckPrecision, c.FailFast, strings.Join(as, " "))
+}
+
+func cellDiff(cm resultset.CellMismatch) CellDiff {
+	return CellDiff{
+		Row:       cm.Pos[0],
+		Col:       cm.Pos[1],
+		Val1:      rawVal(cm.Val1),
+		Val2:      rawVal(cm.Val2),
+		Assertion: fmt.Sprintf("%T", cm.Assertion),
+	}
+}
+
+func rawVal(v []byte) *string {
+	if v == nil {
+		return nil
+	}
+	s := string(v)
+	return &s
+}
+
+func quoteVal(v *string) string {
+	if v == nil {
+		return "NULL"
+	}
+	return fmt.Sprintf("%q", *v)
+}
@@ -0,0 +1,146 @@
+package mycase
+
+import (
+	"sort"
+
+	"github.com/zyguan/mytest/resultset"
+)
+
+// DefaultChecker compares raw bytes of every cell, CompareTasks uses it for keys
+// without a checker.
+var DefaultChecker = resultset.Checker{Assertions: []resultset.ValueAssertion{resultset.RawBytesAssertion{}}}
+
+// KeyChange describes how a key changed between two tasks.
+type KeyChange struct {
+	Key      string
+	OldState string
+	NewState string
+	Diffs    []DiffDetail
+}
+
+// TaskDiff is the outcome of CompareTasks. Keys in NewlyFailing, NewlyPassing
+// and DataChanged exist in both tasks, a key may show up in several of them.
+type TaskDiff struct {
+	Old          TaskInfo
+	New          TaskInfo
+	Added        []string
+	Removed      []string
+	NewlyFailing []KeyChange
+	NewlyPassing []KeyChange
+	DataChanged  []KeyChange
+}
+
+func (d *TaskDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.NewlyFailing) == 0 && len(d.NewlyPassing) == 0 && len(d.DataChanged) == 0
+}
+
+// CompareTasks walks the union of keys of two tasks and compares results of
+// every key source by source. A key is checked by its checker in checkers, the
+// first available global checker or DefaultChecker. The current task of the
+// store is left as is.
+func CompareTasks(rc ResultStore, oldID string, newID string, checkers map[string]resultset.Checker, globals ...GlobalChecker) (*TaskDiff, error) {
+	var (
+		d   TaskDiff
+		err error
+	)
+	if d.Old, err = rc.GetTask(oldID); err != nil {
+		return nil, err
+	}
+	if d.New, err = rc.GetTask(newID); err != nil {
+		return nil, err
+	}
+	oldKeys, oldStates, err := taskKeyStates(rc, oldID)
+	if err != nil {
+		return nil, err
+	}
+	newKeys, newStates, err := taskKeyStates(rc, newID)
+	if err != nil {
+		return nil, err
+	}
+	inOld := make(map[string]bool)
+	for _, k := range oldKeys {
+		inOld[k] = true
+	}
+	inNew := make(map[string]bool)
+	for _, k := range newKeys {
+		inNew[k] = true
+		if !inOld[k] {
+			d.Added = append(d.Added, k)
+		}
+	}
+	for _, k := range oldKeys {
+		if !inNew[k] {
+			d.Removed = append(d.Removed, k)
+			continue
+		}
+		c := KeyChange{Key: k, OldState: oldStates[k], NewState: newStates[k]}
+		if c.OldState != StateFail && c.NewState == StateFail {
+			d.NewlyFailing = append(d.NewlyFailing, c)
+		} else if c.OldState == StateFail && len(c.NewState) > 0 && c.NewState != StateFail {
+			d.NewlyPassing = append(d.NewlyPassing, c)
+		}
+		if c.Diffs, err = diffTaskKey(rc, checkerOf(k, checkers, globals), oldID, newID, k); err != nil {
+			return nil, err
+		}
+		if len(c.Diffs) > 0 {
+			d.DataChanged = append(d.DataChanged, c)
+		}
+	}
+	sort.Strings(d.Added)
+	return &d, nil
+}
+
+func checkerOf(key string, checkers map[string]resultset.Checker, globals []GlobalChecker) resultset.Checker {
+	if c, ok := checkers[key]; ok {
+		return c
+	}
+	for _, gc := range globals {
+		if gc.Available(key) {
+			return gc.Checker()
+		}
+	}
+	return DefaultChecker
+}
+
+// diffTaskKey compares results of the key in two tasks. The n-th result of a
+// source is paired with the n-th result of the same source in the old task.
+func diffTaskKey(rc ResultStore, checker resultset.Checker, oldID string, newID string, key string) ([]DiffDetail, error) {
+	old, cur, err := streamKey(rc, oldID, key)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+	it, err := rc.Stream(newID, key)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var diffs []DiffDetail
+	pairs := newSourcePairs(old)
+	for it.Next() {
+		r := it.Result()
+		j := pairs.next(r)
+		if j < 0 {
+			diffs = append(diffs, DiffDetail{Baseline: oldID, Source: r.SourceID(), Message: "no result in the old task"})
+			continue
+		}
+		rs1, err := cur.Load(j)
+		if err != nil {
+			return nil, err
+		}
+		rs2, err := it.ResultSet()
+		if err != nil {
+			return nil, err
+		}
+		if err = diffResults(checker, rs1, rs2); err != nil {
+			diffs = append(diffs, DescribeDiff(&BaselineMismatch{Baseline: oldID, Source: r.SourceID(), Err: err}))
+		}
+	}
+	for j, r := range old {
+		if !pairs.used[j] {
+			diffs = append(diffs, DiffDetail{Baseline: oldID, Source: r.SourceID(), Message: "no result in the new task"})
+		}
+	}
+	return diffs, nil
+}
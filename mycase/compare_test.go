@@ -0,0 +1,100 @@
+package mycase
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zyguan/mytest/resultset"
+)
+
+func TestCompareTasks(t *testing.T) {
+	store := NewMemResultStore()
+	assert.NoError(t, store.Setup(TaskInfo{ID: "t1", Name: "foo"}))
+	for _, qr := range []QueryResult{
+		testResult("k1", "a", "1"), testResult("k1", "b", "1"),
+		testResult("k2", "a", "1"), testResult("k2", "b", "2"),
+		testResult("k3", "a", "1"),
+		testResult("k5", "a", "1.0"), testResult("k5", "b", "2"),
+		testResult("k6", "a", "1"),
+	} {
+		assert.NoError(t, store.Write(qr))
+	}
+	assert.NoError(t, store.Mark("k1", StateOK))
+	assert.NoError(t, store.Mark("k2", StateFail))
+
+	assert.NoError(t, store.Setup(TaskInfo{ID: "t2", Name: "foo"}))
+	for _, qr := range []QueryResult{
+		testResult("k1", "a", "1"), testResult("k1", "b", "2"),
+		testResult("k2", "a", "1"), testResult("k2", "a", "1"), testResult("k2", "b", "1"),
+		testResult("k4", "a", "1"),
+		testResult("k5", "a", "1.001"),
+		testResult("k6", "c", "1"),
+	} {
+		assert.NoError(t, store.Write(qr))
+	}
+	assert.NoError(t, store.Mark("k1", StateFail))
+	assert.NoError(t, store.Mark("k2", StateOK))
+
+	assert.NoError(t, store.Setup(TaskInfo{ID: "t0", Name: "foo"}))
+
+	checkers := map[string]resultset.Checker{"k5": {Assertions: []resultset.ValueAssertion{resultset.FloatAssertion{Columns: []int{0}, Delta: 0.01}}}}
+	d, err := CompareTasks(store, "t1", "t2", checkers)
+	assert.NoError(t, err)
+	assert.False(t, d.Empty())
+	assert.Equal(t, "t1", d.Old.ID)
+	assert.Equal(t, "t2", d.New.ID)
+	// comparing leaves the current task as is.
+	assert.Equal(t, "t0", store.CurrentTask.ID)
+	assert.Equal(t, []string{"k4"}, d.Added)
+	assert.Equal(t, []string{"k3"}, d.Removed)
+	assert.Equal(t, []KeyChange{{Key: "k1", OldState: StateOK, NewState: StateFail}}, d.NewlyFailing)
+	assert.Equal(t, []KeyChange{{Key: "k2", OldState: StateFail, NewState: StateOK}}, d.NewlyPassing)
+	assert.Equal(t, 4, len(d.DataChanged))
+	assert.Equal(t, "k1", d.DataChanged[0].Key)
+	assert.Equal(t, []string{`baseline t1 (b): 1 cells mismatch` + "\n" + `[0:0] "1" <> "2" by resultset.RawBytesAssertion`}, diffStrings(d.DataChanged[0].Diffs))
+	assert.Equal(t, "k2", d.DataChanged[1].Key)
+	assert.Equal(t, []string{"baseline t1 (a): no result in the old task", `baseline t1 (b): 1 cells mismatch` + "\n" + `[0:0] "2" <> "1" by resultset.RawBytesAssertion`}, diffStrings(d.DataChanged[1].Diffs))
+	assert.Equal(t, "k5", d.DataChanged[2].Key)
+	assert.Equal(t, []string{"baseline t1 (b): no result in the new task"}, diffStrings(d.DataChanged[2].Diffs))
+	// results of different sources are never compared.
+	assert.Equal(t, "k6", d.DataChanged[3].Key)
+	assert.Equal(t, []string{"baseline t1 (c): no result in the old task", "baseline t1 (a): no result in the new task"}, diffStrings(d.DataChanged[3].Diffs))
+
+	d, err = CompareTasks(store, "t2", "t2", nil)
+	assert.NoError(t, err)
+	assert.True(t, d.Empty())
+	_, err = CompareTasks(store, "t1", "t3", nil)
+	assert.Equal(t, ErrTaskNotFound, err)
+}
+
+func diffStrings(diffs []DiffDetail) []string {
+	ss := make([]string, len(diffs))
+	for i, d := range diffs {
+		ss[i] = d.String()
+	}
+	return ss
+}
+
+func TestCompareTasks_Streaming(t *testing.T) {
+	sqlite, err := NewSQLiteResultStore(":memory:")
+	assert.NoError(t, err)
+	defer sqlite.Close()
+	store := &countingStore{SQLiteResultStore: sqlite, streams: make(map[string]int), loads: make(map[string]int)}
+	for _, id := range []string{"t1", "t2"} {
+		assert.NoError(t, store.Setup(TaskInfo{ID: id, Name: "foo"}))
+		for _, src := range []string{"a", "b", "c", "d"} {
+			assert.NoError(t, store.Write(testResult("k", src, src)))
+		}
+	}
+	assert.NoError(t, store.Flush())
+
+	d, err := CompareTasks(store, "t1", "t2", nil)
+	assert.NoError(t, err)
+	assert.True(t, d.Empty())
+	// results of the old task are read by a single stream besides the one of
+	// metadata.
+	assert.Equal(t, 2, store.streams["t1"])
+	assert.Equal(t, 4, store.loads["t1"])
+	assert.Equal(t, 1, store.streams["t2"])
+	assert.Equal(t, 4, store.loads["t2"])
+}
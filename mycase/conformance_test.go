@@ -0,0 +1,381 @@
+package mycase
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zyguan/mytest/resultset"
+)
+
+type storeOpener func(t *testing.T) (ResultStore, func())
+
+var storeOpeners = map[string]storeOpener{
+	"SQLite": func(t *testing.T) (ResultStore, func()) {
+		s, err := NewSQLiteResultStore(":memory:")
+		assert.NoError(t, err)
+		return s, func() { s.Close() }
+	},
+	"Mem": func(t *testing.T) (ResultStore, func()) {
+		s := NewMemResultStore()
+		return s, func() { s.Close() }
+	},
+	"FS": func(t *testing.T) (ResultStore, func()) {
+		dir, err := ioutil.TempDir("", "mycase")
+		assert.NoError(t, err)
+		s, err := NewFSResultStore(dir)
+		assert.NoError(t, err)
+		return s, func() { s.Close(); os.RemoveAll(dir) }
+	},
+}
+
+// TestResultStoreConformance runs the same tests against every ResultStore
+// implementation.
+func TestResultStoreConformance(t *testing.T) {
+	for name, open := range storeOpeners {
+		t.Run(name, func(t *testing.T) {
+			for _, tt := range []struct {
+				name string
+				test func(t *testing.T, rc ResultStore)
+			}{
+				{"NotSetup", tStoreNotSetup},
+				{"Setup", tStoreSetup},
+				{"ReadWrite", tStoreReadWrite},
+				{"Replace", tStoreReplace},
+				{"Stream", tStoreStream},
+				{"Mark", tStoreMark},
+				{"Tasks", tStoreTasks},
+				{"RenameTask", tStoreRenameTask},
+				{"TaskScoped", tStoreTaskScoped},
+				{"Run", tStoreRun},
+			} {
+				t.Run(tt.name, func(t *testing.T) {
+					rc, closeStore := open(t)
+					defer closeStore()
+					tt.test(t, rc)
+				})
+			}
+		})
+	}
+}
+
+func testResult(key string, source string, vals ...string) QueryResult {
+	rs := resultset.New([]resultset.ColumnDef{{Name: "v", Type: "TEXT"}})
+	for _, v := range vals {
+		*(rs.AllocateRow()[0].(*[]byte)) = []byte(v)
+	}
+	return QueryResult{Time: time.Unix(1573430460, 0), Duration: 0.5, Key: key, SQL: "select v", Source: source, Version: "v1", ResultSet: rs}
+}
+
+func tStoreNotSetup(t *testing.T, rc ResultStore) {
+	assert.Equal(t, ErrNotSetup, rc.Write(testResult("k", "a")))
+	_, err := rc.Read("k")
+	assert.Equal(t, ErrNotSetup, err)
+	_, err = rc.Keys()
+	assert.Equal(t, ErrNotSetup, err)
+	assert.Equal(t, ErrNotSetup, rc.Mark("k", StateOK))
+	assert.Equal(t, ErrNotSetup, rc.MarkCheck(KeyCheck{Key: "k"}))
+	_, err = rc.KeyChecks("k")
+	assert.Equal(t, ErrNotSetup, err)
+	_, err = rc.KeysByState(StateOK)
+	assert.Equal(t, ErrNotSetup, err)
+	assert.Error(t, rc.Setup(TaskInfo{}))
+}
+
+func tStoreSetup(t *testing.T, rc ResultStore) {
+	task := TaskInfo{ID: "foo", Name: "bar", Meta: json.RawMessage("42"), Time: time.Unix(1573430400, 0)}
+	assert.NoError(t, rc.Setup(task))
+	assert.NoError(t, rc.Setup(TaskInfo{ID: "foo", Name: "other"}))
+	got, err := rc.GetTask("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, task, got)
+}
+
+func tStoreReadWrite(t *testing.T, rc ResultStore) {
+	assert.NoError(t, rc.Setup(TaskInfo{ID: "t1"}))
+	qrs, err := rc.Read("k")
+	assert.NoError(t, err)
+	assert.Empty(t, qrs)
+	ks, err := rc.Keys()
+	assert.NoError(t, err)
+	assert.Empty(t, ks)
+
+	b1, b2, a := testResult("k", "b", "1"), testResult("k", "b", "2"), testResult("k", "a", "3")
+	a.SourceMeta = json.RawMessage(`{"x":1}`)
+	b2.Bench = &BenchStats{Runs: 3, Min: 0.25, Median: 0.5, P95: 0.75, Max: 0.75, Durations: []float64{0.5, 0.25, 0.75}}
+	for _, qr := range []QueryResult{b1, testResult("k2", "a"), b2, a} {
+		assert.NoError(t, rc.Write(qr))
+	}
+	qrs, err = rc.Read("k")
+	assert.NoError(t, err)
+	assert.Equal(t, []QueryResult{a, b1, b2}, qrs)
+	for i := range qrs {
+		assert.Equal(t, []QueryResult{a, b1, b2}[i].ResultSet.DataDigest(), qrs[i].ResultSet.DataDigest())
+	}
+	ks, err = rc.Keys()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"k", "k2"}, ks)
+
+	assert.NoError(t, rc.Setup(TaskInfo{ID: "t2"}))
+	qrs, err = rc.Read("k")
+	assert.NoError(t, err)
+	assert.Empty(t, qrs)
+	qrs, err = rc.ReadTask("t1", "k")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(qrs))
+}
+
+func tStoreReplace(t *testing.T, rc ResultStore) {
+	assert.Equal(t, ErrNotSetup, rc.Replace(testResult("k", "a")))
+	assert.NoError(t, rc.Setup(TaskInfo{ID: "t1"}))
+	assert.NoError(t, rc.Write(testResult("k", "a", "0")))
+	assert.NoError(t, rc.Flush())
+
+	v1, v2 := testResult("k2", "", "1"), testResult("k2", "", "2")
+	v2.Version = "v2"
+	for _, qr := range []QueryResult{testResult("k", "a", "1"), testResult("k", "a", "2"), testResult("k", "b", "3"), v1, v2} {
+		assert.NoError(t, rc.Write(qr))
+	}
+	a, v1 := testResult("k", "a", "4"), testResult("k2", "", "5")
+	assert.NoError(t, rc.Replace(a))
+	assert.NoError(t, rc.Replace(v1))
+	assert.NoError(t, rc.Replace(testResult("k3", "a", "6")))
+	assert.NoError(t, rc.Flush())
+
+	qrs, err := rc.Read("k")
+	assert.NoError(t, err)
+	assert.Equal(t, []QueryResult{a, testResult("k", "b", "3")}, qrs)
+	qrs, err = rc.Read("k2")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []QueryResult{v1, v2}, qrs)
+	qrs, err = rc.Read("k3")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(qrs))
+
+	// a source never replaces results without a source of the same version
+	// and vice versa.
+	src, ver := testResult("k4", "v1", "8"), testResult("k4", "", "9")
+	assert.NoError(t, rc.Write(src))
+	assert.NoError(t, rc.Write(ver))
+	src2, ver2 := testResult("k4", "v1", "10"), testResult("k4", "", "11")
+	assert.NoError(t, rc.Replace(src2))
+	assert.NoError(t, rc.Flush())
+	qrs, err = rc.Read("k4")
+	assert.NoError(t, err)
+	assert.Equal(t, []QueryResult{ver, src2}, qrs)
+	assert.NoError(t, rc.Replace(ver2))
+	assert.NoError(t, rc.Flush())
+	qrs, err = rc.Read("k4")
+	assert.NoError(t, err)
+	assert.Equal(t, []QueryResult{ver2, src2}, qrs)
+
+	// results of other tasks are kept
+	assert.NoError(t, rc.Setup(TaskInfo{ID: "t2"}))
+	assert.NoError(t, rc.Replace(testResult("k", "a", "7")))
+	assert.NoError(t, rc.Flush())
+	qrs, err = rc.ReadTask("t1", "k")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(qrs))
+}
+
+func tStoreStream(t *testing.T, rc ResultStore) {
+	assert.NoError(t, rc.Setup(TaskInfo{ID: "t1"}))
+	for _, qr := range []QueryResult{testResult("k", "b", "1"), testResult("k", "a", "2", "3"), testResult("k2", "a"), testResult("k", "b", "4")} {
+		assert.NoError(t, rc.Write(qr))
+	}
+	qrs, err := rc.ReadTask("t1", "k")
+	assert.NoError(t, err)
+	it, err := rc.Stream("t1", "k")
+	assert.NoError(t, err)
+	defer it.Close()
+	for i := 0; i < len(qrs); i++ {
+		assert.True(t, it.Next())
+		qr := it.Result()
+		assert.Nil(t, qr.ResultSet)
+		qr.ResultSet, err = it.ResultSet()
+		assert.NoError(t, err)
+		assert.Equal(t, qrs[i], qr)
+	}
+	assert.False(t, it.Next())
+
+	it, err = rc.Stream("t2", "k")
+	assert.NoError(t, err)
+	assert.False(t, it.Next())
+	assert.NoError(t, it.Close())
+}
+
+func tStoreMark(t *testing.T, rc ResultStore) {
+	assert.NoError(t, rc.Setup(TaskInfo{ID: "t1"}))
+	ks, err := rc.KeysByState(StateOK)
+	assert.NoError(t, err)
+	assert.Empty(t, ks)
+
+	assert.NoError(t, rc.Mark("foo", StateOK))
+	assert.NoError(t, rc.Mark("bar", StateOK))
+	assert.NoError(t, rc.Mark("baz", StateFail))
+	assert.NoError(t, rc.Mark("bar", StateFail))
+	ks, err = rc.KeysByState(StateOK)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, ks)
+	ks, err = rc.KeysByState(StateFail)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bar", "baz"}, ks)
+
+	v := "1"
+	c := KeyCheck{Key: "foo", State: StateFail, Checker: "dummy", Sources: []string{"a", "b"}, Time: time.Unix(1573430460, 0),
+		Diffs: []DiffDetail{{Reference: "a", Source: "b", Message: "oops", Cells: []CellDiff{{Row: 1, Val1: &v}}}},
+		Perf:  &PerfCheck{State: StateSlow, Slowdowns: []Slowdown{{Source: "b", Reference: "a", Duration: 2, RefTime: 1, Ratio: 2}}}}
+	assert.NoError(t, rc.MarkCheck(c))
+	cs, err := rc.KeyChecks("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(cs))
+	assert.Equal(t, StateOK, cs[0].State)
+	assert.Nil(t, cs[0].Sources)
+	assert.Nil(t, cs[0].Diffs)
+	assert.Equal(t, c, cs[1])
+	ks, err = rc.KeysByState(StateFail)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bar", "baz", "foo"}, ks)
+	ks, err = rc.KeysByState(StateSlow)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, ks)
+	assert.NoError(t, rc.MarkCheck(KeyCheck{Key: "bar", State: StateOK, Perf: &PerfCheck{State: StateOK, Slowdowns: []Slowdown{{Source: StateSlow}}}}))
+	ks, err = rc.KeysByState(StateSlow)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, ks)
+
+	assert.NoError(t, rc.Setup(TaskInfo{ID: "t2"}))
+	ks, err = rc.KeysByState(StateFail)
+	assert.NoError(t, err)
+	assert.Empty(t, ks)
+	cs, err = rc.KeyChecks("foo")
+	assert.NoError(t, err)
+	assert.Empty(t, cs)
+}
+
+func tStoreTasks(t *testing.T, rc ResultStore) {
+	now := time.Now().Truncate(time.Second)
+	tasks := []TaskInfo{
+		{ID: "t1", Name: "foo", Meta: json.RawMessage(`{"tag":"nightly"}`), Time: now.Add(-72 * time.Hour)},
+		{ID: "t2", Name: "bar", Meta: json.RawMessage(`{"tag":"release"}`), Time: now.Add(-48 * time.Hour)},
+		{ID: "t3", Name: "foo", Meta: json.RawMessage(`{"tag":"nightly"}`), Time: now},
+	}
+	for _, task := range tasks {
+		assert.NoError(t, rc.Setup(task))
+	}
+	for _, qr := range []QueryResult{testResult("k1", "b"), testResult("k1", "a"), testResult("k2", "")} {
+		assert.NoError(t, rc.Write(qr))
+	}
+	assert.NoError(t, rc.Mark("k1", StateOK))
+	assert.NoError(t, rc.Mark("k2", StateFail))
+
+	ts, err := rc.ListTasks(TaskFilter{Name: "foo", Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []TaskInfo{tasks[2]}, ts)
+	ts, err = rc.ListTasks(TaskFilter{Meta: "release", Since: now.Add(-50 * time.Hour), Until: now})
+	assert.NoError(t, err)
+	assert.Equal(t, []TaskInfo{tasks[1]}, ts)
+
+	sum, err := rc.TaskSummary("t3")
+	assert.NoError(t, err)
+	assert.Equal(t, TaskSummary{Info: tasks[2], Keys: 2, Results: 3, Sources: []string{"a", "b", "v1"}, States: map[string]int{StateOK: 1, StateFail: 1}, Duration: 1.5}, sum)
+	_, err = rc.TaskSummary("t4")
+	assert.Equal(t, ErrTaskNotFound, err)
+
+	assert.NoError(t, rc.DeleteTask("t3"))
+	_, err = rc.GetTask("t3")
+	assert.Equal(t, ErrTaskNotFound, err)
+	qrs, err := rc.ReadTask("t3", "k1")
+	assert.NoError(t, err)
+	assert.Empty(t, qrs)
+	assert.Equal(t, ErrNotSetup, rc.Mark("k1", StateOK))
+
+	n, err := rc.PruneTasks(60 * time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	ts, err = rc.ListTasks(TaskFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []TaskInfo{tasks[1]}, ts)
+}
+
+func tStoreRenameTask(t *testing.T, rc ResultStore) {
+	task := TaskInfo{ID: "t1", Name: "foo", Meta: json.RawMessage(`{"x":1}`), Time: time.Unix(1573430400, 0)}
+	assert.NoError(t, rc.Setup(task))
+	assert.NoError(t, rc.Write(testResult("k", "a", "1")))
+	assert.NoError(t, rc.MarkCheck(KeyCheck{Key: "k", State: StateFail, Time: time.Unix(1573430460, 0)}))
+	assert.NoError(t, rc.Setup(TaskInfo{ID: "t2"}))
+
+	assert.Equal(t, ErrTaskNotFound, rc.RenameTask("t0", "t3"))
+	assert.Equal(t, ErrTaskExists, rc.RenameTask("t1", "t2"))
+	assert.NoError(t, rc.RenameTask("t2", "t3"))
+	// the current task follows the rename
+	assert.NoError(t, rc.Write(testResult("k", "b", "2")))
+	assert.NoError(t, rc.Flush())
+	qrs, err := rc.ReadTask("t3", "k")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(qrs))
+	assert.NoError(t, rc.RenameTask("t1", "t4"))
+
+	_, err = rc.GetTask("t1")
+	assert.Equal(t, ErrTaskNotFound, err)
+	got, err := rc.GetTask("t4")
+	assert.NoError(t, err)
+	task.ID = "t4"
+	assert.Equal(t, task, got)
+	qrs, err = rc.ReadTask("t4", "k")
+	assert.NoError(t, err)
+	assert.Equal(t, []QueryResult{testResult("k", "a", "1")}, qrs)
+	qrs, err = rc.ReadTask("t1", "k")
+	assert.NoError(t, err)
+	assert.Empty(t, qrs)
+	assert.NoError(t, rc.Setup(TaskInfo{ID: "t4"}))
+	ks, err := rc.KeysByState(StateFail)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"k"}, ks)
+}
+
+func tStoreTaskScoped(t *testing.T, rc ResultStore) {
+	assert.NoError(t, rc.Setup(TaskInfo{ID: "t1"}))
+	assert.NoError(t, rc.Write(testResult("k1", "a", "1")))
+	assert.NoError(t, rc.Write(testResult("k2", "a", "1")))
+	assert.NoError(t, rc.MarkCheck(KeyCheck{Key: "k1", State: StateFail, Time: time.Unix(1573430460, 0)}))
+	assert.NoError(t, rc.MarkCheck(KeyCheck{Key: "k2", State: StateOK, Perf: &PerfCheck{State: StateSlow}, Time: time.Unix(1573430460, 0)}))
+	assert.NoError(t, rc.Setup(TaskInfo{ID: "t2"}))
+	assert.NoError(t, rc.Write(testResult("k3", "a", "1")))
+	assert.NoError(t, rc.Mark("k3", StateFail))
+
+	ks, err := rc.TaskKeys("t1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"k1", "k2"}, ks)
+	ks, err = rc.TaskKeysByState("t1", StateFail)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"k1"}, ks)
+	ks, err = rc.TaskKeysByState("t1", StateSlow)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"k2"}, ks)
+	cs, err := rc.TaskKeyChecks("t1", "k1")
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(cs)) {
+		assert.Equal(t, StateFail, cs[0].State)
+	}
+	ks, err = rc.TaskKeys("t0")
+	assert.NoError(t, err)
+	assert.Empty(t, ks)
+	// reads of other tasks leave the current task as is.
+	ks, err = rc.KeysByState(StateFail)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"k3"}, ks)
+}
+
+func tStoreRun(t *testing.T, rc ResultStore) {
+	mc := &fakeCase{name: "c", results: map[string][]string{"k1": {"a", "a"}, "k2": {"a", "b"}}}
+	err := Run(mc, rc)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"k2"}, err.(*RunErrors).DiffKeys)
+	ks, err := rc.KeysByState(StateOK)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"k1"}, ks)
+}
@@ -0,0 +1,861 @@
+package mycase
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zyguan/mytest/resultset"
+)
+
+var (
+	_ ResultStore = &FSResultStore{}
+)
+
+// FSResultStore keeps results in a directory tree which is friendly to version
+// control systems:
+//
+//	<root>/<task>/task.json
+//	<root>/<task>/states.jsonl
+//	<root>/<task>/results/<key>/source=<source>.jsonl
+//	<root>/<task>/timings/<key>/source=<source>.jsonl
+//
+// Results without a source are kept in files named version=<version>.jsonl
+// instead, see encodeName for how names are escaped and shortened.
+// A result file is in JSON lines format, every result written starts with a
+// header line holding its schema and is followed by one line per row. When and
+// how long a result took are kept apart in the timing file of the same name, one
+// line per result, so that rerunning unchanged queries leaves result files byte
+// by byte identical and timings may be left out of version control. Key states
+// are appended to states.jsonl, the last line of a key is its current state.
+type FSResultStore struct {
+	CurrentTask TaskInfo
+
+	root string
+	mu   *sync.Mutex
+}
+
+func NewFSResultStore(root string) (*FSResultStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, errors.New("create root dir: " + err.Error())
+	}
+	return &FSResultStore{root: root, mu: new(sync.Mutex)}, nil
+}
+
+// Fork returns a store sharing the same directory but with its own current task.
+func (s *FSResultStore) Fork() *FSResultStore { return &FSResultStore{root: s.root, mu: s.mu} }
+
+// Meta fields are kept as strings to preserve them byte by byte.
+type fsTask struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Meta string `json:"meta,omitempty"`
+	Time int64  `json:"time"`
+}
+
+// fsHeader is the header line of a result. Timing fields are no longer written
+// here but still read from results written before timing files.
+type fsHeader struct {
+	SQL        string                `json:"sql"`
+	Source     string                `json:"source"`
+	Version    string                `json:"version"`
+	SourceMeta string                `json:"source_meta,omitempty"`
+	Time       int64                 `json:"time,omitempty"`
+	Duration   float64               `json:"duration,omitempty"`
+	Bench      *BenchStats           `json:"bench,omitempty"`
+	Columns    []resultset.ColumnDef `json:"columns,omitempty"`
+	Exec       *resultset.ExecResult `json:"exec,omitempty"`
+}
+
+// fsTiming is a line of a timing file.
+type fsTiming struct {
+	Time     int64       `json:"time"`
+	Duration float64     `json:"duration"`
+	Bench    *BenchStats `json:"bench,omitempty"`
+}
+
+type fsBinary struct {
+	Base64 string `json:"base64"`
+}
+
+type fsCheck struct {
+	Key     string       `json:"key"`
+	State   string       `json:"state"`
+	Checker string       `json:"checker,omitempty"`
+	Sources []string     `json:"sources,omitempty"`
+	Diffs   []DiffDetail `json:"diffs,omitempty"`
+	Perf    *PerfCheck   `json:"perf,omitempty"`
+	Time    int64        `json:"time"`
+}
+
+func (s *FSResultStore) Setup(info TaskInfo) error {
+	if len(info.ID) == 0 {
+		return errors.New("id is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.loadTask(info.ID)
+	if err == nil {
+		s.CurrentTask = t
+		return nil
+	} else if err != ErrTaskNotFound {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Join(s.taskDir(info.ID), "results"), 0755); err != nil {
+		return errors.New("add task: " + err.Error())
+	}
+	if err = s.saveTask(info); err != nil {
+		return err
+	}
+	s.CurrentTask = info
+	return nil
+}
+
+func (s *FSResultStore) ListTasks(filter TaskFilter) ([]TaskInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fis, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		return nil, errors.New("list tasks: " + err.Error())
+	}
+	var tasks []TaskInfo
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		t, err := s.loadTaskFile(filepath.Join(s.root, fi.Name(), "task.json"))
+		if err == ErrTaskNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if len(filter.Name) > 0 && t.Name != filter.Name {
+			continue
+		}
+		if len(filter.Meta) > 0 && !strings.Contains(string(t.Meta), filter.Meta) {
+			continue
+		}
+		if !filter.Since.IsZero() && t.Time.Unix() < filter.Since.Unix() {
+			continue
+		}
+		if !filter.Until.IsZero() && t.Time.Unix() >= filter.Until.Unix() {
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].Time.Equal(tasks[j].Time) {
+			return tasks[i].Time.After(tasks[j].Time)
+		}
+		return tasks[i].ID < tasks[j].ID
+	})
+	if filter.Limit > 0 && len(tasks) > filter.Limit {
+		tasks = tasks[:filter.Limit]
+	}
+	return tasks, nil
+}
+
+func (s *FSResultStore) GetTask(id string) (TaskInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loadTask(id)
+}
+
+func (s *FSResultStore) TaskSummary(id string) (TaskSummary, error) {
+	var (
+		sum TaskSummary
+		err error
+	)
+	if sum.Info, err = s.GetTask(id); err != nil {
+		return sum, err
+	}
+	keys, err := s.keys(id)
+	if err != nil {
+		return sum, err
+	}
+	sum.Keys = len(keys)
+	sources := make(map[string]bool)
+	for _, key := range keys {
+		qrs, _, err := s.readTask(id, key, func(int) bool { return false })
+		if err != nil {
+			return sum, err
+		}
+		for _, qr := range qrs {
+			sum.Results++
+			sum.Duration += qr.Duration
+			if !sources[qr.SourceID()] {
+				sources[qr.SourceID()] = true
+				sum.Sources = append(sum.Sources, qr.SourceID())
+			}
+		}
+	}
+	sort.Strings(sum.Sources)
+	states, err := s.states(id)
+	if err != nil {
+		return sum, err
+	}
+	sum.States = make(map[string]int)
+	for _, c := range states {
+		sum.States[c.State]++
+	}
+	return sum, nil
+}
+
+func (s *FSResultStore) DeleteTask(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.RemoveAll(s.taskDir(id)); err != nil {
+		return errors.New("delete task: " + err.Error())
+	}
+	if s.CurrentTask.ID == id {
+		s.CurrentTask = TaskInfo{}
+	}
+	return nil
+}
+
+func (s *FSResultStore) RenameTask(id string, newID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, err := s.loadTask(id)
+	if err != nil {
+		return err
+	}
+	if _, err = s.loadTask(newID); err == nil {
+		return ErrTaskExists
+	} else if err != ErrTaskNotFound {
+		return err
+	}
+	if err = os.Rename(s.taskDir(id), s.taskDir(newID)); err != nil {
+		return errors.New("rename task: " + err.Error())
+	}
+	info.ID = newID
+	if err = s.saveTask(info); err != nil {
+		return err
+	}
+	if s.CurrentTask.ID == id {
+		s.CurrentTask.ID = newID
+	}
+	return nil
+}
+
+func (s *FSResultStore) PruneTasks(olderThan time.Duration) (int, error) {
+	tasks, err := s.ListTasks(TaskFilter{Until: time.Now().Add(-olderThan)})
+	if err != nil {
+		return 0, err
+	}
+	for i, t := range tasks {
+		if err = s.DeleteTask(t.ID); err != nil {
+			return i, err
+		}
+	}
+	return len(tasks), nil
+}
+
+func (s *FSResultStore) Write(res QueryResult) error { return s.write(res, false) }
+
+// Replace rewrites the result file of the source.
+func (s *FSResultStore) Replace(res QueryResult) error { return s.write(res, true) }
+
+func (s *FSResultStore) write(res QueryResult, replace bool) error {
+	if len(s.CurrentTask.ID) == 0 {
+		return ErrNotSetup
+	}
+	buf := new(bytes.Buffer)
+	if err := encodeResult(buf, res); err != nil {
+		return errors.New("encode result set: " + err.Error())
+	}
+	timing, err := json.Marshal(fsTiming{Time: res.Time.Unix(), Duration: res.Duration, Bench: res.Bench})
+	if err != nil {
+		return errors.New("encode timing: " + err.Error())
+	}
+	name := resultFileName(res)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.saveKey(s.CurrentTask.ID, res.Key); err != nil {
+		return err
+	}
+	for _, f := range []struct {
+		dir  string
+		data []byte
+	}{
+		{s.resultDir(s.CurrentTask.ID, "results", res.Key), buf.Bytes()},
+		{s.resultDir(s.CurrentTask.ID, "timings", res.Key), append(timing, '\n')},
+	} {
+		if err := os.MkdirAll(f.dir, 0755); err != nil {
+			return errors.New("add result: " + err.Error())
+		}
+		file := filepath.Join(f.dir, name)
+		if !replace {
+			if err := appendFile(file, f.data); err != nil {
+				return errors.New("add result: " + err.Error())
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(file+".tmp", f.data, 0644); err != nil {
+			return errors.New("replace result: " + err.Error())
+		}
+		if err := os.Rename(file+".tmp", file); err != nil {
+			return errors.New("replace result: " + err.Error())
+		}
+		// files written before names of sources and versions were apart.
+		if err := os.Remove(filepath.Join(f.dir, encodeName(res.SourceID())+".jsonl")); err != nil && !os.IsNotExist(err) {
+			return errors.New("replace result: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func (s *FSResultStore) Read(key string) ([]QueryResult, error) {
+	if len(s.CurrentTask.ID) == 0 {
+		return nil, ErrNotSetup
+	}
+	return s.ReadTask(s.CurrentTask.ID, key)
+}
+
+func (s *FSResultStore) ReadTask(taskID string, key string) ([]QueryResult, error) {
+	qrs, _, err := s.readTask(taskID, key, func(int) bool { return true })
+	return qrs, err
+}
+
+func (s *FSResultStore) Stream(taskID string, key string) (ResultIterator, error) {
+	qrs, refs, err := s.readTask(taskID, key, func(int) bool { return false })
+	if err != nil {
+		return nil, err
+	}
+	return newResultIter(qrs, func(i int) (*resultset.ResultSet, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		rs, err := readResults(refs[i].file, "", key, func(k int) bool { return k == refs[i].idx })
+		if err != nil {
+			return nil, err
+		}
+		return rs[refs[i].idx].ResultSet, nil
+	}), nil
+}
+
+// fsResultRef locates the idx-th result in a result file.
+type fsResultRef struct {
+	file string
+	idx  int
+}
+
+// readTask reads results of the key, rows are decoded only for results picked
+// by decode, which is given the index of a result in its file.
+func (s *FSResultStore) readTask(taskID string, key string, decode func(i int) bool) ([]QueryResult, []fsResultRef, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dir := s.resultDir(taskID, "results", key)
+	fis, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, errors.New("query result: " + err.Error())
+	}
+	var (
+		qrs  []QueryResult
+		refs []fsResultRef
+	)
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".jsonl") {
+			continue
+		}
+		file := filepath.Join(dir, fi.Name())
+		rs, err := readResults(file, filepath.Join(s.resultDir(taskID, "timings", key), fi.Name()), key, decode)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range rs {
+			refs = append(refs, fsResultRef{file, i})
+		}
+		qrs = append(qrs, rs...)
+	}
+	idx := make([]int, len(qrs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		a, b := qrs[idx[i]], qrs[idx[j]]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Version < b.Version
+	})
+	sortedQrs, sortedRefs := make([]QueryResult, len(idx)), make([]fsResultRef, len(idx))
+	for i, k := range idx {
+		sortedQrs[i], sortedRefs[i] = qrs[k], refs[k]
+	}
+	return sortedQrs, sortedRefs, nil
+}
+
+func (s *FSResultStore) Keys() ([]string, error) {
+	if len(s.CurrentTask.ID) == 0 {
+		return nil, ErrNotSetup
+	}
+	return s.TaskKeys(s.CurrentTask.ID)
+}
+
+func (s *FSResultStore) TaskKeys(taskID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys(taskID)
+}
+
+func (s *FSResultStore) Mark(key string, state string) error {
+	return s.MarkCheck(KeyCheck{Key: key, State: state, Time: time.Now()})
+}
+
+func (s *FSResultStore) MarkCheck(check KeyCheck) error {
+	if len(s.CurrentTask.ID) == 0 {
+		return ErrNotSetup
+	}
+	raw, err := json.Marshal(fsCheck{
+		Key:     check.Key,
+		State:   check.State,
+		Checker: check.Checker,
+		Sources: check.Sources,
+		Diffs:   check.Diffs,
+		Perf:    check.Perf,
+		Time:    check.Time.Unix(),
+	})
+	if err != nil {
+		return errors.New("encode key check: " + err.Error())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return appendFile(filepath.Join(s.taskDir(s.CurrentTask.ID), "states.jsonl"), append(raw, '\n'))
+}
+
+func (s *FSResultStore) KeyChecks(key string) ([]KeyCheck, error) {
+	if len(s.CurrentTask.ID) == 0 {
+		return nil, ErrNotSetup
+	}
+	return s.TaskKeyChecks(s.CurrentTask.ID, key)
+}
+
+func (s *FSResultStore) TaskKeyChecks(taskID string, key string) ([]KeyCheck, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	checks, err := s.checks(taskID)
+	if err != nil {
+		return nil, err
+	}
+	var cs []KeyCheck
+	for _, c := range checks {
+		if c.Key == key {
+			cs = append(cs, c)
+		}
+	}
+	return cs, nil
+}
+
+func (s *FSResultStore) KeysByState(state string) ([]string, error) {
+	if len(s.CurrentTask.ID) == 0 {
+		return nil, ErrNotSetup
+	}
+	return s.TaskKeysByState(s.CurrentTask.ID, state)
+}
+
+func (s *FSResultStore) TaskKeysByState(taskID string, state string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states, err := s.states(taskID)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for k, c := range states {
+		if c.inState(state) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *FSResultStore) Flush() error { return nil }
+
+func (s *FSResultStore) Close() error { return nil }
+
+func (s *FSResultStore) taskDir(id string) string { return filepath.Join(s.root, encodeName(id)) }
+
+// resultDir returns the dir of result or timing files of the key.
+func (s *FSResultStore) resultDir(id string, kind string, key string) string {
+	return filepath.Join(s.taskDir(id), kind, encodeName(key))
+}
+
+func (s *FSResultStore) saveTask(info TaskInfo) error {
+	raw, err := json.MarshalIndent(fsTask{ID: info.ID, Name: info.Name, Meta: string(info.Meta), Time: info.Time.Unix()}, "", "  ")
+	if err != nil {
+		return errors.New("encode task: " + err.Error())
+	}
+	if err = ioutil.WriteFile(filepath.Join(s.taskDir(info.ID), "task.json"), append(raw, '\n'), 0644); err != nil {
+		return errors.New("add task: " + err.Error())
+	}
+	return nil
+}
+
+func (s *FSResultStore) loadTask(id string) (TaskInfo, error) {
+	info, err := s.loadTaskFile(filepath.Join(s.taskDir(id), "task.json"))
+	if err != nil {
+		info.ID = id
+	}
+	return info, err
+}
+
+// loadTaskFile reads a task.json, task ids are read from it since shortened
+// names of task dirs can't be decoded.
+func (s *FSResultStore) loadTaskFile(file string) (TaskInfo, error) {
+	raw, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return TaskInfo{}, ErrTaskNotFound
+	} else if err != nil {
+		return TaskInfo{}, errors.New("query task: " + err.Error())
+	}
+	var t fsTask
+	if err = json.Unmarshal(raw, &t); err != nil {
+		return TaskInfo{}, errors.New("decode task: " + err.Error())
+	}
+	info := TaskInfo{ID: t.ID, Name: t.Name, Time: time.Unix(t.Time, 0)}
+	if len(t.Meta) > 0 {
+		info.Meta = json.RawMessage(t.Meta)
+	}
+	return info, nil
+}
+
+func (s *FSResultStore) keys(id string) ([]string, error) {
+	fis, err := ioutil.ReadDir(filepath.Join(s.taskDir(id), "results"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.New("query keys: " + err.Error())
+	}
+	var keys []string
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		key, err := decodeName(fi.Name())
+		if isShortened(fi.Name()) {
+			var raw []byte
+			raw, err = ioutil.ReadFile(filepath.Join(s.taskDir(id), "results", fi.Name(), "key"))
+			key = string(raw)
+		}
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// saveKey keeps the key in its result dir if the name of the dir is shortened.
+func (s *FSResultStore) saveKey(id string, key string) error {
+	dir := s.resultDir(id, "results", key)
+	if !isShortened(filepath.Base(dir)) {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.New("add result: " + err.Error())
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "key"), []byte(key), 0644); err != nil {
+		return errors.New("add result: " + err.Error())
+	}
+	return nil
+}
+
+func (s *FSResultStore) checks(id string) ([]KeyCheck, error) {
+	f, err := os.Open(filepath.Join(s.taskDir(id), "states.jsonl"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.New("query key states: " + err.Error())
+	}
+	defer f.Close()
+	var checks []KeyCheck
+	dec := json.NewDecoder(f)
+	for {
+		var c fsCheck
+		if err = dec.Decode(&c); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.New("decode key state: " + err.Error())
+		}
+		checks = append(checks, KeyCheck{
+			Key:     c.Key,
+			State:   c.State,
+			Checker: c.Checker,
+			Sources: c.Sources,
+			Diffs:   c.Diffs,
+			Perf:    c.Perf,
+			Time:    time.Unix(c.Time, 0),
+		})
+	}
+	return checks, nil
+}
+
+func (s *FSResultStore) states(id string) (map[string]KeyCheck, error) {
+	checks, err := s.checks(id)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]KeyCheck)
+	for _, c := range checks {
+		states[c.Key] = c
+	}
+	return states, nil
+}
+
+func encodeResult(w io.Writer, res QueryResult) error {
+	rs := res.ResultSet
+	hdr := fsHeader{
+		SQL:        res.SQL,
+		Source:     res.Source,
+		Version:    res.Version,
+		SourceMeta: string(res.SourceMeta),
+	}
+	if rs.IsExecResult() {
+		exec := rs.ExecResult()
+		hdr.Exec = &exec
+	} else {
+		hdr.Columns = make([]resultset.ColumnDef, rs.NCols())
+		for j := range hdr.Columns {
+			hdr.Columns[j] = rs.ColumnDef(j)
+		}
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(hdr); err != nil {
+		return err
+	}
+	row := make([]interface{}, rs.NCols())
+	for i := 0; i < rs.NRows(); i++ {
+		for j := range row {
+			v, _ := rs.RawValue(i, j)
+			if v == nil {
+				row[j] = nil
+			} else if utf8.Valid(v) {
+				row[j] = string(v)
+			} else {
+				row[j] = fsBinary{base64.StdEncoding.EncodeToString(v)}
+			}
+		}
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readResults reads results in the file, rows are decoded only for results
+// picked by decode, the result sets of others are left nil. Timings of results
+// are read from timingFile unless it's empty.
+func readResults(file string, timingFile string, key string, decode func(i int) bool) ([]QueryResult, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.New("query result: " + err.Error())
+	}
+	defer f.Close()
+	var timings []fsTiming
+	if len(timingFile) > 0 {
+		if timings, err = readTimings(timingFile); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		qrs []QueryResult
+		r   = bufio.NewReader(f)
+	)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if line[0] == '{' {
+				var hdr fsHeader
+				if err := json.Unmarshal(line, &hdr); err != nil {
+					return nil, errors.New("decode result header: " + err.Error())
+				}
+				qr := QueryResult{
+					Time:     time.Unix(hdr.Time, 0),
+					Duration: hdr.Duration,
+					Bench:    hdr.Bench,
+					Key:      key,
+					SQL:      hdr.SQL,
+					Source:   hdr.Source,
+					Version:  hdr.Version,
+				}
+				if len(hdr.SourceMeta) > 0 {
+					qr.SourceMeta = json.RawMessage(hdr.SourceMeta)
+				}
+				if i := len(qrs); i < len(timings) {
+					qr.Time, qr.Duration, qr.Bench = time.Unix(timings[i].Time, 0), timings[i].Duration, timings[i].Bench
+				}
+				if decode(len(qrs)) {
+					qr.ResultSet = resultset.New(hdr.Columns)
+					if hdr.Exec != nil {
+						qr.ResultSet = resultset.NewExec(*hdr.Exec)
+					}
+				}
+				qrs = append(qrs, qr)
+			} else if len(qrs) == 0 {
+				return nil, errors.New("decode result row: missing header in " + file)
+			} else if rs := qrs[len(qrs)-1].ResultSet; rs != nil {
+				if err := decodeRow(line, rs); err != nil {
+					return nil, errors.New("decode result row: " + err.Error())
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.New("read result: " + err.Error())
+		}
+	}
+	return qrs, nil
+}
+
+// readTimings reads lines of the timing file, a missing file has no lines.
+func readTimings(file string) ([]fsTiming, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.New("query timing: " + err.Error())
+	}
+	defer f.Close()
+	var timings []fsTiming
+	dec := json.NewDecoder(f)
+	for {
+		var t fsTiming
+		if err = dec.Decode(&t); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.New("decode timing: " + err.Error())
+		}
+		timings = append(timings, t)
+	}
+	return timings, nil
+}
+
+func decodeRow(line []byte, rs *resultset.ResultSet) error {
+	var vals []json.RawMessage
+	if err := json.Unmarshal(line, &vals); err != nil {
+		return err
+	}
+	row := rs.AllocateRow()
+	if len(vals) != len(row) {
+		return errors.New("unexpected number of columns")
+	}
+	for j, raw := range vals {
+		p := row[j].(*[]byte)
+		switch {
+		case bytes.Equal(raw, []byte("null")):
+			*p = nil
+		case len(raw) > 0 && raw[0] == '{':
+			var b fsBinary
+			if err := json.Unmarshal(raw, &b); err != nil {
+				return err
+			}
+			v, err := base64.StdEncoding.DecodeString(b.Base64)
+			if err != nil {
+				return err
+			}
+			*p = v
+		default:
+			var v string
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return err
+			}
+			*p = []byte(v)
+		}
+	}
+	return nil
+}
+
+func appendFile(file string, data []byte) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// resultFileName returns the name of the result and timing files of the
+// result, names of sources and versions are apart so that a source never
+// shares the file of results without a source.
+func resultFileName(res QueryResult) string {
+	if len(res.Source) > 0 {
+		return "source=" + encodeName(res.Source) + ".jsonl"
+	}
+	return "version=" + encodeName(res.Version) + ".jsonl"
+}
+
+// maxNameLen is the max length of names returned by encodeName, it leaves room
+// for prefixes and suffixes of result files within the 255 bytes limit of
+// most file systems.
+const maxNameLen = 200
+
+// encodeName makes a string safe to be used as a file name. Bytes other than
+// lower case letters, digits, '-', '_' and '.' are escaped as %XX, so that names
+// neither collide on case-insensitive file systems nor contain characters like
+// ':' which are reserved on some of them. The empty string is encoded as a
+// single '%' which can't be produced by escaping. Names longer than maxNameLen
+// are shortened to a prefix followed by '~' and the SHA-1 of the string, they
+// can't be decoded.
+func encodeName(s string) string {
+	if len(s) == 0 {
+		return "%"
+	}
+	if s == "." || s == ".." {
+		return strings.Repeat("%2E", len(s))
+	}
+	n := escapeName(s)
+	if len(n) > maxNameLen {
+		sum := sha1.Sum([]byte(s))
+		n = n[:maxNameLen-1-2*len(sum)] + "~" + hex.EncodeToString(sum[:])
+	}
+	return n
+}
+
+// isShortened tells whether the name is shortened by encodeName, '~' is always
+// escaped otherwise.
+func isShortened(n string) bool { return strings.Contains(n, "~") }
+
+func escapeName(s string) string {
+	const digits = "0123456789ABCDEF"
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' {
+			sb.WriteByte(c)
+		} else {
+			sb.WriteByte('%')
+			sb.WriteByte(digits[c>>4])
+			sb.WriteByte(digits[c&15])
+		}
+	}
+	return sb.String()
+}
+
+func decodeName(n string) (string, error) {
+	if n == "%" {
+		return "", nil
+	}
+	return url.PathUnescape(n)
+}
@@ -0,0 +1,171 @@
+package mycase
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zyguan/mytest/resultset"
+)
+
+func TestFSResultStore_Layout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mycase")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store, err := NewFSResultStore(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Setup(TaskInfo{ID: "t/1", Name: "foo"}))
+
+	rs := resultset.New([]resultset.ColumnDef{{Name: "a", Type: "TEXT"}, {Name: "b", Type: "BLOB"}})
+	row := rs.AllocateRow()
+	*(row[0].(*[]byte)) = []byte("x\ny")
+	*(row[1].(*[]byte)) = []byte{0xff, 0x00}
+	rs.AllocateRow()
+	for _, key := range []string{"", "..", "a/b", "%", "K", "k"} {
+		assert.NoError(t, store.Write(QueryResult{Key: key, Source: "127.0.0.1:4000/test", ResultSet: rs}))
+		assert.NoError(t, store.Write(QueryResult{Key: key, Version: "5.7", ResultSet: resultset.NewExec(resultset.ExecResult{RowsAffected: 3, HasRowsAffected: true})}))
+	}
+	assert.NoError(t, store.Mark("a/b", StateOK))
+
+	ks, err := store.Keys()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"", "%", "..", "K", "a/b", "k"}, ks)
+	for _, key := range ks {
+		qrs, err := store.Read(key)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(qrs))
+		assert.Equal(t, "", qrs[0].Source)
+		assert.True(t, qrs[0].ResultSet.IsExecResult())
+		assert.Equal(t, int64(3), qrs[0].ResultSet.ExecResult().RowsAffected)
+		assert.Equal(t, rs, qrs[1].ResultSet)
+	}
+
+	task := filepath.Join(dir, "t%2F1")
+	for _, f := range []string{"task.json", "states.jsonl", "results/%/version=5.7.jsonl", "results/%2E%2E/source=127.0.0.1%3A4000%2Ftest.jsonl", "results/a%2Fb/version=5.7.jsonl", "results/%4B/version=5.7.jsonl", "results/k/version=5.7.jsonl"} {
+		_, err := os.Stat(filepath.Join(task, f))
+		assert.NoError(t, err, f)
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(task, "results/a%2Fb/source=127.0.0.1%3A4000%2Ftest.jsonl"))
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.Equal(t, `["x\ny",{"base64":"/wA="}]`, lines[1])
+	assert.Equal(t, `[null,null]`, lines[2])
+}
+
+func TestFSResultStore_StableResultFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mycase")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store, err := NewFSResultStore(dir)
+	assert.NoError(t, err)
+
+	// results recorded twice differ only by timings.
+	for i, id := range []string{"t1", "t2"} {
+		assert.NoError(t, store.Setup(TaskInfo{ID: id}))
+		qr := testResult("k1", "a", "1", "2")
+		qr.Time, qr.Duration = time.Unix(1573430400+int64(i), 0), 0.5+float64(i)
+		qr.Bench = &BenchStats{Runs: 3, Median: qr.Duration}
+		assert.NoError(t, store.Write(qr))
+		qrs, err := store.Read("k1")
+		assert.NoError(t, err)
+		assert.Equal(t, []QueryResult{qr}, qrs)
+	}
+	read := func(id string, kind string) string {
+		raw, err := ioutil.ReadFile(filepath.Join(dir, id, kind, "k1", "source=a.jsonl"))
+		assert.NoError(t, err)
+		return string(raw)
+	}
+	assert.Equal(t, read("t1", "results"), read("t2", "results"))
+	assert.NotContains(t, read("t1", "results"), "duration")
+	assert.NotEqual(t, read("t1", "timings"), read("t2", "timings"))
+	assert.Contains(t, read("t2", "timings"), `"duration":1.5`)
+}
+
+func TestFSResultStore_Names(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mycase")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store, err := NewFSResultStore(dir)
+	assert.NoError(t, err)
+
+	// sources never share files of results without a source.
+	assert.NoError(t, store.Setup(TaskInfo{ID: "t1"}))
+	assert.NoError(t, store.Write(testResult("k", "v1", "1")))
+	assert.NoError(t, store.Write(QueryResult{Key: "k", Version: "v1", ResultSet: testResult("k", "", "2").ResultSet}))
+	assert.NoError(t, store.Replace(QueryResult{Key: "k", Version: "v1", ResultSet: testResult("k", "", "3").ResultSet}))
+	qrs, err := store.Read("k")
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, len(qrs)) {
+		assert.Equal(t, "", qrs[0].Source)
+		v, _ := qrs[0].ResultSet.RawValue(0, 0)
+		assert.Equal(t, "3", string(v))
+		assert.Equal(t, "v1", qrs[1].Source)
+		v, _ = qrs[1].ResultSet.RawValue(0, 0)
+		assert.Equal(t, "1", string(v))
+	}
+
+	// long names are shortened.
+	long := strings.Repeat("x/", 200)
+	assert.NoError(t, store.Setup(TaskInfo{ID: long}))
+	assert.NoError(t, store.Write(testResult(long, long, "1")))
+	assert.NoError(t, store.Write(testResult(long+"y", long, "1")))
+	ks, err := store.Keys()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{long, long + "y"}, ks)
+	qrs, err = store.Read(long)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(qrs)) {
+		assert.Equal(t, long, qrs[0].Source)
+	}
+	tasks, err := store.ListTasks(TaskFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(tasks))
+	assert.NoError(t, store.RenameTask(long, long+"z"))
+	_, err = store.GetTask(long + "z")
+	assert.NoError(t, err)
+	assert.Equal(t, maxNameLen, len(encodeName(long)))
+	assert.NotEqual(t, encodeName(long), encodeName(long+"y"))
+
+	// results in files written before sources and versions were apart are
+	// still read and replaced.
+	assert.NoError(t, store.Setup(TaskInfo{ID: "t2"}))
+	assert.NoError(t, store.Write(testResult("k", "a", "1")))
+	legacy := filepath.Join(dir, "t2", "results", "k", "a.jsonl")
+	assert.NoError(t, os.Rename(filepath.Join(dir, "t2", "results", "k", "source=a.jsonl"), legacy))
+	qrs, err = store.Read("k")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(qrs))
+	assert.NoError(t, store.Replace(testResult("k", "a", "2")))
+	qrs, err = store.Read("k")
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(qrs)) {
+		v, _ := qrs[0].ResultSet.RawValue(0, 0)
+		assert.Equal(t, "2", string(v))
+	}
+	_, err = os.Stat(legacy)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFSResultStore_TaskSummaryWithoutDecoding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mycase")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store, err := NewFSResultStore(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Setup(TaskInfo{ID: "t1"}))
+	assert.NoError(t, store.Write(testResult("k", "a", "1")))
+	assert.NoError(t, appendFile(filepath.Join(dir, "t1", "results", "k", "source=a.jsonl"), []byte("[\"x\", \"y\"]\n")))
+
+	// rows are never decoded to summarize the task.
+	_, err = store.Read("k")
+	assert.Error(t, err)
+	sum, err := store.TaskSummary("t1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sum.Results)
+	assert.Equal(t, []string{"a"}, sum.Sources)
+}
@@ -0,0 +1,114 @@
+package mycase
+
+import (
+	"errors"
+
+	"github.com/zyguan/mytest/resultset"
+)
+
+// ResultIterator iterates over results of a key in the same order as Read.
+// Result sets are decoded on demand, so that results of a key can be checked
+// without holding all of them in memory.
+type ResultIterator interface {
+	Next() bool
+	// Result returns the current result, its ResultSet is nil unless it has
+	// been loaded already.
+	Result() QueryResult
+	// ResultSet decodes the result set of the current result.
+	ResultSet() (*resultset.ResultSet, error)
+	Close() error
+}
+
+// resultIter iterates over results whose result sets are loaded by load.
+type resultIter struct {
+	qrs  []QueryResult
+	load func(i int) (*resultset.ResultSet, error)
+	pos  int
+}
+
+func newResultIter(qrs []QueryResult, load func(i int) (*resultset.ResultSet, error)) *resultIter {
+	return &resultIter{qrs: qrs, load: load, pos: -1}
+}
+
+// NewSliceIterator returns an iterator over results already read.
+func NewSliceIterator(qrs []QueryResult) ResultIterator { return newResultIter(qrs, nil) }
+
+func (it *resultIter) Next() bool {
+	if it.pos < len(it.qrs) {
+		it.pos++
+	}
+	return it.pos < len(it.qrs)
+}
+
+func (it *resultIter) Result() QueryResult { return it.qrs[it.pos] }
+
+func (it *resultIter) ResultSet() (*resultset.ResultSet, error) {
+	if rs := it.qrs[it.pos].ResultSet; rs != nil || it.load == nil {
+		return rs, nil
+	}
+	return it.load(it.pos)
+}
+
+func (it *resultIter) Close() error { return nil }
+
+// resultCursor loads result sets of a key from a single stream. Results are
+// expected to be loaded in order, the stream is reopened to load an earlier one.
+type resultCursor struct {
+	open func() (ResultIterator, error)
+	it   ResultIterator
+	pos  int
+}
+
+func newResultCursor(rc ResultStore, taskID string, key string) *resultCursor {
+	return &resultCursor{open: func() (ResultIterator, error) { return rc.Stream(taskID, key) }}
+}
+
+// Load decodes the result set of the i-th result.
+func (c *resultCursor) Load(i int) (*resultset.ResultSet, error) {
+	if c.it == nil || i < c.pos {
+		c.Close()
+		it, err := c.open()
+		if err != nil {
+			return nil, err
+		}
+		c.it, c.pos = it, -1
+	}
+	for c.pos < i {
+		if !c.it.Next() {
+			return nil, errors.New("result not found")
+		}
+		c.pos++
+	}
+	return c.it.ResultSet()
+}
+
+func (c *resultCursor) Close() error {
+	if c.it == nil {
+		return nil
+	}
+	err := c.it.Close()
+	c.it = nil
+	return err
+}
+
+// streamKey returns results of the key without their result sets, and a cursor
+// to load them.
+func streamKey(rc ResultStore, taskID string, key string) ([]QueryResult, *resultCursor, error) {
+	it, err := rc.Stream(taskID, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer it.Close()
+	return collectResults(it), newResultCursor(rc, taskID, key), nil
+}
+
+// collectResults returns results left in the iterator without their result sets.
+func collectResults(it ResultIterator) []QueryResult {
+	var qrs []QueryResult
+	for it.Next() {
+		qr := it.Result()
+		qr.ResultSet = nil
+		qrs = append(qrs, qr)
+	}
+	return qrs
+}
@@ -0,0 +1,388 @@
+package mycase
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zyguan/mytest/resultset"
+)
+
+var (
+	_ ResultStore = &MemResultStore{}
+)
+
+type memResult struct {
+	id     int
+	taskID string
+	qr     QueryResult
+	raw    []byte
+}
+
+type memData struct {
+	sync.RWMutex
+	seq     int
+	tasks   map[string]TaskInfo
+	results []memResult
+	states  map[string]map[string]KeyCheck
+	checks  map[string]map[string][]KeyCheck
+}
+
+// MemResultStore keeps everything in memory, it behaves the same as the
+// SQLiteResultStore and is meant for unit tests and dry runs.
+type MemResultStore struct {
+	CurrentTask TaskInfo
+
+	data *memData
+}
+
+func NewMemResultStore() *MemResultStore {
+	return &MemResultStore{data: &memData{
+		tasks:  make(map[string]TaskInfo),
+		states: make(map[string]map[string]KeyCheck),
+		checks: make(map[string]map[string][]KeyCheck),
+	}}
+}
+
+// Fork returns a store sharing the same data but with its own current task.
+func (s *MemResultStore) Fork() *MemResultStore { return &MemResultStore{data: s.data} }
+
+func (s *MemResultStore) Setup(info TaskInfo) error {
+	if len(info.ID) == 0 {
+		return errors.New("id is required")
+	}
+	s.data.Lock()
+	defer s.data.Unlock()
+	if t, ok := s.data.tasks[info.ID]; ok {
+		s.CurrentTask = t
+		return nil
+	}
+	t := info
+	t.Time = time.Unix(info.Time.Unix(), 0)
+	if len(t.Meta) == 0 {
+		t.Meta = nil
+	}
+	s.data.tasks[info.ID] = t
+	s.CurrentTask = info
+	return nil
+}
+
+func (s *MemResultStore) ListTasks(filter TaskFilter) ([]TaskInfo, error) {
+	s.data.RLock()
+	defer s.data.RUnlock()
+	var tasks []TaskInfo
+	for _, t := range s.data.tasks {
+		if len(filter.Name) > 0 && t.Name != filter.Name {
+			continue
+		}
+		if len(filter.Meta) > 0 && !strings.Contains(string(t.Meta), filter.Meta) {
+			continue
+		}
+		if !filter.Since.IsZero() && t.Time.Unix() < filter.Since.Unix() {
+			continue
+		}
+		if !filter.Until.IsZero() && t.Time.Unix() >= filter.Until.Unix() {
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].Time.Equal(tasks[j].Time) {
+			return tasks[i].Time.After(tasks[j].Time)
+		}
+		return tasks[i].ID < tasks[j].ID
+	})
+	if filter.Limit > 0 && len(tasks) > filter.Limit {
+		tasks = tasks[:filter.Limit]
+	}
+	return tasks, nil
+}
+
+func (s *MemResultStore) GetTask(id string) (TaskInfo, error) {
+	s.data.RLock()
+	defer s.data.RUnlock()
+	t, ok := s.data.tasks[id]
+	if !ok {
+		return TaskInfo{ID: id}, ErrTaskNotFound
+	}
+	return t, nil
+}
+
+func (s *MemResultStore) TaskSummary(id string) (TaskSummary, error) {
+	var (
+		sum TaskSummary
+		err error
+	)
+	if sum.Info, err = s.GetTask(id); err != nil {
+		return sum, err
+	}
+	s.data.RLock()
+	defer s.data.RUnlock()
+	keys := make(map[string]bool)
+	sources := make(map[string]bool)
+	for _, r := range s.data.results {
+		if r.taskID != id {
+			continue
+		}
+		sum.Results++
+		sum.Duration += r.qr.Duration
+		keys[r.qr.Key] = true
+		if !sources[r.qr.SourceID()] {
+			sources[r.qr.SourceID()] = true
+			sum.Sources = append(sum.Sources, r.qr.SourceID())
+		}
+	}
+	sort.Strings(sum.Sources)
+	sum.Keys = len(keys)
+	sum.States = make(map[string]int)
+	for _, c := range s.data.states[id] {
+		sum.States[c.State]++
+	}
+	return sum, nil
+}
+
+func (s *MemResultStore) DeleteTask(id string) error {
+	s.data.Lock()
+	defer s.data.Unlock()
+	results := s.data.results[:0]
+	for _, r := range s.data.results {
+		if r.taskID != id {
+			results = append(results, r)
+		}
+	}
+	s.data.results = results
+	delete(s.data.states, id)
+	delete(s.data.checks, id)
+	delete(s.data.tasks, id)
+	if s.CurrentTask.ID == id {
+		s.CurrentTask = TaskInfo{}
+	}
+	return nil
+}
+
+func (s *MemResultStore) RenameTask(id string, newID string) error {
+	s.data.Lock()
+	defer s.data.Unlock()
+	t, ok := s.data.tasks[id]
+	if !ok {
+		return ErrTaskNotFound
+	}
+	if _, ok = s.data.tasks[newID]; ok {
+		return ErrTaskExists
+	}
+	for i := range s.data.results {
+		if s.data.results[i].taskID == id {
+			s.data.results[i].taskID = newID
+		}
+	}
+	if states, ok := s.data.states[id]; ok {
+		s.data.states[newID] = states
+	}
+	if checks, ok := s.data.checks[id]; ok {
+		s.data.checks[newID] = checks
+	}
+	t.ID = newID
+	s.data.tasks[newID] = t
+	delete(s.data.states, id)
+	delete(s.data.checks, id)
+	delete(s.data.tasks, id)
+	if s.CurrentTask.ID == id {
+		s.CurrentTask.ID = newID
+	}
+	return nil
+}
+
+func (s *MemResultStore) PruneTasks(olderThan time.Duration) (int, error) {
+	tasks, err := s.ListTasks(TaskFilter{Until: time.Now().Add(-olderThan)})
+	if err != nil {
+		return 0, err
+	}
+	for i, t := range tasks {
+		if err = s.DeleteTask(t.ID); err != nil {
+			return i, err
+		}
+	}
+	return len(tasks), nil
+}
+
+func (s *MemResultStore) Write(res QueryResult) error { return s.write(res, false) }
+
+func (s *MemResultStore) Replace(res QueryResult) error { return s.write(res, true) }
+
+func (s *MemResultStore) write(res QueryResult, replace bool) error {
+	if len(s.CurrentTask.ID) == 0 {
+		return ErrNotSetup
+	}
+	raw, err := res.ResultSet.Encode()
+	if err != nil {
+		return errors.New("encode result set: " + err.Error())
+	}
+	res.Time = time.Unix(res.Time.Unix(), 0)
+	res.ResultSet = nil
+	if len(res.SourceMeta) == 0 {
+		res.SourceMeta = nil
+	}
+	s.data.Lock()
+	defer s.data.Unlock()
+	if replace {
+		results := s.data.results[:0]
+		for _, r := range s.data.results {
+			// the same as the predicate of sqlStore, sources never match
+			// results without a source.
+			same := r.qr.Source == res.Source && (len(res.Source) > 0 || r.qr.Version == res.Version)
+			if r.taskID != s.CurrentTask.ID || r.qr.Key != res.Key || !same {
+				results = append(results, r)
+			}
+		}
+		s.data.results = results
+	}
+	s.data.seq++
+	s.data.results = append(s.data.results, memResult{id: s.data.seq, taskID: s.CurrentTask.ID, qr: res, raw: raw})
+	return nil
+}
+
+func (s *MemResultStore) Read(key string) ([]QueryResult, error) {
+	if len(s.CurrentTask.ID) == 0 {
+		return nil, ErrNotSetup
+	}
+	return s.ReadTask(s.CurrentTask.ID, key)
+}
+
+func (s *MemResultStore) ReadTask(taskID string, key string) ([]QueryResult, error) {
+	it, err := s.Stream(taskID, key)
+	if err != nil {
+		return nil, err
+	}
+	var qrs []QueryResult
+	for it.Next() {
+		qr := it.Result()
+		if qr.ResultSet, err = it.ResultSet(); err != nil {
+			return nil, err
+		}
+		qrs = append(qrs, qr)
+	}
+	return qrs, nil
+}
+
+func (s *MemResultStore) Stream(taskID string, key string) (ResultIterator, error) {
+	s.data.RLock()
+	var rs []memResult
+	for _, r := range s.data.results {
+		if r.taskID == taskID && r.qr.Key == key {
+			rs = append(rs, r)
+		}
+	}
+	s.data.RUnlock()
+	sort.SliceStable(rs, func(i, j int) bool {
+		if rs[i].qr.Source != rs[j].qr.Source {
+			return rs[i].qr.Source < rs[j].qr.Source
+		}
+		if rs[i].qr.Version != rs[j].qr.Version {
+			return rs[i].qr.Version < rs[j].qr.Version
+		}
+		return rs[i].id < rs[j].id
+	})
+	qrs := make([]QueryResult, len(rs))
+	for i, r := range rs {
+		qrs[i] = r.qr
+	}
+	return newResultIter(qrs, func(i int) (*resultset.ResultSet, error) {
+		res := &resultset.ResultSet{}
+		if err := res.Decode(rs[i].raw); err != nil {
+			return nil, errors.New("decode result set: " + err.Error())
+		}
+		return res, nil
+	}), nil
+}
+
+func (s *MemResultStore) Keys() ([]string, error) {
+	if len(s.CurrentTask.ID) == 0 {
+		return nil, ErrNotSetup
+	}
+	return s.TaskKeys(s.CurrentTask.ID)
+}
+
+func (s *MemResultStore) TaskKeys(taskID string) ([]string, error) {
+	s.data.RLock()
+	defer s.data.RUnlock()
+	seen := make(map[string]bool)
+	var keys []string
+	for _, r := range s.data.results {
+		if r.taskID == taskID && !seen[r.qr.Key] {
+			seen[r.qr.Key] = true
+			keys = append(keys, r.qr.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *MemResultStore) Mark(key string, state string) error {
+	return s.MarkCheck(KeyCheck{Key: key, State: state, Time: time.Now()})
+}
+
+func (s *MemResultStore) MarkCheck(check KeyCheck) error {
+	if len(s.CurrentTask.ID) == 0 {
+		return ErrNotSetup
+	}
+	check.Time = time.Unix(check.Time.Unix(), 0)
+	if len(check.Sources) == 0 {
+		check.Sources = nil
+	}
+	if len(check.Diffs) == 0 {
+		check.Diffs = nil
+	}
+	id := s.CurrentTask.ID
+	s.data.Lock()
+	defer s.data.Unlock()
+	if s.data.states[id] == nil {
+		s.data.states[id] = make(map[string]KeyCheck)
+		s.data.checks[id] = make(map[string][]KeyCheck)
+	}
+	s.data.states[id][check.Key] = check
+	s.data.checks[id][check.Key] = append(s.data.checks[id][check.Key], check)
+	return nil
+}
+
+func (s *MemResultStore) KeyChecks(key string) ([]KeyCheck, error) {
+	if len(s.CurrentTask.ID) == 0 {
+		return nil, ErrNotSetup
+	}
+	return s.TaskKeyChecks(s.CurrentTask.ID, key)
+}
+
+func (s *MemResultStore) TaskKeyChecks(taskID string, key string) ([]KeyCheck, error) {
+	s.data.RLock()
+	defer s.data.RUnlock()
+	cs := s.data.checks[taskID][key]
+	if len(cs) == 0 {
+		return nil, nil
+	}
+	return append([]KeyCheck(nil), cs...), nil
+}
+
+func (s *MemResultStore) KeysByState(state string) ([]string, error) {
+	if len(s.CurrentTask.ID) == 0 {
+		return nil, ErrNotSetup
+	}
+	return s.TaskKeysByState(s.CurrentTask.ID, state)
+}
+
+func (s *MemResultStore) TaskKeysByState(taskID string, state string) ([]string, error) {
+	s.data.RLock()
+	defer s.data.RUnlock()
+	var keys []string
+	for k, c := range s.data.states[taskID] {
+		if c.inState(state) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *MemResultStore) Flush() error { return nil }
+
+func (s *MemResultStore) Close() error { return nil }
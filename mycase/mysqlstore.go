@@ -0,0 +1,125 @@
+package mycase
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	_ ResultStore = &MySQLResultStore{}
+)
+
+var mysqlDialect = &sqlDialect{
+	addTask: "insert ignore into `task`(`id`, `name`, `meta`, `time`) values (?, ?, ?, ?)",
+	upsertState: "insert into `key_state`(`task_id`, `key`, `state`, `checker`, `sources`, `diffs`, `perf`, `time`) values (?, ?, ?, ?, ?, ?, ?, ?) " +
+		"on duplicate key update `state` = values(`state`), `checker` = values(`checker`), `sources` = values(`sources`), `diffs` = values(`diffs`), `perf` = values(`perf`), `time` = values(`time`)",
+	addVersion: "insert ignore into `schema_version`(`version`, `time`) values (?, ?)",
+}
+
+// MySQLResultStore stores results in a MySQL compatible database, so that
+// runners on different machines can share the same storage. It uses the same
+// tables and schema versions as SQLiteResultStore.
+type MySQLResultStore struct {
+	sqlStore
+}
+
+// NewMySQLResultStore connects to the database specified by dsn, the database
+// and tables are created if they do not exist. Only WithBatchSize applies to it
+// among SQLStoreOptions.
+func NewMySQLResultStore(dsn string, opts ...SQLStoreOption) (*MySQLResultStore, error) {
+	o := newSQLStoreOptions(opts)
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, errors.New("parse dsn: " + err.Error())
+	}
+	if len(cfg.DBName) == 0 {
+		return nil, errors.New("database name is required")
+	}
+	if err = createDatabase(*cfg); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(32)
+	db.SetMaxIdleConns(8)
+	db.SetConnMaxLifetime(5 * time.Minute)
+	s := &MySQLResultStore{sqlStore{db: db, dialect: mysqlDialect, batch: &sqlBatch{size: o.BatchSize}}}
+	if err = s.bootstrap(mysqlMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Fork returns a store sharing the same connection pool but with its own
+// current task and write buffer, results written by the fork are visible to
+// others once it's flushed.
+func (s *MySQLResultStore) Fork() *MySQLResultStore {
+	return &MySQLResultStore{sqlStore{db: s.db, dialect: s.dialect, batch: &sqlBatch{size: s.batch.size}}}
+}
+
+// DB returns the underlying connection pool, it can be used to tune the pool.
+func (s *MySQLResultStore) DB() *sql.DB { return s.db }
+
+func createDatabase(cfg mysql.Config) error {
+	name := cfg.DBName
+	cfg.DBName = ""
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err = db.Exec("create database if not exists `" + name + "`"); err != nil {
+		return errors.New("create database: " + err.Error())
+	}
+	return nil
+}
+
+// mysqlMigrations upgrade the database step by step like sqliteMigrations, and
+// version i stands for the same layout on both backends. The initial MySQL
+// schema already has source identity and check details, so versions 2 and 3
+// change nothing. MySQL doesn't allow defaults of text columns, so json columns
+// added later are filled by updates instead. Released migrations must not be
+// changed, append a new one to both backends instead.
+var mysqlMigrations = []func(tx *sql.Tx) error{
+	// 1: the initial schema.
+	execAll(
+		"create table if not exists `task`(`id` varchar(191) not null, `name` text, `meta` text, `time` bigint, primary key (`id`)) default charset = utf8mb4 collate = utf8mb4_bin",
+		"create table if not exists `result`(`id` bigint not null auto_increment, `task_id` varchar(191), `key` varchar(512), `sql` longtext, `source` varchar(255), `version` varchar(255), `source_meta` text, `data_digest` varchar(64), `result` longblob, `time` bigint, `duration` double, "+
+			"primary key (`id`), key `idx_result__task_id__key` (`task_id`, `key`)) default charset = utf8mb4 collate = utf8mb4_bin",
+		"create table if not exists `key_state`(`task_id` varchar(191) not null, `key` varchar(512) not null, `state` varchar(32), `checker` text, `sources` longtext, `diffs` longtext, `time` bigint, "+
+			"primary key (`task_id`, `key`)) default charset = utf8mb4 collate = utf8mb4_bin",
+		"create table if not exists `key_check`(`id` bigint not null auto_increment, `task_id` varchar(191), `key` varchar(512), `state` varchar(32), `checker` text, `sources` longtext, `diffs` longtext, `time` bigint, "+
+			"primary key (`id`), key `idx_key_check__task_id__key` (`task_id`, `key`)) default charset = utf8mb4 collate = utf8mb4_bin",
+	),
+	// 2: source identity of results.
+	execAll(),
+	// 3: check details and history of key states.
+	execAll(),
+	// 4: performance checks.
+	func(tx *sql.Tx) error {
+		if err := addColumns("key_state", "`perf` longtext")(tx); err != nil {
+			return err
+		}
+		if err := addColumns("key_check", "`perf` longtext")(tx); err != nil {
+			return err
+		}
+		return execAll(
+			"update `key_state` set `perf` = 'null' where `perf` is null",
+			"update `key_check` set `perf` = 'null' where `perf` is null",
+		)(tx)
+	},
+	// 5: bench stats of results. Durations of bench runs may not fit in a text
+	// column, which is how unversioned databases store them.
+	func(tx *sql.Tx) error {
+		if err := addColumns("result", "`bench` mediumtext")(tx); err != nil {
+			return err
+		}
+		return execAll("alter table `result` modify `bench` mediumtext")(tx)
+	},
+}
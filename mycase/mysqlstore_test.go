@@ -0,0 +1,127 @@
+package mycase
+
+import (
+	"database/sql"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/zyguan/mytest/internal/testmysql"
+)
+
+func init() {
+	storeOpeners["MySQL"] = openMySQLStore
+}
+
+// openMySQLStore opens a store on a fresh database.
+func openMySQLStore(t *testing.T) (ResultStore, func()) {
+	cfg, dropDB := testmysql.DB(t)
+	s, err := NewMySQLResultStore(cfg.FormatDSN(), WithBatchSize(16))
+	if err != nil {
+		dropDB()
+		t.Fatalf("open mysql store: %v", err)
+	}
+	return s, func() {
+		s.Close()
+		dropDB()
+	}
+}
+
+// unversionedMySQLSchema is the layout created by bootstrap before
+// schema_version was introduced.
+var unversionedMySQLSchema = []string{
+	"create table `task`(`id` varchar(191) not null, `name` text, `meta` text, `time` bigint, primary key (`id`)) default charset = utf8mb4 collate = utf8mb4_bin",
+	"create table `result`(`id` bigint not null auto_increment, `task_id` varchar(191), `key` varchar(512), `sql` longtext, `source` varchar(255), `version` varchar(255), `source_meta` text, `data_digest` varchar(64), `result` longblob, `time` bigint, `duration` double, `bench` text, " +
+		"primary key (`id`), key `idx_result__task_id__key` (`task_id`, `key`)) default charset = utf8mb4 collate = utf8mb4_bin",
+	"create table `key_state`(`task_id` varchar(191) not null, `key` varchar(512) not null, `state` varchar(32), `checker` text, `sources` longtext, `diffs` longtext, `perf` longtext, `time` bigint, " +
+		"primary key (`task_id`, `key`)) default charset = utf8mb4 collate = utf8mb4_bin",
+	"create table `key_check`(`id` bigint not null auto_increment, `task_id` varchar(191), `key` varchar(512), `state` varchar(32), `checker` text, `sources` longtext, `diffs` longtext, `perf` longtext, `time` bigint, " +
+		"primary key (`id`), key `idx_key_check__task_id__key` (`task_id`, `key`)) default charset = utf8mb4 collate = utf8mb4_bin",
+}
+
+func TestMySQLMigrations(t *testing.T) {
+	assert.Equal(t, len(sqliteMigrations), len(mysqlMigrations))
+}
+
+func TestMySQLResultStore_MigrateUnversioned(t *testing.T) {
+	raw, err := testResult("k", "a", "1").ResultSet.Encode()
+	assert.NoError(t, err)
+	cfg, dropDB := testmysql.DB(t)
+	defer dropDB()
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	assert.NoError(t, err)
+	for _, stmt := range append(unversionedMySQLSchema,
+		"insert into `task`(`id`, `name`, `meta`, `time`) values ('t1', 'old', '', 1573430400)",
+		"insert into `key_state`(`task_id`, `key`, `state`, `checker`, `sources`, `diffs`, `perf`, `time`) values ('t1', 'k', 'FAIL', '', '[\"a\"]', 'null', 'null', 1573430460)",
+		"insert into `key_check`(`task_id`, `key`, `state`, `checker`, `sources`, `diffs`, `perf`, `time`) values ('t1', 'k', 'FAIL', '', '[\"a\"]', 'null', 'null', 1573430460)",
+	) {
+		_, err = db.Exec(stmt)
+		assert.NoError(t, err, stmt)
+	}
+	_, err = db.Exec("insert into `result`(`task_id`, `key`, `sql`, `source`, `version`, `source_meta`, `data_digest`, `result`, `time`, `duration`, `bench`) values ('t1', 'k', 'select 1', 'a', 'v1', '', '', ?, 1573430460, 0.5, 'null')", raw)
+	assert.NoError(t, err)
+
+	store, err := NewMySQLResultStore(cfg.FormatDSN())
+	assert.NoError(t, err)
+	defer store.Close()
+	v, err := store.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, len(mysqlMigrations), v)
+	var typ string
+	assert.NoError(t, db.QueryRow("select `data_type` from `information_schema`.`columns` where `table_schema` = ? and `table_name` = 'result' and `column_name` = 'bench'", cfg.DBName).Scan(&typ))
+	assert.Equal(t, "mediumtext", typ)
+	assert.NoError(t, db.Close())
+
+	assert.NoError(t, store.Setup(TaskInfo{ID: "t1"}))
+	assert.NoError(t, store.Write(testResult("k", "b", "1")))
+	qrs, err := store.Read("k")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(qrs))
+	assert.Equal(t, "a", qrs[0].SourceID())
+	assert.Nil(t, qrs[0].Bench)
+	assert.NoError(t, store.MarkCheck(KeyCheck{Key: "k", State: StateOK, Sources: []string{"a", "b"}, Time: time.Unix(1573430520, 0)}))
+	cs, err := store.KeyChecks("k")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(cs))
+	assert.Equal(t, StateFail, cs[0].State)
+	assert.Nil(t, cs[0].Perf)
+	assert.Equal(t, []string{"a", "b"}, cs[1].Sources)
+
+	// bootstrapping again is a no-op.
+	store2, err := NewMySQLResultStore(cfg.FormatDSN())
+	assert.NoError(t, err)
+	assert.NoError(t, store2.Close())
+}
+
+func TestMySQLResultStoreConcurrentWriters(t *testing.T) {
+	rc, closeStore := openMySQLStore(t)
+	defer closeStore()
+	store := rc.(*MySQLResultStore)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := store.Fork()
+			assert.NoError(t, s.Setup(TaskInfo{ID: "t1"}))
+			for j := 0; j < 10; j++ {
+				key := "k" + strconv.Itoa(j)
+				assert.NoError(t, s.Write(testResult(key, "s"+strconv.Itoa(i), "v")))
+				assert.NoError(t, s.Mark(key, StateOK))
+			}
+			assert.NoError(t, s.Flush())
+		}(i)
+	}
+	wg.Wait()
+
+	sum, err := store.TaskSummary("t1")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, sum.Keys)
+	assert.Equal(t, 80, sum.Results)
+	assert.Equal(t, 8, len(sum.Sources))
+	assert.Equal(t, map[string]int{StateOK: 10}, sum.States)
+}
@@ -0,0 +1,202 @@
+package mycase
+
+import (
+	"math"
+	"sort"
+)
+
+// StateSlow is the performance state of keys having significant slowdowns,
+// keys without slowdowns are in StateOK.
+const StateSlow = "SLOW"
+
+// PerfCheck records how durations of a key were checked.
+type PerfCheck struct {
+	State     string     `json:"state"`
+	Slowdowns []Slowdown `json:"slowdowns,omitempty"`
+}
+
+// Slowdown tells that Source is slower than Reference, which is another source
+// of the same task or the same source of the Baseline task. Durations are the
+// medians of repeated runs in seconds.
+type Slowdown struct {
+	Source    string  `json:"source"`
+	Reference string  `json:"reference"`
+	Baseline  string  `json:"baseline,omitempty"`
+	Duration  float64 `json:"duration"`
+	RefTime   float64 `json:"ref_time"`
+	Ratio     float64 `json:"ratio"`
+	PValue    float64 `json:"p_value,omitempty"`
+}
+
+// PerfOptions decides what counts as a slowdown. A source is slower than its
+// reference if its median duration is at least Ratio times and MinTime seconds
+// more than the reference one. If both sides have repeated runs, the slowdown
+// must also be significant at level Alpha by the one-sided Mann-Whitney U test.
+type PerfOptions struct {
+	Ratio   float64
+	MinTime float64
+	Alpha   float64
+}
+
+// DefaultPerfOptions flags slowdowns of at least 1.5x and 10ms.
+var DefaultPerfOptions = PerfOptions{Ratio: 1.5, MinTime: 0.01, Alpha: 0.05}
+
+// AnalyzePerf compares durations of results of a key across sources and
+// against results of the baseline task. Sources of rs are compared with the
+// one at ref, and each source is compared with the same source in base.
+func AnalyzePerf(rs []QueryResult, ref int, base []QueryResult, baseline string, o PerfOptions) PerfCheck {
+	pc := PerfCheck{State: StateOK}
+	cur, baseDurs := durationsBySource(rs), durationsBySource(base)
+	refID := ""
+	if ref >= 0 && ref < len(rs) {
+		refID = rs[ref].SourceID()
+	}
+	for _, id := range sortedSources(cur) {
+		if id != refID && len(refID) > 0 {
+			if sd, ok := slowdown(cur[id], cur[refID], o); ok {
+				sd.Source, sd.Reference = id, refID
+				pc.Slowdowns = append(pc.Slowdowns, sd)
+			}
+		}
+		if ds, ok := baseDurs[id]; ok {
+			if sd, ok := slowdown(cur[id], ds, o); ok {
+				sd.Source, sd.Reference, sd.Baseline = id, id, baseline
+				pc.Slowdowns = append(pc.Slowdowns, sd)
+			}
+		}
+	}
+	if len(pc.Slowdowns) > 0 {
+		pc.State = StateSlow
+	}
+	return pc
+}
+
+// BenchStats summarizes durations of repeated runs of a query in seconds.
+// Durations are kept in the order of runs, so that they can be tested by
+// AnalyzePerf, stats written before they were kept have none.
+type BenchStats struct {
+	Runs      int       `json:"runs"`
+	Min       float64   `json:"min"`
+	Median    float64   `json:"median"`
+	P95       float64   `json:"p95"`
+	Max       float64   `json:"max"`
+	Durations []float64 `json:"durations,omitempty"`
+}
+
+// NewBenchStats summarizes durations, it returns nil if there is none.
+func NewBenchStats(durs []float64) *BenchStats {
+	if len(durs) == 0 {
+		return nil
+	}
+	s := append([]float64(nil), durs...)
+	sort.Float64s(s)
+	// p95 is picked by the nearest-rank method.
+	p95 := int(math.Ceil(0.95*float64(len(s)))) - 1
+	return &BenchStats{Runs: len(s), Min: s[0], Median: median(s), P95: s[p95], Max: s[len(s)-1], Durations: append([]float64(nil), durs...)}
+}
+
+// durationsBySource collects durations of results by sources, every run of a
+// benched result counts as a sample.
+func durationsBySource(rs []QueryResult) map[string][]float64 {
+	m := make(map[string][]float64)
+	for _, r := range rs {
+		if r.Bench != nil && len(r.Bench.Durations) > 0 {
+			m[r.SourceID()] = append(m[r.SourceID()], r.Bench.Durations...)
+		} else {
+			m[r.SourceID()] = append(m[r.SourceID()], r.Duration)
+		}
+	}
+	return m
+}
+
+func sortedSources(m map[string][]float64) []string {
+	ids := make([]string, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func slowdown(xs []float64, ys []float64, o PerfOptions) (Slowdown, bool) {
+	sd := Slowdown{Duration: median(xs), RefTime: median(ys)}
+	if sd.Duration-sd.RefTime < o.MinTime || sd.Duration < o.Ratio*sd.RefTime {
+		return sd, false
+	}
+	if sd.RefTime > 0 {
+		sd.Ratio = sd.Duration / sd.RefTime
+	} else {
+		sd.Ratio = math.Inf(1)
+	}
+	if len(xs) > 1 && len(ys) > 1 {
+		sd.PValue = mannWhitneyP(xs, ys)
+		if sd.PValue > o.Alpha {
+			return sd, false
+		}
+	}
+	return sd, true
+}
+
+func median(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	if n := len(s); n%2 == 0 {
+		return (s[n/2-1] + s[n/2]) / 2
+	}
+	return s[len(s)/2]
+}
+
+// mannWhitneyP returns the p-value of the one-sided Mann-Whitney U test whose
+// alternative is that xs tends to be greater than ys. Exact distribution is used
+// for small samples, normal approximation for large ones.
+func mannWhitneyP(xs []float64, ys []float64) float64 {
+	n1, n2 := len(xs), len(ys)
+	u := 0.0
+	for _, x := range xs {
+		for _, y := range ys {
+			if x > y {
+				u++
+			} else if x == y {
+				u += 0.5
+			}
+		}
+	}
+	if n1*n2 > 400 {
+		mu := float64(n1*n2) / 2
+		sigma := math.Sqrt(float64(n1*n2*(n1+n2+1)) / 12)
+		z := (u - 0.5 - mu) / sigma
+		return 0.5 * math.Erfc(z/math.Sqrt2)
+	}
+	// cnt[i][j][k] is the number of arrangements of i xs and j ys whose U is k.
+	cnt := make([][][]float64, n1+1)
+	for i := range cnt {
+		cnt[i] = make([][]float64, n2+1)
+		for j := range cnt[i] {
+			cnt[i][j] = make([]float64, i*j+1)
+			if i == 0 || j == 0 {
+				cnt[i][j][0] = 1
+				continue
+			}
+			for k := range cnt[i][j] {
+				// the largest value is either an x, which beats all j ys, or a y.
+				if k >= j {
+					cnt[i][j][k] += cnt[i-1][j][k-j]
+				}
+				if k < len(cnt[i][j-1]) {
+					cnt[i][j][k] += cnt[i][j-1][k]
+				}
+			}
+		}
+	}
+	total, tail := 0.0, 0.0
+	for k, c := range cnt[n1][n2] {
+		total += c
+		if float64(k) >= u {
+			tail += c
+		}
+	}
+	return tail / total
+}
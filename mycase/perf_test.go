@@ -0,0 +1,115 @@
+package mycase
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMannWhitneyP(t *testing.T) {
+	assert.InDelta(t, 0.05, mannWhitneyP([]float64{4, 5, 6}, []float64{1, 2, 3}), 1e-9)
+	assert.InDelta(t, 1.0, mannWhitneyP([]float64{1, 2, 3}, []float64{4, 5, 6}), 1e-9)
+	assert.InDelta(t, 4.0/6, mannWhitneyP([]float64{1, 2}, []float64{1, 2}), 1e-9)
+	assert.InDelta(t, 0.5, mannWhitneyP([]float64{2, 3, 4}, []float64{1, 2.5, 5}), 1e-9)
+
+	xs, ys := make([]float64, 30), make([]float64, 30)
+	for i := range xs {
+		xs[i], ys[i] = float64(i+30), float64(i)
+	}
+	assert.True(t, mannWhitneyP(xs, ys) < 1e-6)
+	assert.True(t, mannWhitneyP(ys, xs) > 0.99)
+}
+
+func TestNewBenchStats(t *testing.T) {
+	assert.Nil(t, NewBenchStats(nil))
+	assert.Equal(t, &BenchStats{Runs: 1, Min: 2, Median: 2, P95: 2, Max: 2, Durations: []float64{2}}, NewBenchStats([]float64{2}))
+	durs := []float64{4, 1, 3, 2}
+	assert.Equal(t, &BenchStats{Runs: 4, Min: 1, Median: 2.5, P95: 4, Max: 4, Durations: []float64{4, 1, 3, 2}}, NewBenchStats(durs))
+	assert.Equal(t, []float64{4, 1, 3, 2}, durs)
+
+	durs = make([]float64, 100)
+	for i := range durs {
+		durs[i] = float64(100 - i)
+	}
+	assert.Equal(t, &BenchStats{Runs: 100, Min: 1, Median: 50.5, P95: 95, Max: 100, Durations: durs}, NewBenchStats(durs))
+}
+
+func TestAnalyzePerf(t *testing.T) {
+	result := func(src string, d float64) QueryResult { return QueryResult{Source: src, Duration: d} }
+	o := PerfOptions{Ratio: 1.5, MinTime: 0.01, Alpha: 0.05}
+
+	pc := AnalyzePerf([]QueryResult{result("a", 0.1), result("b", 0.3), result("c", 0.12), result("d", 0.001)}, 0, nil, "", o)
+	assert.Equal(t, PerfCheck{State: StateSlow, Slowdowns: []Slowdown{{Source: "b", Reference: "a", Duration: 0.3, RefTime: 0.1, Ratio: 3}}}, roundRatios(pc))
+
+	// tiny statements are never slow.
+	pc = AnalyzePerf([]QueryResult{result("a", 0.001), result("b", 0.005)}, 0, nil, "", o)
+	assert.Equal(t, PerfCheck{State: StateOK}, pc)
+
+	// repeated runs must differ significantly.
+	rs := []QueryResult{result("a", 0.1), result("a", 0.1), result("a", 0.5), result("b", 0.3), result("b", 0.09), result("b", 0.4)}
+	pc = AnalyzePerf(rs, 0, nil, "", o)
+	assert.Equal(t, StateOK, pc.State)
+	rs = []QueryResult{result("a", 0.1), result("a", 0.11), result("a", 0.12), result("a", 0.1), result("b", 0.3), result("b", 0.31), result("b", 0.4), result("b", 0.35)}
+	pc = AnalyzePerf(rs, 0, nil, "", o)
+	assert.Equal(t, StateSlow, pc.State)
+	assert.InDelta(t, 1.0/70, pc.Slowdowns[0].PValue, 1e-9)
+
+	// runs of benched results are samples of their sources.
+	bench := func(src string, durs ...float64) QueryResult {
+		qr := QueryResult{Source: src, Bench: NewBenchStats(durs)}
+		qr.Duration = qr.Bench.Median
+		return qr
+	}
+	pc = AnalyzePerf([]QueryResult{bench("a", 0.1, 0.09, 0.5, 0.1), bench("b", 0.3, 0.08, 0.4, 0.2)}, 0, nil, "", o)
+	assert.Equal(t, StateOK, pc.State)
+	pc = AnalyzePerf([]QueryResult{bench("a", 0.1, 0.11, 0.12, 0.1), bench("b", 0.3, 0.31, 0.4, 0.35)}, 0, nil, "", o)
+	assert.Equal(t, StateSlow, pc.State)
+	assert.InDelta(t, 1.0/70, pc.Slowdowns[0].PValue, 1e-9)
+	assert.InDelta(t, 0.105, pc.Slowdowns[0].RefTime, 1e-9)
+	// against the baseline as well.
+	pc = AnalyzePerf([]QueryResult{bench("a", 0.3, 0.31, 0.4, 0.35)}, 0, []QueryResult{bench("a", 0.1, 0.11, 0.12, 0.1)}, "t0", o)
+	assert.Equal(t, StateSlow, pc.State)
+	assert.Equal(t, "t0", pc.Slowdowns[0].Baseline)
+	assert.InDelta(t, 1.0/70, pc.Slowdowns[0].PValue, 1e-9)
+	// stats without durations count as a single sample of the median.
+	old := bench("b", 0.3, 0.08, 0.4, 0.2)
+	old.Bench.Durations = nil
+	pc = AnalyzePerf([]QueryResult{bench("a", 0.1, 0.09, 0.5, 0.1), old}, 0, nil, "", o)
+	assert.Equal(t, StateSlow, pc.State)
+	assert.Zero(t, pc.Slowdowns[0].PValue)
+
+	pc = AnalyzePerf([]QueryResult{result("a", 0.1), result("b", 0.1)}, 1, []QueryResult{result("a", 0.05), result("b", 0.1)}, "t0", o)
+	assert.Equal(t, PerfCheck{State: StateSlow, Slowdowns: []Slowdown{{Source: "a", Reference: "a", Baseline: "t0", Duration: 0.1, RefTime: 0.05, Ratio: 2}}}, roundRatios(pc))
+}
+
+func roundRatios(pc PerfCheck) PerfCheck {
+	for i := range pc.Slowdowns {
+		pc.Slowdowns[i].Ratio = float64(int(pc.Slowdowns[i].Ratio*1000+0.5)) / 1000
+	}
+	return pc
+}
+
+func TestRunWithPerfCheck(t *testing.T) {
+	store := NewMemResultStore()
+	mc := &fakeCase{
+		name:      "perf",
+		results:   map[string][]string{"k1": {"a", "a"}, "k2": {"a", "b"}},
+		durations: map[string][]float64{"k1": {0.1, 0.5}, "k2": {0.1, 0.1}},
+	}
+	err := Run(mc, store, WithPerfCheck(DefaultPerfOptions))
+	assert.Error(t, err)
+	cs, err := store.KeyChecks("k1")
+	assert.NoError(t, err)
+	assert.Equal(t, StateOK, cs[0].State)
+	assert.Equal(t, StateSlow, cs[0].Perf.State)
+	assert.Equal(t, "v1", cs[0].Perf.Slowdowns[0].Source)
+	cs, err = store.KeyChecks("k2")
+	assert.NoError(t, err)
+	assert.Equal(t, StateFail, cs[0].State)
+	assert.Equal(t, &PerfCheck{State: StateOK}, cs[0].Perf)
+
+	assert.NoError(t, Run(&fakeCase{name: "perf", results: map[string][]string{"k1": {"a", "a"}}}, store))
+	cs, err = store.KeyChecks("k1")
+	assert.NoError(t, err)
+	assert.Nil(t, cs[0].Perf)
+}
@@ -0,0 +1,794 @@
+package mycase
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zyguan/mytest/resultset"
+)
+
+// sqlDialect holds statements that differ between sql databases.
+type sqlDialect struct {
+	addTask     string
+	upsertState string
+	addVersion  string
+}
+
+// SQLStoreOptions are options of SQLiteResultStore and MySQLResultStore,
+// options specific to one of them are ignored by the other.
+type SQLStoreOptions struct {
+	JournalMode string
+	BusyTimeout time.Duration
+	BatchSize   int
+	// MaxOpenConns limits connections of SQLite stores, 0 means no limit.
+	MaxOpenConns int
+}
+
+type SQLStoreOption func(SQLStoreOptions) SQLStoreOptions
+
+// WithBatchSize sets how many results are buffered before they are written in
+// one transaction, a size no more than 1 disables buffering.
+func WithBatchSize(n int) SQLStoreOption {
+	return func(o SQLStoreOptions) SQLStoreOptions {
+		o.BatchSize = n
+		return o
+	}
+}
+
+func newSQLStoreOptions(opts []SQLStoreOption) SQLStoreOptions {
+	o := SQLStoreOptions{JournalMode: "WAL", BusyTimeout: 5 * time.Second, BatchSize: 128}
+	for _, f := range opts {
+		o = f(o)
+	}
+	return o
+}
+
+// sqlBatch buffers results to be written in a single transaction, every fork
+// of a store has its own one, so that failures of its rows are reported to the
+// fork which wrote them. Writers sharing a fork are told apart by sources of
+// their results, failures of a source are kept in dropped until they are
+// reported to the next write of the source or to Flush.
+type sqlBatch struct {
+	sync.Mutex
+	size    int
+	rows    []sqlRow
+	dropped map[string][]string
+}
+
+// sqlRow is a buffered result, earlier results of the same key and source are
+// deleted before inserting it if replace is set.
+type sqlRow struct {
+	args    []interface{}
+	replace bool
+}
+
+// sqlStore implements ResultStore on a sql database, it is shared by the
+// SQLite and MySQL result stores. Results are buffered and written in batches,
+// pending results are flushed before being queried.
+type sqlStore struct {
+	CurrentTask TaskInfo
+
+	db      *sql.DB
+	dialect *sqlDialect
+	batch   *sqlBatch
+}
+
+func (s *sqlStore) Setup(info TaskInfo) error {
+	if len(info.ID) == 0 {
+		return errors.New("id is required")
+	}
+	// add the task unless it exists, runners may setup the same task at the same time.
+	res, err := s.db.Exec(s.dialect.addTask, info.ID, info.Name, string(info.Meta), info.Time.Unix())
+	if err != nil {
+		return errors.New("add task: " + err.Error())
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if info, err = s.GetTask(info.ID); err != nil {
+			return err
+		}
+	}
+	s.CurrentTask = info
+	return nil
+}
+
+// ListTasks returns matched tasks, the latest one comes first.
+func (s *sqlStore) ListTasks(filter TaskFilter) ([]TaskInfo, error) {
+	q := "select `id`, `name`, `meta`, `time` from `task` where 1 = 1"
+	var args []interface{}
+	if len(filter.Name) > 0 {
+		q += " and `name` = ?"
+		args = append(args, filter.Name)
+	}
+	if len(filter.Meta) > 0 {
+		q += " and instr(`meta`, ?) > 0"
+		args = append(args, filter.Meta)
+	}
+	if !filter.Since.IsZero() {
+		q += " and `time` >= ?"
+		args = append(args, filter.Since.Unix())
+	}
+	if !filter.Until.IsZero() {
+		q += " and `time` < ?"
+		args = append(args, filter.Until.Unix())
+	}
+	q += " order by `time` desc, `id`"
+	if filter.Limit > 0 {
+		q += " limit ?"
+		args = append(args, filter.Limit)
+	}
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, errors.New("query tasks: " + err.Error())
+	}
+	defer rows.Close()
+	var tasks []TaskInfo
+	for rows.Next() {
+		var info TaskInfo
+		var m []byte
+		var t int64
+		if err = rows.Scan(&info.ID, &info.Name, &m, &t); err != nil {
+			return nil, errors.New("scan task row: " + err.Error())
+		}
+		info.Time = time.Unix(t, 0)
+		info.Meta = m
+		tasks = append(tasks, info)
+	}
+	return tasks, rows.Err()
+}
+
+func (s *sqlStore) GetTask(id string) (TaskInfo, error) {
+	info := TaskInfo{ID: id}
+	var m []byte
+	var t int64
+	err := s.db.QueryRow("select `name`, `meta`, `time` from `task` where `id` = ?", id).Scan(&info.Name, &m, &t)
+	if err == sql.ErrNoRows {
+		return info, ErrTaskNotFound
+	} else if err != nil {
+		return info, errors.New("query task: " + err.Error())
+	}
+	info.Time = time.Unix(t, 0)
+	info.Meta = m
+	return info, nil
+}
+
+func (s *sqlStore) TaskSummary(id string) (TaskSummary, error) {
+	var (
+		sum TaskSummary
+		err error
+	)
+	if sum.Info, err = s.GetTask(id); err != nil {
+		return sum, err
+	}
+	if err = s.persist(); err != nil {
+		return sum, err
+	}
+	var d sql.NullFloat64
+	err = s.db.QueryRow("select count(distinct `key`), count(1), sum(`duration`) from `result` where `task_id` = ?", id).Scan(&sum.Keys, &sum.Results, &d)
+	if err != nil {
+		return sum, errors.New("query results: " + err.Error())
+	}
+	sum.Duration = d.Float64
+	rows, err := s.db.Query("select distinct case when `source` = '' then `version` else `source` end as `src` from `result` where `task_id` = ? order by `src`", id)
+	if err != nil {
+		return sum, errors.New("query sources: " + err.Error())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var src string
+		if err = rows.Scan(&src); err != nil {
+			return sum, errors.New("scan result row: " + err.Error())
+		}
+		sum.Sources = append(sum.Sources, src)
+	}
+	if err = rows.Err(); err != nil {
+		return sum, err
+	}
+	rows, err = s.db.Query("select `state`, count(1) from `key_state` where `task_id` = ? group by `state`", id)
+	if err != nil {
+		return sum, errors.New("query key states: " + err.Error())
+	}
+	defer rows.Close()
+	sum.States = make(map[string]int)
+	for rows.Next() {
+		var state string
+		var cnt int
+		if err = rows.Scan(&state, &cnt); err != nil {
+			return sum, errors.New("scan key_state row: " + err.Error())
+		}
+		sum.States[state] = cnt
+	}
+	return sum, rows.Err()
+}
+
+// DeleteTask removes the task with all its results and key states.
+func (s *sqlStore) DeleteTask(id string) error {
+	if err := s.persist(); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.New("begin txn: " + err.Error())
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		"delete from `result` where `task_id` = ?",
+		"delete from `key_state` where `task_id` = ?",
+		"delete from `key_check` where `task_id` = ?",
+		"delete from `task` where `id` = ?",
+	} {
+		if _, err = tx.Exec(stmt, id); err != nil {
+			return fmt.Errorf("delete task: %s while executing %s", err.Error(), stmt)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.New("commit txn: " + err.Error())
+	}
+	if s.CurrentTask.ID == id {
+		s.CurrentTask = TaskInfo{}
+	}
+	return nil
+}
+
+func (s *sqlStore) RenameTask(id string, newID string) error {
+	if err := s.persist(); err != nil {
+		return err
+	}
+	if _, err := s.GetTask(newID); err == nil {
+		return ErrTaskExists
+	} else if err != ErrTaskNotFound {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.New("begin txn: " + err.Error())
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("update `task` set `id` = ? where `id` = ?", newID, id)
+	if err != nil {
+		return errors.New("rename task: " + err.Error())
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTaskNotFound
+	}
+	for _, stmt := range []string{
+		"update `result` set `task_id` = ? where `task_id` = ?",
+		"update `key_state` set `task_id` = ? where `task_id` = ?",
+		"update `key_check` set `task_id` = ? where `task_id` = ?",
+	} {
+		if _, err = tx.Exec(stmt, newID, id); err != nil {
+			return fmt.Errorf("rename task: %s while executing %s", err.Error(), stmt)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.New("commit txn: " + err.Error())
+	}
+	if s.CurrentTask.ID == id {
+		s.CurrentTask.ID = newID
+	}
+	return nil
+}
+
+// PruneTasks deletes tasks started before olderThan ago and returns the number
+// of deleted tasks.
+func (s *sqlStore) PruneTasks(olderThan time.Duration) (int, error) {
+	tasks, err := s.ListTasks(TaskFilter{Until: time.Now().Add(-olderThan)})
+	if err != nil {
+		return 0, err
+	}
+	for i, t := range tasks {
+		if err = s.DeleteTask(t.ID); err != nil {
+			return i, err
+		}
+	}
+	return len(tasks), nil
+}
+
+func (s *sqlStore) Write(res QueryResult) error { return s.write(res, false) }
+
+func (s *sqlStore) Replace(res QueryResult) error { return s.write(res, true) }
+
+func (s *sqlStore) write(res QueryResult, replace bool) error {
+	if len(s.CurrentTask.ID) == 0 {
+		return ErrNotSetup
+	}
+	args := make([]interface{}, 11)
+	var err error
+	args[7], err = res.ResultSet.Encode()
+	if err != nil {
+		return errors.New("encode result set: " + err.Error())
+	}
+	bench, err := json.Marshal(res.Bench)
+	if err != nil {
+		return errors.New("encode bench: " + err.Error())
+	}
+	args[0] = s.CurrentTask.ID
+	args[1] = res.Key
+	args[2] = res.SQL
+	args[3] = res.Source
+	args[4] = res.Version
+	args[5] = string(res.SourceMeta)
+	args[6] = res.ResultSet.DataDigest()
+	args[8] = res.Time.Unix()
+	args[9] = res.Duration
+	args[10] = string(bench)
+	s.batch.Lock()
+	defer s.batch.Unlock()
+	s.batch.rows = append(s.batch.rows, sqlRow{args: args, replace: replace})
+	if len(s.batch.rows) >= s.batch.size {
+		if err = s.flush(); err != nil {
+			return err
+		}
+	}
+	return s.takeDropped(res.SourceID())
+}
+
+// Flush writes buffered results in a single transaction. Results are kept in
+// the buffer if the transaction fails, so that they can be flushed again later,
+// except those failed to be inserted, which are dropped. Flush reports all
+// dropped results which have not been reported to writes of their sources.
+func (s *sqlStore) Flush() error {
+	s.batch.Lock()
+	defer s.batch.Unlock()
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return s.takeDropped("")
+}
+
+// persist is like Flush but leaves dropped results to be reported to their
+// writers, it's used before querying results.
+func (s *sqlStore) persist() error {
+	s.batch.Lock()
+	defer s.batch.Unlock()
+	return s.flush()
+}
+
+func (s *sqlStore) flush() error {
+	for len(s.batch.rows) > 0 {
+		i, err := s.insertResults(s.batch.rows)
+		if err == nil {
+			s.batch.rows = nil
+		} else if i < 0 {
+			return err
+		} else {
+			row := s.batch.rows[i]
+			src := row.args[3].(string)
+			if len(src) == 0 {
+				src = row.args[4].(string)
+			}
+			if s.batch.dropped == nil {
+				s.batch.dropped = make(map[string][]string)
+			}
+			s.batch.dropped[src] = append(s.batch.dropped[src], fmt.Sprintf("%s of %s: %s", row.args[1], src, err.Error()))
+			s.batch.rows = append(s.batch.rows[:i:i], s.batch.rows[i+1:]...)
+		}
+	}
+	return nil
+}
+
+// takeDropped returns an error of results of the source which are dropped and
+// forgets them, results of all sources are taken if src is empty.
+func (s *sqlStore) takeDropped(src string) error {
+	var srcs []string
+	if len(src) > 0 {
+		srcs = []string{src}
+	} else {
+		for src := range s.batch.dropped {
+			srcs = append(srcs, src)
+		}
+		sort.Strings(srcs)
+	}
+	var dropped []string
+	for _, src := range srcs {
+		dropped = append(dropped, s.batch.dropped[src]...)
+		delete(s.batch.dropped, src)
+	}
+	if len(dropped) > 0 {
+		return errors.New("drop results: " + strings.Join(dropped, "; "))
+	}
+	return nil
+}
+
+// insertResults inserts rows in a transaction, it returns the index of the row
+// failed to be inserted, or -1 if the transaction itself fails.
+func (s *sqlStore) insertResults(rows []sqlRow) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return -1, errors.New("begin txn: " + err.Error())
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare("insert into `result`(`task_id`, `key`, `sql`, `source`, `version`, `source_meta`, `data_digest`, `result`, `time`, `duration`, `bench`) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return -1, errors.New("prepare insert: " + err.Error())
+	}
+	defer stmt.Close()
+	for i, row := range rows {
+		if row.replace {
+			if _, err = tx.Exec("delete from `result` where `task_id` = ? and `key` = ? and `source` = ? and (`source` != '' or `version` = ?)", row.args[0], row.args[1], row.args[3], row.args[4]); err != nil {
+				return i, err
+			}
+		}
+		if _, err = stmt.Exec(row.args...); err != nil {
+			return i, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return -1, errors.New("commit txn: " + err.Error())
+	}
+	return len(rows), nil
+}
+
+func (s *sqlStore) Read(key string) ([]QueryResult, error) {
+	if len(s.CurrentTask.ID) == 0 {
+		return nil, ErrNotSetup
+	}
+	return s.ReadTask(s.CurrentTask.ID, key)
+}
+
+func (s *sqlStore) ReadTask(taskID string, key string) ([]QueryResult, error) {
+	if err := s.persist(); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query("select `sql`, `source`, `version`, `source_meta`, `result`, `time`, `duration`, `bench` from `result` where `task_id` = ? and `key` = ? order by `source`, `version`, `id`", taskID, key)
+	if err != nil {
+		return nil, errors.New("query result: " + err.Error())
+	}
+	defer rows.Close()
+	var qrs []QueryResult
+	for rows.Next() {
+		var raw, meta, bench []byte
+		var ts int64
+		qr := QueryResult{Key: key}
+		err = rows.Scan(&qr.SQL, &qr.Source, &qr.Version, &meta, &raw, &ts, &qr.Duration, &bench)
+		if err != nil {
+			return nil, errors.New("scan result row: " + err.Error())
+		}
+		qr.Time = time.Unix(ts, 0)
+		if len(meta) > 0 {
+			qr.SourceMeta = meta
+		}
+		if qr.Bench, err = decodeBench(bench); err != nil {
+			return nil, err
+		}
+		qr.ResultSet = &resultset.ResultSet{}
+		if err = qr.ResultSet.Decode(raw); err != nil {
+			return nil, errors.New("decode result set: " + err.Error())
+		}
+		qrs = append(qrs, qr)
+	}
+	return qrs, rows.Err()
+}
+
+func (s *sqlStore) Stream(taskID string, key string) (ResultIterator, error) {
+	if err := s.persist(); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query("select `id`, `sql`, `source`, `version`, `source_meta`, `time`, `duration`, `bench` from `result` where `task_id` = ? and `key` = ? order by `source`, `version`, `id`", taskID, key)
+	if err != nil {
+		return nil, errors.New("query result: " + err.Error())
+	}
+	defer rows.Close()
+	var (
+		ids []int64
+		qrs []QueryResult
+	)
+	for rows.Next() {
+		var (
+			id, ts      int64
+			meta, bench []byte
+		)
+		qr := QueryResult{Key: key}
+		if err = rows.Scan(&id, &qr.SQL, &qr.Source, &qr.Version, &meta, &ts, &qr.Duration, &bench); err != nil {
+			return nil, errors.New("scan result row: " + err.Error())
+		}
+		qr.Time = time.Unix(ts, 0)
+		if len(meta) > 0 {
+			qr.SourceMeta = meta
+		}
+		if qr.Bench, err = decodeBench(bench); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+		qrs = append(qrs, qr)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	// result sets are fetched one by one, so that no cursor is held while
+	// iterating, which may block other queries on the same connection.
+	return newResultIter(qrs, func(i int) (*resultset.ResultSet, error) {
+		var raw []byte
+		if err := s.db.QueryRow("select `result` from `result` where `id` = ?", ids[i]).Scan(&raw); err != nil {
+			return nil, errors.New("query result: " + err.Error())
+		}
+		rs := &resultset.ResultSet{}
+		if err := rs.Decode(raw); err != nil {
+			return nil, errors.New("decode result set: " + err.Error())
+		}
+		return rs, nil
+	}), nil
+}
+
+func (s *sqlStore) Keys() ([]string, error) {
+	if len(s.CurrentTask.ID) == 0 {
+		return nil, ErrNotSetup
+	}
+	return s.TaskKeys(s.CurrentTask.ID)
+}
+
+func (s *sqlStore) TaskKeys(taskID string) ([]string, error) {
+	if err := s.persist(); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query("select distinct `key` from `result` where `task_id` = ? order by `key`", taskID)
+	if err != nil {
+		return nil, errors.New("query keys: " + err.Error())
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return nil, errors.New("scan result row: " + err.Error())
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *sqlStore) Mark(key string, state string) error {
+	return s.MarkCheck(KeyCheck{Key: key, State: state, Time: time.Now()})
+}
+
+func (s *sqlStore) MarkCheck(check KeyCheck) error {
+	if len(s.CurrentTask.ID) == 0 {
+		return ErrNotSetup
+	}
+	if len(check.Sources) == 0 {
+		check.Sources = nil
+	}
+	if len(check.Diffs) == 0 {
+		check.Diffs = nil
+	}
+	sources, err := json.Marshal(check.Sources)
+	if err != nil {
+		return errors.New("encode sources: " + err.Error())
+	}
+	diffs, err := json.Marshal(check.Diffs)
+	if err != nil {
+		return errors.New("encode diffs: " + err.Error())
+	}
+	perf, err := json.Marshal(check.Perf)
+	if err != nil {
+		return errors.New("encode perf: " + err.Error())
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.New("begin txn: " + err.Error())
+	}
+	defer tx.Rollback()
+	args := []interface{}{s.CurrentTask.ID, check.Key, check.State, check.Checker, string(sources), string(diffs), string(perf), check.Time.Unix()}
+	if _, err = tx.Exec(s.dialect.upsertState, args...); err != nil {
+		return errors.New("update key state: " + err.Error())
+	}
+	if _, err = tx.Exec("insert into `key_check`(`task_id`, `key`, `state`, `checker`, `sources`, `diffs`, `perf`, `time`) values (?, ?, ?, ?, ?, ?, ?, ?)", args...); err != nil {
+		return errors.New("add key check: " + err.Error())
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.New("commit txn: " + err.Error())
+	}
+	return nil
+}
+
+// KeyChecks returns the check history of the key, the latest one comes last.
+func (s *sqlStore) KeyChecks(key string) ([]KeyCheck, error) {
+	if len(s.CurrentTask.ID) == 0 {
+		return nil, ErrNotSetup
+	}
+	return s.TaskKeyChecks(s.CurrentTask.ID, key)
+}
+
+func (s *sqlStore) TaskKeyChecks(taskID string, key string) ([]KeyCheck, error) {
+	rows, err := s.db.Query("select `state`, `checker`, `sources`, `diffs`, `perf`, `time` from `key_check` where `task_id` = ? and `key` = ? order by `id`", taskID, key)
+	if err != nil {
+		return nil, errors.New("query key checks: " + err.Error())
+	}
+	defer rows.Close()
+	var checks []KeyCheck
+	for rows.Next() {
+		var sources, diffs, perf []byte
+		var ts int64
+		c := KeyCheck{Key: key}
+		if err = rows.Scan(&c.State, &c.Checker, &sources, &diffs, &perf, &ts); err != nil {
+			return nil, errors.New("scan key_check row: " + err.Error())
+		}
+		c.Time = time.Unix(ts, 0)
+		if err = json.Unmarshal(sources, &c.Sources); err != nil {
+			return nil, errors.New("decode sources: " + err.Error())
+		}
+		if err = json.Unmarshal(diffs, &c.Diffs); err != nil {
+			return nil, errors.New("decode diffs: " + err.Error())
+		}
+		if err = json.Unmarshal(perf, &c.Perf); err != nil {
+			return nil, errors.New("decode perf: " + err.Error())
+		}
+		checks = append(checks, c)
+	}
+	return checks, rows.Err()
+}
+
+func (s *sqlStore) KeysByState(state string) ([]string, error) {
+	if len(s.CurrentTask.ID) == 0 {
+		return nil, ErrNotSetup
+	}
+	return s.TaskKeysByState(s.CurrentTask.ID, state)
+}
+
+func (s *sqlStore) TaskKeysByState(taskID string, state string) ([]string, error) {
+	if state == StateSlow {
+		return s.slowKeys(taskID)
+	}
+	rows, err := s.db.Query("select distinct `key` from `key_state` where `task_id` = ? and `state` = ? order by `key`", taskID, state)
+	if err != nil {
+		return nil, errors.New("query keys: " + err.Error())
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return nil, errors.New("scan key_state row: " + err.Error())
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// slowKeys returns keys whose perf state is StateSlow, perf checks are stored
+// as json and filtered after being decoded.
+func (s *sqlStore) slowKeys(taskID string) ([]string, error) {
+	rows, err := s.db.Query("select `key`, `perf` from `key_state` where `task_id` = ? and `perf` like ? order by `key`", taskID, "%"+StateSlow+"%")
+	if err != nil {
+		return nil, errors.New("query keys: " + err.Error())
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var (
+			key  string
+			perf []byte
+			pc   *PerfCheck
+		)
+		if err = rows.Scan(&key, &perf); err != nil {
+			return nil, errors.New("scan key_state row: " + err.Error())
+		}
+		if err = json.Unmarshal(perf, &pc); err != nil {
+			return nil, errors.New("decode perf: " + err.Error())
+		}
+		if (KeyCheck{Perf: pc}).inState(StateSlow) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, rows.Err()
+}
+
+// Close flushes buffered results and closes the database, which is shared by
+// forks of the store. Forks should be flushed before since their buffers are
+// not flushed by the store.
+func (s *sqlStore) Close() error {
+	err := s.Flush()
+	if err1 := s.db.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// decodeBench decodes bench stats of a result, results written before bench
+// stats were introduced have none.
+func decodeBench(raw []byte) (*BenchStats, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var b *BenchStats
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return nil, errors.New("decode bench: " + err.Error())
+	}
+	return b, nil
+}
+
+// SchemaVersion returns the schema version of the database.
+func (s *sqlStore) SchemaVersion() (int, error) {
+	var v sql.NullInt64
+	if err := s.db.QueryRow("select max(`version`) from `schema_version`").Scan(&v); err != nil {
+		return 0, errors.New("query schema version: " + err.Error())
+	}
+	return int(v.Int64), nil
+}
+
+// bootstrap upgrades the database to the latest schema version, databases
+// created before schema_version was introduced are treated as version 0. Every
+// migration must be idempotent, since runners may bootstrap the same database
+// at the same time and DDL statements are not transactional in MySQL.
+func (s *sqlStore) bootstrap(migrations []func(tx *sql.Tx) error) error {
+	if _, err := s.db.Exec("create table if not exists `schema_version`(`version` int, `time` bigint, primary key (`version`))"); err != nil {
+		return errors.New("bootstrap: " + err.Error())
+	}
+	v, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if v > len(migrations) {
+		return fmt.Errorf("bootstrap: schema version %d is newer than the supported version %d", v, len(migrations))
+	}
+	for ; v < len(migrations); v++ {
+		if err = s.migrate(v+1, migrations[v]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) migrate(version int, up func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.New("begin txn: " + err.Error())
+	}
+	defer tx.Rollback()
+	if err = up(tx); err != nil {
+		return fmt.Errorf("migrate to version %d: %s", version, err.Error())
+	}
+	if _, err = tx.Exec(s.dialect.addVersion, version, time.Now().Unix()); err != nil {
+		return errors.New("update schema version: " + err.Error())
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.New("commit txn: " + err.Error())
+	}
+	return nil
+}
+
+func execAll(stmts ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("%s while executing %s", err.Error(), stmt)
+			}
+		}
+		return nil
+	}
+}
+
+// addColumns adds columns to the table unless they exist, each column is
+// given by its definition like "`name` type ...".
+func addColumns(table string, defs ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, def := range defs {
+			name := strings.Trim(strings.Fields(def)[0], "`")
+			if hasColumn(tx, table, name) {
+				continue
+			}
+			stmt := "alter table `" + table + "` add column " + def
+			// the column may be added by others in the meantime.
+			if _, err := tx.Exec(stmt); err != nil && !hasColumn(tx, table, name) {
+				return fmt.Errorf("%s while executing %s", err.Error(), stmt)
+			}
+		}
+		return nil
+	}
+}
+
+// hasColumn tells whether the column exists by selecting it, which works the
+// same in SQLite and MySQL.
+func hasColumn(tx *sql.Tx, table string, name string) bool {
+	rows, err := tx.Query("select `" + name + "` from `" + table + "` where 1 = 0")
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
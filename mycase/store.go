@@ -3,190 +3,207 @@ package mycase
 import (
 	"database/sql"
 	"errors"
-	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/zyguan/mytest/resultset"
 )
 
 var (
-	ErrNotSetup = errors.New("task has not been setup")
+	ErrNotSetup     = errors.New("task has not been setup")
+	ErrTaskNotFound = errors.New("task not found")
+	ErrTaskExists   = errors.New("task already exists")
 )
 
+// TaskFilter selects tasks, zero fields match everything. Meta matches tasks
+// whose meta contains the given text.
+type TaskFilter struct {
+	Name  string
+	Meta  string
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+type TaskSummary struct {
+	Info     TaskInfo
+	Keys     int
+	Results  int
+	Sources  []string
+	States   map[string]int
+	Duration float64
+}
+
 type ResultStore interface {
 	Setup(info TaskInfo) error
 
+	ListTasks(filter TaskFilter) ([]TaskInfo, error)
+	GetTask(id string) (TaskInfo, error)
+	TaskSummary(id string) (TaskSummary, error)
+	DeleteTask(id string) error
+	// RenameTask moves the task with everything of it to newID, it fails with
+	// ErrTaskExists if newID is taken.
+	RenameTask(id string, newID string) error
+	PruneTasks(olderThan time.Duration) (int, error)
+
 	Write(res QueryResult) error
+	// Replace writes the result in place of results of the same key and source
+	// written before in the current task, it's used by reruns of keys.
+	Replace(res QueryResult) error
 	Read(key string) ([]QueryResult, error)
+	ReadTask(taskID string, key string) ([]QueryResult, error)
+	// Stream is like ReadTask but decodes result sets on demand.
+	Stream(taskID string, key string) (ResultIterator, error)
 	Keys() ([]string, error)
+	// TaskKeys is like Keys but lists keys of the task.
+	TaskKeys(taskID string) ([]string, error)
 
 	Mark(key string, state string) error
+	MarkCheck(check KeyCheck) error
+	KeyChecks(key string) ([]KeyCheck, error)
+	// TaskKeyChecks is like KeyChecks but reads checks of the task.
+	TaskKeyChecks(taskID string, key string) ([]KeyCheck, error)
+	// KeysByState returns keys of the current task in the state, StateSlow
+	// matches keys by the performance state of their latest checks.
 	KeysByState(state string) ([]string, error)
+	// TaskKeysByState is like KeysByState but lists keys of the task.
+	TaskKeysByState(taskID string, state string) ([]string, error)
+
+	// Flush makes sure all written results are persisted.
+	Flush() error
+	// Close flushes written results and releases the store.
+	Close() error
 }
 
 var (
 	_ ResultStore = &SQLiteResultStore{}
 )
 
-type SQLiteResultStore struct {
-	CurrentTask TaskInfo
-
-	db *sql.DB
+var sqliteDialect = &sqlDialect{
+	addTask: "insert or ignore into `task`(`id`, `name`, `meta`, `time`) values (?, ?, ?, ?)",
+	upsertState: "insert into `key_state`(`task_id`, `key`, `state`, `checker`, `sources`, `diffs`, `perf`, `time`) values (?, ?, ?, ?, ?, ?, ?, ?) " +
+		"on conflict(`task_id`, `key`) do update set `state` = excluded.`state`, `checker` = excluded.`checker`, `sources` = excluded.`sources`, `diffs` = excluded.`diffs`, `perf` = excluded.`perf`, `time` = excluded.`time`",
+	addVersion: "insert or ignore into `schema_version`(`version`, `time`) values (?, ?)",
 }
 
-func NewSQLiteResultStore(dsn string) (*SQLiteResultStore, error) {
-	db, err := sql.Open("sqlite3", dsn)
-	if err != nil {
-		return nil, err
-	}
-	s := &SQLiteResultStore{db: db}
-	if err = s.bootstrap(); err != nil {
-		return nil, err
+// WithJournalMode sets the journal mode of SQLite databases, WAL is used by
+// default so that readers and the writer don't block each other.
+func WithJournalMode(mode string) SQLStoreOption {
+	return func(o SQLStoreOptions) SQLStoreOptions {
+		o.JournalMode = mode
+		return o
 	}
-	return s, nil
 }
 
-func (s *SQLiteResultStore) Setup(info TaskInfo) error {
-	if len(info.ID) == 0 {
-		return errors.New("id is required")
-	}
-	tx, err := s.db.Begin()
-	if err != nil {
-		return errors.New("begin txn: " + err.Error())
-	}
-	row := tx.QueryRow("select `id`, `name`, `meta`, `time` from `task` where `id` = ?", info.ID)
-	var t int64
-	var m []byte
-	err = row.Scan(&info.ID, &info.Name, &m, &t)
-	if err == nil {
-		info.Time = time.Unix(t, 0)
-		info.Meta = m
-		s.CurrentTask = info
-	} else if err == sql.ErrNoRows {
-		_, err = tx.Exec("insert into `task`(`id`, `name`, `meta`, `time`) values (?, ?, ?, ?)",
-			info.ID, info.Name, string(info.Meta), info.Time.Unix())
-		if err != nil {
-			return errors.New("add task: " + err.Error())
-		}
-		s.CurrentTask = info
-	}
-	err = tx.Commit()
-	if err != nil {
-		return errors.New("commit txn: " + err.Error())
+// WithMaxOpenConns limits the number of open connections of SQLite databases,
+// a limit of 1 serializes access of concurrent writers to avoid lock contention.
+func WithMaxOpenConns(n int) SQLStoreOption {
+	return func(o SQLStoreOptions) SQLStoreOptions {
+		o.MaxOpenConns = n
+		return o
 	}
-	return nil
 }
 
-func (s *SQLiteResultStore) Write(res QueryResult) error {
-	if len(s.CurrentTask.ID) == 0 {
-		return ErrNotSetup
-	}
-	args := make([]interface{}, 8)
-	var err error
-	args[5], err = res.ResultSet.Encode()
-	if err != nil {
-		return errors.New("encode result set: " + err.Error())
+// WithBusyTimeout sets how long to wait for a locked SQLite database before
+// failing with SQLITE_BUSY.
+func WithBusyTimeout(d time.Duration) SQLStoreOption {
+	return func(o SQLStoreOptions) SQLStoreOptions {
+		o.BusyTimeout = d
+		return o
 	}
-	args[0] = s.CurrentTask.ID
-	args[1] = res.Key
-	args[2] = res.SQL
-	args[3] = res.Version
-	args[4] = res.ResultSet.DataDigest()
-	args[6] = res.Time.Unix()
-	args[7] = res.Duration
-	_, err = s.db.Exec("insert into `result`(`task_id`, `key`, `sql`, `version`, `data_digest`, `result`, `time`, `duration`) values (?, ?, ?, ?, ?, ?, ?, ?)", args...)
-	return err
 }
 
-func (s *SQLiteResultStore) Read(key string) ([]QueryResult, error) {
-	if len(s.CurrentTask.ID) == 0 {
-		return nil, ErrNotSetup
-	}
-	rows, err := s.db.Query("select `sql`, `version`, `result`, `time`, `duration` from `result` where `task_id` = ? and `key` = ? order by `version`", s.CurrentTask.ID, key)
-	if err != nil {
-		return nil, errors.New("query result: " + err.Error())
-	}
-	defer rows.Close()
-	var qrs []QueryResult
-	for rows.Next() {
-		var raw []byte
-		var ts int64
-		qr := QueryResult{Key: key}
-		err = rows.Scan(&qr.SQL, &qr.Version, &raw, &ts, &qr.Duration)
-		if err != nil {
-			return nil, errors.New("scan result row: " + err.Error())
-		}
-		qr.Time = time.Unix(ts, 0)
-		qr.ResultSet = &resultset.ResultSet{}
-		if err = qr.ResultSet.Decode(raw); err != nil {
-			return nil, errors.New("decode result set: " + err.Error())
-		}
-		qrs = append(qrs, qr)
-	}
-	return qrs, rows.Err()
+type SQLiteResultStore struct {
+	sqlStore
 }
 
-func (s *SQLiteResultStore) Keys() ([]string, error) {
-	if len(s.CurrentTask.ID) == 0 {
-		return nil, ErrNotSetup
+func NewSQLiteResultStore(dsn string, opts ...SQLStoreOption) (*SQLiteResultStore, error) {
+	o := newSQLStoreOptions(opts)
+	memory := strings.HasPrefix(dsn, ":memory:") || strings.Contains(dsn, "mode=memory")
+	params := url.Values{}
+	if len(o.JournalMode) > 0 && !memory {
+		params.Set("_journal_mode", o.JournalMode)
+	}
+	params.Set("_busy_timeout", strconv.FormatInt(int64(o.BusyTimeout/time.Millisecond), 10))
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
 	}
-	rows, err := s.db.Query("select distinct `key` from `result` where `task_id` = ? order by `key`", s.CurrentTask.ID)
+	db, err := sql.Open("sqlite3", dsn+sep+params.Encode())
 	if err != nil {
-		return nil, errors.New("query keys: " + err.Error())
+		return nil, err
 	}
-	defer rows.Close()
-	var keys []string
-	for rows.Next() {
-		var key string
-		if err = rows.Scan(&key); err != nil {
-			return nil, errors.New("scan result row: " + err.Error())
-		}
-		keys = append(keys, key)
+	if memory {
+		// every connection opens its own in-memory database.
+		db.SetMaxOpenConns(1)
+	} else if o.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(o.MaxOpenConns)
+	}
+	s := &SQLiteResultStore{sqlStore{db: db, dialect: sqliteDialect, batch: &sqlBatch{size: o.BatchSize}}}
+	if err = s.bootstrap(sqliteMigrations); err != nil {
+		db.Close()
+		return nil, err
 	}
-	return keys, rows.Err()
+	return s, nil
 }
 
-func (s *SQLiteResultStore) Mark(key string, state string) error {
-	if len(s.CurrentTask.ID) == 0 {
-		return ErrNotSetup
-	}
-	_, err := s.db.Exec("insert into `key_state`(`task_id`, `key`, `state`) values (?, ?, ?) on conflict(`task_id`, `key`) do update set `state` = ?", s.CurrentTask.ID, key, state, state)
-	return err
+// Fork returns a store sharing the same database but with its own current task
+// and write buffer, results written by the fork are visible to others once it's
+// flushed.
+func (s *SQLiteResultStore) Fork() *SQLiteResultStore {
+	return &SQLiteResultStore{sqlStore{db: s.db, dialect: s.dialect, batch: &sqlBatch{size: s.batch.size}}}
 }
 
-func (s *SQLiteResultStore) KeysByState(state string) ([]string, error) {
-	if len(s.CurrentTask.ID) == 0 {
-		return nil, ErrNotSetup
+// Vacuum rebuilds the database file to reclaim space of deleted tasks.
+func (s *SQLiteResultStore) Vacuum() error {
+	if _, err := s.db.Exec("vacuum"); err != nil {
+		return errors.New("vacuum: " + err.Error())
 	}
-	rows, err := s.db.Query("select distinct `key` from `key_state` where `task_id` = ? and `state` = ? order by `key`", s.CurrentTask.ID, state)
-	if err != nil {
-		return nil, errors.New("query keys: " + err.Error())
-	}
-	defer rows.Close()
-	var keys []string
-	for rows.Next() {
-		var key string
-		if err = rows.Scan(&key); err != nil {
-			return nil, errors.New("scan key_state row: " + err.Error())
-		}
-		keys = append(keys, key)
-	}
-	return keys, rows.Err()
+	return nil
 }
 
-func (s *SQLiteResultStore) Close() error { return s.db.Close() }
-
-func (s *SQLiteResultStore) bootstrap() error {
-	for _, stmt := range []string{
+// sqliteMigrations upgrade the schema step by step, applying migrations[i]
+// brings the schema to version i+1. Versions are shared with mysqlMigrations.
+// Released migrations must not be changed, append a new one to both backends
+// instead.
+var sqliteMigrations = []func(tx *sql.Tx) error{
+	// 1: the initial schema.
+	execAll(
 		"create table if not exists `task`(`id` text, `name` text, `meta` text, `time` int, primary key (id))",
 		"create table if not exists `result`(`id` integer primary key autoincrement, `task_id` text, `key` text, `sql` text, `version` text, `data_digest` text, `result` blob, `time` int, `duration` real)",
 		"create table if not exists `key_state`(`task_id` text, `key` text, `state` text, primary key (`task_id`, `key`))",
 		"create index if not exists `idx_result__task_id__key` on `result`(`task_id`, `key`)",
-	} {
-		if _, err := s.db.Exec(stmt); err != nil {
-			return fmt.Errorf("bootstrap: %s while executing %s", err.Error(), stmt)
+	),
+	// 2: source identity of results.
+	addColumns("result", "`source` text default ''", "`source_meta` text default ''"),
+	// 3: check details and history of key states.
+	func(tx *sql.Tx) error {
+		if err := addColumns("key_state", "`checker` text default ''", "`sources` text default 'null'", "`diffs` text default 'null'", "`time` int default 0")(tx); err != nil {
+			return err
 		}
-	}
-	return nil
+		var cnt int
+		if err := tx.QueryRow("select count(1) from `sqlite_master` where `type` = 'table' and `name` = 'key_check'").Scan(&cnt); err != nil {
+			return errors.New("query tables: " + err.Error())
+		}
+		if cnt > 0 {
+			return nil
+		}
+		// the current states are the only history we have.
+		return execAll(
+			"create table `key_check`(`id` integer primary key autoincrement, `task_id` text, `key` text, `state` text, `checker` text, `sources` text, `diffs` text, `time` int)",
+			"create index `idx_key_check__task_id__key` on `key_check`(`task_id`, `key`)",
+			"insert into `key_check`(`task_id`, `key`, `state`, `checker`, `sources`, `diffs`, `time`) select `task_id`, `key`, `state`, `checker`, `sources`, `diffs`, `time` from `key_state` order by `task_id`, `key`",
+		)(tx)
+	},
+	// 4: performance checks.
+	func(tx *sql.Tx) error {
+		if err := addColumns("key_state", "`perf` text default 'null'")(tx); err != nil {
+			return err
+		}
+		return addColumns("key_check", "`perf` text default 'null'")(tx)
+	},
+	// 5: bench stats of results.
+	addColumns("result", "`bench` text default 'null'"),
 }
@@ -1,7 +1,13 @@
 package mycase
 
 import (
+	"database/sql"
 	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -68,12 +74,14 @@ func TestSQLiteResultStore_ReadWriteResults(t *testing.T) {
 	rows.Close()
 
 	qr := QueryResult{
-		Time:      time.Unix(1573430460, 0),
-		Duration:  3.14,
-		Key:       "k",
-		SQL:       "select * from task",
-		Version:   "sqlite3",
-		ResultSet: rs,
+		Time:       time.Unix(1573430460, 0),
+		Duration:   3.14,
+		Key:        "k",
+		SQL:        "select * from task",
+		Source:     "local",
+		Version:    "sqlite3",
+		SourceMeta: json.RawMessage(`{"file":":memory:"}`),
+		ResultSet:  rs,
 	}
 
 	// #2 read empty results
@@ -127,7 +135,26 @@ func TestSQLiteResultStore_ReadWriteResults(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []string{qr.Key, qr2.Key}, ks)
 
-	// #6 switch task
+	// #6 results are grouped by source
+	qr3 := qr
+	qr3.Key, qr3.Source = "k3", "b"
+	assert.NoError(t, store.Write(qr3))
+	qr3.Source = "a"
+	assert.NoError(t, store.Write(qr3))
+	qr3.Source = "b"
+	qr3.SourceMeta = nil
+	assert.NoError(t, store.Write(qr3))
+	qrs, err = store.Read(qr3.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(qrs))
+	groups := GroupBySource(qrs)
+	assert.Equal(t, 2, len(groups))
+	assert.Equal(t, "a", groups[0][0].SourceID())
+	assert.Equal(t, 2, len(groups[1]))
+	assert.Equal(t, json.RawMessage(`{"file":":memory:"}`), groups[1][0].SourceMeta)
+	assert.Nil(t, groups[1][1].SourceMeta)
+
+	// #7 switch task
 	task.ID = "bar"
 	assert.NoError(t, store.Setup(task))
 	qrs, err = store.Read(qr.Key)
@@ -136,6 +163,14 @@ func TestSQLiteResultStore_ReadWriteResults(t *testing.T) {
 	ks, err = store.Keys()
 	assert.NoError(t, err)
 	assert.Empty(t, ks)
+
+	// #8 read results of another task
+	qrs, err = store.ReadTask("foo", qr.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(qrs))
+	qrs, err = store.ReadTask("not_found", qr.Key)
+	assert.NoError(t, err)
+	assert.Empty(t, qrs)
 }
 
 func TestSQLiteResultStore_MarkListKeys(t *testing.T) {
@@ -205,3 +240,397 @@ func TestSQLiteResultStore_MarkListKeys(t *testing.T) {
 	assert.Contains(t, ks, "foo")
 
 }
+
+func TestSQLiteResultStore_KeyChecks(t *testing.T) {
+	store, err := NewSQLiteResultStore(":memory:")
+	assert.NoError(t, err)
+	defer store.Close()
+
+	assert.Error(t, store.MarkCheck(KeyCheck{Key: "k"}))
+	_, err = store.KeyChecks("k")
+	assert.Error(t, err)
+
+	assert.NoError(t, store.Setup(TaskInfo{ID: "foo", Time: time.Unix(1573430400, 0)}))
+	cs, err := store.KeyChecks("k")
+	assert.NoError(t, err)
+	assert.Empty(t, cs)
+
+	v := "1"
+	c1 := KeyCheck{Key: "k", State: StateFail, Checker: "dummy", Sources: []string{"a", "b"}, Time: time.Unix(1573430460, 0),
+		Diffs: []DiffDetail{{Reference: "a", Source: "b", Message: "1 cells mismatch", Cells: []CellDiff{{Row: 1, Col: 2, Val1: &v, Assertion: "dummy"}}}}}
+	c2 := KeyCheck{Key: "k", State: StateOK, Checker: "dummy", Sources: []string{"a", "b"}, Time: time.Unix(1573430520, 0)}
+	assert.NoError(t, store.MarkCheck(c1))
+	assert.NoError(t, store.MarkCheck(c2))
+	assert.NoError(t, store.Mark("k2", StateFail))
+
+	cs, err = store.KeyChecks("k")
+	assert.NoError(t, err)
+	assert.Equal(t, []KeyCheck{c1, c2}, cs)
+	ks, err := store.KeysByState(StateOK)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"k"}, ks)
+	ks, err = store.KeysByState(StateFail)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"k2"}, ks)
+	cs, err = store.KeyChecks("k2")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(cs))
+	assert.Equal(t, StateFail, cs[0].State)
+}
+
+func TestSQLiteResultStore_Tasks(t *testing.T) {
+	store, err := NewSQLiteResultStore(":memory:")
+	assert.NoError(t, err)
+	defer store.Close()
+
+	now := time.Now().Truncate(time.Second)
+	tasks := []TaskInfo{
+		{ID: "t1", Name: "foo", Meta: json.RawMessage(`{"tag":"nightly"}`), Time: now.Add(-72 * time.Hour)},
+		{ID: "t2", Name: "bar", Meta: json.RawMessage(`{"tag":"release"}`), Time: now.Add(-48 * time.Hour)},
+		{ID: "t3", Name: "foo", Meta: json.RawMessage(`{"tag":"nightly"}`), Time: now},
+	}
+	for _, task := range tasks {
+		assert.NoError(t, store.Setup(task))
+	}
+	rs := resultset.New([]resultset.ColumnDef{{Name: "v", Type: "TEXT"}})
+	for _, qr := range []QueryResult{
+		{Key: "k1", Source: "a", Duration: 1, ResultSet: rs},
+		{Key: "k1", Source: "b", Duration: 2, ResultSet: rs},
+		{Key: "k2", Version: "v", Duration: 3, ResultSet: rs},
+	} {
+		assert.NoError(t, store.Write(qr))
+	}
+	assert.NoError(t, store.Mark("k1", StateOK))
+	assert.NoError(t, store.Mark("k2", StateOK))
+
+	// #1 list tasks
+	for _, tt := range []struct {
+		filter TaskFilter
+		ids    []string
+	}{
+		{TaskFilter{}, []string{"t3", "t2", "t1"}},
+		{TaskFilter{Limit: 2}, []string{"t3", "t2"}},
+		{TaskFilter{Name: "foo"}, []string{"t3", "t1"}},
+		{TaskFilter{Meta: `"release"`}, []string{"t2"}},
+		{TaskFilter{Since: now.Add(-50 * time.Hour)}, []string{"t3", "t2"}},
+		{TaskFilter{Until: now.Add(-50 * time.Hour)}, []string{"t1"}},
+		{TaskFilter{Name: "baz"}, nil},
+	} {
+		ts, err := store.ListTasks(tt.filter)
+		assert.NoError(t, err)
+		var ids []string
+		for _, task := range ts {
+			ids = append(ids, task.ID)
+		}
+		assert.Equal(t, tt.ids, ids, "%+v", tt.filter)
+	}
+
+	// #2 get task
+	task, err := store.GetTask("t2")
+	assert.NoError(t, err)
+	assert.Equal(t, tasks[1], task)
+	_, err = store.GetTask("t4")
+	assert.Equal(t, ErrTaskNotFound, err)
+
+	// #3 task summary
+	sum, err := store.TaskSummary("t3")
+	assert.NoError(t, err)
+	assert.Equal(t, TaskSummary{Info: tasks[2], Keys: 2, Results: 3, Sources: []string{"a", "b", "v"}, States: map[string]int{StateOK: 2}, Duration: 6}, sum)
+	sum, err = store.TaskSummary("t1")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sum.Results)
+	assert.Empty(t, sum.States)
+	_, err = store.TaskSummary("t4")
+	assert.Equal(t, ErrTaskNotFound, err)
+
+	// #4 delete and prune
+	assert.NoError(t, store.DeleteTask("t3"))
+	_, err = store.GetTask("t3")
+	assert.Equal(t, ErrTaskNotFound, err)
+	_, err = store.Keys()
+	assert.Equal(t, ErrNotSetup, err)
+	var cnt int
+	assert.NoError(t, store.db.QueryRow("select (select count(1) from result) + (select count(1) from key_state) + (select count(1) from key_check)").Scan(&cnt))
+	assert.Equal(t, 0, cnt)
+
+	n, err := store.PruneTasks(60 * time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	ts, err := store.ListTasks(TaskFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []TaskInfo{tasks[1]}, ts)
+	assert.NoError(t, store.Vacuum())
+}
+
+func TestSQLiteResultStore_Migrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mycase")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dsn := filepath.Join(dir, "old.db")
+
+	// a store created before schema_version was introduced.
+	db, err := sql.Open("sqlite3", dsn)
+	assert.NoError(t, err)
+	raw, err := testResult("k", "", "1").ResultSet.Encode()
+	assert.NoError(t, err)
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+	assert.NoError(t, execAll(
+		"create table `task`(`id` text, `name` text, `meta` text, `time` int, primary key (id))",
+		"create table `result`(`id` integer primary key autoincrement, `task_id` text, `key` text, `sql` text, `version` text, `data_digest` text, `result` blob, `time` int, `duration` real)",
+		"create table `key_state`(`task_id` text, `key` text, `state` text, primary key (`task_id`, `key`))",
+		"insert into `task` values ('t1', 'old', '', 1573430400)",
+		"insert into `key_state` values ('t1', 'k', 'FAIL')",
+	)(tx))
+	assert.NoError(t, tx.Commit())
+	_, err = db.Exec("insert into `result`(`task_id`, `key`, `sql`, `version`, `data_digest`, `result`, `time`, `duration`) values ('t1', 'k', 'select 1', 'v1', '', ?, 1573430460, 0.5)", raw)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	store, err := NewSQLiteResultStore(dsn)
+	assert.NoError(t, err)
+	v, err := store.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, len(sqliteMigrations), v)
+	assert.NoError(t, store.Setup(TaskInfo{ID: "t1"}))
+	qrs, err := store.Read("k")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(qrs))
+	assert.Equal(t, "v1", qrs[0].SourceID())
+	cs, err := store.KeyChecks("k")
+	assert.NoError(t, err)
+	assert.Equal(t, []KeyCheck{{Key: "k", State: StateFail, Time: time.Unix(0, 0)}}, cs)
+	assert.NoError(t, store.Write(testResult("k", "a", "1")))
+	assert.NoError(t, store.Mark("k", StateOK))
+	store.Close()
+
+	// reopening an up-to-date store changes nothing.
+	store, err = NewSQLiteResultStore(dsn)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Setup(TaskInfo{ID: "t1"}))
+	qrs, err = store.Read("k")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(qrs))
+	cs, err = store.KeyChecks("k")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(cs))
+	store.Close()
+
+	db, err = sql.Open("sqlite3", dsn)
+	assert.NoError(t, err)
+	_, err = db.Exec("insert into `schema_version` values (?, 0)", len(sqliteMigrations)+1)
+	assert.NoError(t, err)
+	db.Close()
+	_, err = NewSQLiteResultStore(dsn)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "newer than the supported version")
+}
+
+// unversionedSchemas are layouts created by bootstrap before schema_version was
+// introduced, by the `create table if not exists` statements at that time.
+var unversionedSchemas = map[string][]string{
+	"source": {
+		"create table `task`(`id` text, `name` text, `meta` text, `time` int, primary key (id))",
+		"create table `result`(`id` integer primary key autoincrement, `task_id` text, `key` text, `sql` text, `source` text, `version` text, `source_meta` text, `data_digest` text, `result` blob, `time` int, `duration` real)",
+		"create table `key_state`(`task_id` text, `key` text, `state` text, primary key (`task_id`, `key`))",
+	},
+	"check": {
+		"create table `task`(`id` text, `name` text, `meta` text, `time` int, primary key (id))",
+		"create table `result`(`id` integer primary key autoincrement, `task_id` text, `key` text, `sql` text, `source` text, `version` text, `source_meta` text, `data_digest` text, `result` blob, `time` int, `duration` real)",
+		"create table `key_state`(`task_id` text, `key` text, `state` text, `checker` text, `sources` text, `diffs` text, `time` int, primary key (`task_id`, `key`))",
+		"create table `key_check`(`id` integer primary key autoincrement, `task_id` text, `key` text, `state` text, `checker` text, `sources` text, `diffs` text, `time` int)",
+		"create index `idx_result__task_id__key` on `result`(`task_id`, `key`)",
+		"create index `idx_key_check__task_id__key` on `key_check`(`task_id`, `key`)",
+		"insert into `key_check`(`task_id`, `key`, `state`, `checker`, `sources`, `diffs`, `time`) values ('t1', 'k', 'FAIL', '', '[\"a\"]', 'null', 1573430460)",
+	},
+}
+
+func TestSQLiteResultStore_MigrateUnversioned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mycase")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	raw, err := testResult("k", "a", "1").ResultSet.Encode()
+	assert.NoError(t, err)
+
+	for name, schema := range unversionedSchemas {
+		t.Run(name, func(t *testing.T) {
+			dsn := filepath.Join(dir, name+".db")
+			db, err := sql.Open("sqlite3", dsn)
+			assert.NoError(t, err)
+			tx, err := db.Begin()
+			assert.NoError(t, err)
+			assert.NoError(t, execAll(append(schema,
+				"insert into `task`(`id`, `name`, `meta`, `time`) values ('t1', 'old', '', 1573430400)",
+				"insert into `key_state`(`task_id`, `key`, `state`) values ('t1', 'k', 'FAIL')",
+			)...)(tx))
+			assert.NoError(t, tx.Commit())
+			_, err = db.Exec("insert into `result`(`task_id`, `key`, `sql`, `source`, `version`, `data_digest`, `result`, `time`, `duration`) values ('t1', 'k', 'select 1', 'a', 'v1', '', ?, 1573430460, 0.5)", raw)
+			assert.NoError(t, err)
+			assert.NoError(t, db.Close())
+
+			store, err := NewSQLiteResultStore(dsn)
+			assert.NoError(t, err)
+			defer store.Close()
+			v, err := store.SchemaVersion()
+			assert.NoError(t, err)
+			assert.Equal(t, len(sqliteMigrations), v)
+			assert.NoError(t, store.Setup(TaskInfo{ID: "t1"}))
+			assert.NoError(t, store.Write(testResult("k", "b", "1")))
+			qrs, err := store.Read("k")
+			assert.NoError(t, err)
+			assert.Equal(t, 2, len(qrs))
+			assert.Equal(t, "a", qrs[0].SourceID())
+			assert.NoError(t, store.MarkCheck(KeyCheck{Key: "k", State: StateOK, Sources: []string{"a", "b"}, Time: time.Unix(1573430520, 0)}))
+			cs, err := store.KeyChecks("k")
+			assert.NoError(t, err)
+			assert.Equal(t, 2, len(cs))
+			assert.Equal(t, StateFail, cs[0].State)
+			assert.Equal(t, []string{"a", "b"}, cs[1].Sources)
+		})
+	}
+}
+
+func TestSQLiteResultStore_BatchWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mycase")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dsn := filepath.Join(dir, "batch.db")
+	store, err := NewSQLiteResultStore(dsn, WithBatchSize(4))
+	assert.NoError(t, err)
+	var mode string
+	assert.NoError(t, store.db.QueryRow("pragma journal_mode").Scan(&mode))
+	assert.Equal(t, "wal", mode)
+	assert.NoError(t, store.Setup(TaskInfo{ID: "t1"}))
+
+	count := func() int {
+		var cnt int
+		assert.NoError(t, store.db.QueryRow("select count(1) from `result`").Scan(&cnt))
+		return cnt
+	}
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, store.Write(testResult("k", "a", strconv.Itoa(i))))
+	}
+	assert.Equal(t, 0, count())
+	assert.NoError(t, store.Write(testResult("k", "a", "3")))
+	assert.Equal(t, 4, count())
+	assert.NoError(t, store.Write(testResult("k", "b", "4")))
+	qrs, err := store.Read("k")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(qrs))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := store.Fork()
+			assert.NoError(t, s.Setup(TaskInfo{ID: "t2"}))
+			for j := 0; j < 50; j++ {
+				key := "k" + strconv.Itoa(j)
+				assert.NoError(t, s.Write(testResult(key, "s"+strconv.Itoa(i), "v")))
+				if j%10 == 0 {
+					assert.NoError(t, s.Mark(key, StateOK))
+				}
+			}
+			assert.NoError(t, s.Flush())
+		}(i)
+	}
+	wg.Wait()
+	assert.NoError(t, store.Write(testResult("k", "c", "5")))
+	assert.NoError(t, store.Close())
+
+	store, err = NewSQLiteResultStore(dsn)
+	assert.NoError(t, err)
+	defer store.Close()
+	sum, err := store.TaskSummary("t2")
+	assert.NoError(t, err)
+	assert.Equal(t, 400, sum.Results)
+	assert.Equal(t, map[string]int{StateOK: 5}, sum.States)
+	sum, err = store.TaskSummary("t1")
+	assert.NoError(t, err)
+	assert.Equal(t, 6, sum.Results)
+}
+
+func TestSQLiteResultStore_BatchWriteFailure(t *testing.T) {
+	store, err := NewSQLiteResultStore(":memory:", WithBatchSize(4))
+	assert.NoError(t, err)
+	defer store.Close()
+	assert.NoError(t, store.Setup(TaskInfo{ID: "t1"}))
+	_, err = store.db.Exec("create trigger `reject_bad` before insert on `result` when new.`key` = 'bad' begin select raise(abort, 'bad key'); end")
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Write(testResult("k1", "a", "1")))
+	assert.NoError(t, store.Write(testResult("bad", "a", "1")))
+	assert.NoError(t, store.Write(testResult("k2", "a", "1")))
+	// the write flushing the batch is told only about results of its source.
+	assert.EqualError(t, store.Write(testResult("bad", "b", "1")), "drop results: bad of b: bad key")
+	assert.Equal(t, 0, len(store.batch.rows))
+	for key, n := range map[string]int{"k1": 1, "k2": 1, "bad": 0} {
+		qrs, err := store.Read(key)
+		assert.NoError(t, err)
+		assert.Equal(t, n, len(qrs), key)
+	}
+	assert.EqualError(t, store.Write(testResult("k3", "a", "1")), "drop results: bad of a: bad key")
+	assert.NoError(t, store.Flush())
+
+	// failures are reported to the fork which wrote them.
+	f1, f2 := store.Fork(), store.Fork()
+	assert.NoError(t, f1.Setup(TaskInfo{ID: "t1"}))
+	assert.NoError(t, f2.Setup(TaskInfo{ID: "t1"}))
+	assert.NoError(t, f1.Write(testResult("bad", "c", "1")))
+	assert.NoError(t, f2.Write(testResult("k4", "a", "1")))
+	assert.NoError(t, f2.Flush())
+	assert.EqualError(t, f1.Flush(), "drop results: bad of c: bad key")
+	qrs, err := store.Read("k4")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(qrs))
+}
+
+func TestSQLiteResultStore_SharedForkWriteFailure(t *testing.T) {
+	store, err := NewSQLiteResultStore(":memory:", WithBatchSize(3))
+	assert.NoError(t, err)
+	defer store.Close()
+	_, err = store.db.Exec("create trigger `reject_bad` before insert on `result` when new.`key` = 'bad' begin select raise(abort, 'bad key'); end")
+	assert.NoError(t, err)
+
+	// writers of sources a and b share a fork like tasks of a case do.
+	f := store.Fork()
+	assert.NoError(t, f.Setup(TaskInfo{ID: "t1"}))
+	var wg sync.WaitGroup
+	errs := make(map[string][]error)
+	var mu sync.Mutex
+	for _, src := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(src string) {
+			defer wg.Done()
+			for _, key := range []string{"k1", "bad", "k2", "k3", "k4", "k5"} {
+				if src == "b" && key == "bad" {
+					continue
+				}
+				err := f.Write(testResult(key, src, "1"))
+				mu.Lock()
+				errs[src] = append(errs[src], err)
+				mu.Unlock()
+			}
+		}(src)
+	}
+	wg.Wait()
+	// b never sees the failure of a, while a sees it once, either from one of
+	// its writes or from the final flush.
+	for _, err := range errs["b"] {
+		assert.NoError(t, err)
+	}
+	var failures []string
+	for _, err := range errs["a"] {
+		if err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if err = f.Flush(); err != nil {
+		failures = append(failures, err.Error())
+	}
+	assert.Equal(t, []string{"drop results: bad of a: bad key"}, failures)
+	sum, err := f.TaskSummary("t1")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, sum.Results)
+}
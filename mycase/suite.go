@@ -0,0 +1,222 @@
+package mycase
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type CaseLoader func(file string) (MyCase, error)
+
+// DiscoverCases walks root and loads every file whose base name matches pattern.
+func DiscoverCases(root string, pattern string, load CaseLoader) ([]MyCase, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, errors.New("bad pattern: " + err.Error())
+	}
+	var files []string
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		if ok, _ := filepath.Match(pattern, fi.Name()); ok {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.New("walk " + root + ": " + err.Error())
+	}
+	sort.Strings(files)
+	cases := make([]MyCase, 0, len(files))
+	for _, f := range files {
+		mc, err := load(f)
+		if err != nil {
+			return nil, errors.New("load " + f + ": " + err.Error())
+		}
+		cases = append(cases, mc)
+	}
+	return cases, nil
+}
+
+// StoreProvider returns the store the i-th case of a suite should run against,
+// together with a function to release it after the case finished.
+type StoreProvider func(i int) (ResultStore, func() error, error)
+
+// SharedSQLiteStore lets all cases write to the same underlying database, every
+// case gets its own handle so that their current tasks and write buffers don't
+// interfere, the handle is flushed once the case finished. The
+// connection pool of s is left as is, open s with WithMaxOpenConns(1) to
+// serialize access of concurrent cases if they contend for locks.
+func SharedSQLiteStore(s *SQLiteResultStore) StoreProvider {
+	return func(i int) (ResultStore, func() error, error) {
+		f := s.Fork()
+		return f, f.Flush, nil
+	}
+}
+
+// SharedMySQLStore shares the MySQL result store among cases, each case gets its
+// own handle from the connection pool, which is flushed once the case finished.
+func SharedMySQLStore(s *MySQLResultStore) StoreProvider {
+	return func(i int) (ResultStore, func() error, error) {
+		f := s.Fork()
+		return f, f.Flush, nil
+	}
+}
+
+// IsolatedStores opens a new store of any kind for each case via open and
+// closes it afterwards.
+func IsolatedStores(open func(i int) (ResultStore, error)) StoreProvider {
+	return func(i int) (ResultStore, func() error, error) {
+		s, err := open(i)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Close, nil
+	}
+}
+
+const (
+	OutcomePass  = "PASS"
+	OutcomeFail  = "FAIL"
+	OutcomeError = "ERROR"
+)
+
+type CaseResult struct {
+	Index    int
+	Info     TaskInfo
+	Outcome  string
+	Err      error
+	Duration time.Duration
+}
+
+type SuiteResult struct {
+	Cases    []CaseResult
+	Passed   int
+	Failed   int
+	Errored  int
+	Duration time.Duration
+	// Stale lists entries of the allowlist given by Options which belong to
+	// cases of the suite but matched no diff, the failures they describe are
+	// gone and they can be removed.
+	Stale []KnownFailure
+}
+
+func (r *SuiteResult) Ok() bool { return r.Failed == 0 && r.Errored == 0 }
+
+type Suite struct {
+	Cases       []MyCase
+	Stores      StoreProvider
+	Concurrency int
+	Options     []RunOption
+}
+
+func (s *Suite) Run() (*SuiteResult, error) {
+	if s.Stores == nil {
+		return nil, errors.New("store provider is required")
+	}
+	n := s.Concurrency
+	if n <= 0 {
+		n = 1
+	}
+	t0 := time.Now()
+	res := &SuiteResult{Cases: make([]CaseResult, len(s.Cases))}
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i := range s.Cases {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			res.Cases[i] = s.runCase(i)
+		}(i)
+	}
+	wg.Wait()
+	res.Duration = time.Since(t0)
+	var o RunOptions
+	for _, f := range s.Options {
+		o = f(o)
+	}
+	if o.Allowlist != nil {
+		res.Stale = o.Allowlist.Stale()
+	}
+	for _, cr := range res.Cases {
+		switch cr.Outcome {
+		case OutcomePass:
+			res.Passed++
+		case OutcomeFail:
+			res.Failed++
+		default:
+			res.Errored++
+		}
+	}
+	return res, nil
+}
+
+func (s *Suite) runCase(i int) CaseResult {
+	cr := CaseResult{Index: i}
+	t0 := time.Now()
+	rc, release, err := s.Stores(i)
+	if err != nil {
+		cr.Outcome, cr.Err = OutcomeError, errors.New("provide store: "+err.Error())
+		cr.Duration = time.Since(t0)
+		return cr
+	}
+	rec := &recordingCase{MyCase: s.Cases[i]}
+	var mc MyCase = rec
+	if rr, ok := s.Cases[i].(Rerunner); ok {
+		mc = &rerunningCase{recordingCase: rec, rr: rr}
+	}
+	cr.Err = Run(mc, rc, s.Options...)
+	cr.Info = rec.info
+	cr.Outcome = Outcome(cr.Err)
+	if err = release(); err != nil {
+		// the outcome of a failed run is kept, the release error is reported
+		// along with its errors.
+		err = errors.New("release store: " + err.Error())
+		if e, ok := cr.Err.(*RunErrors); ok {
+			e.StoreErrs = append(e.StoreErrs, err)
+		} else if cr.Err != nil {
+			cr.Err = errors.New(cr.Err.Error() + "; " + err.Error())
+		} else {
+			cr.Outcome, cr.Err = OutcomeError, err
+		}
+	}
+	cr.Duration = time.Since(t0)
+	return cr
+}
+
+// Outcome classifies the error returned by Run.
+func Outcome(err error) string {
+	if err == nil {
+		return OutcomePass
+	}
+	if e, ok := err.(*RunErrors); ok && e.ExecErr == nil && len(e.StoreErrs) == 0 {
+		return OutcomeFail
+	}
+	return OutcomeError
+}
+
+// recordingCase records the task info of the case.
+type recordingCase struct {
+	MyCase
+	info TaskInfo
+}
+
+// rerunningCase is a recordingCase of a case which can be rerun.
+type rerunningCase struct {
+	*recordingCase
+	rr Rerunner
+}
+
+func (c *rerunningCase) Rerun(rc ResultStore, key string) error { return c.rr.Rerun(rc, key) }
+
+func (c *recordingCase) NewTask() TaskInfo {
+	c.info = c.MyCase.NewTask()
+	return c.info
+}
@@ -0,0 +1,187 @@
+package mycase
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zyguan/mytest/resultset"
+)
+
+type fakeCase struct {
+	name      string
+	results   map[string][]string
+	durations map[string][]float64
+	testErr   error
+}
+
+func (c *fakeCase) NewTask() TaskInfo {
+	return TaskInfo{ID: c.name + "-" + strconv.FormatInt(time.Now().UnixNano(), 36), Name: c.name, Time: time.Now()}
+}
+
+func (c *fakeCase) Checkers() map[string]resultset.Checker {
+	cks := make(map[string]resultset.Checker)
+	for k := range c.results {
+		cks[k] = resultset.Checker{Assertions: []resultset.ValueAssertion{resultset.RawBytesAssertion{}}}
+	}
+	return cks
+}
+
+func (c *fakeCase) Setup(args json.RawMessage) error { return nil }
+
+func (c *fakeCase) Teardown() error { return nil }
+
+func (c *fakeCase) Test(rc ResultStore) error {
+	if c.testErr != nil {
+		return c.testErr
+	}
+	for k, vs := range c.results {
+		for i, v := range vs {
+			rs := resultset.New([]resultset.ColumnDef{{Name: "v", Type: "TEXT"}})
+			*(rs.AllocateRow()[0].(*[]byte)) = []byte(v)
+			qr := QueryResult{Key: k, Version: "v" + strconv.Itoa(i), ResultSet: rs}
+			if i < len(c.durations[k]) {
+				qr.Duration = c.durations[k][i]
+			}
+			if err := rc.Write(qr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestDiscoverCases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mycase")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	for _, f := range []string{"b.json", "a.json", "sub/c.json", "sub/d.sql"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, f), nil, 0644))
+	}
+
+	var loaded []string
+	cases, err := DiscoverCases(dir, "*.json", func(file string) (MyCase, error) {
+		rel, _ := filepath.Rel(dir, file)
+		loaded = append(loaded, rel)
+		return &fakeCase{name: rel}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(cases))
+	assert.Equal(t, []string{"a.json", "b.json", filepath.Join("sub", "c.json")}, loaded)
+
+	_, err = DiscoverCases(dir, "*.json", func(file string) (MyCase, error) { return nil, errors.New("oops") })
+	assert.Error(t, err)
+	_, err = DiscoverCases(dir, "[", nil)
+	assert.Error(t, err)
+}
+
+func TestSuite_Run(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mycase")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cases := []MyCase{
+		&fakeCase{name: "pass", results: map[string][]string{"k1": {"a", "a"}, "k2": {"b", "b", "b"}}},
+		&fakeCase{name: "fail", results: map[string][]string{"k1": {"a", "b"}}},
+		&fakeCase{name: "error", testErr: errors.New("oops")},
+		&fakeCase{name: "pass", results: map[string][]string{"k1": {"x"}}},
+	}
+
+	t.Run("Shared", func(t *testing.T) {
+		store, err := NewSQLiteResultStore(filepath.Join(dir, "shared.db"), WithMaxOpenConns(1))
+		assert.NoError(t, err)
+		defer store.Close()
+
+		a, err := NewAllowlist(KnownFailure{Case: "fail", Key: "^k1$"}, KnownFailure{Case: "pass", Key: "^k1$"}, KnownFailure{Case: "other", Key: ".*"})
+		assert.NoError(t, err)
+		suite := Suite{Cases: cases, Stores: SharedSQLiteStore(store), Concurrency: 2, Options: []RunOption{WithAllowlist(a)}}
+		res, err := suite.Run()
+		assert.NoError(t, err)
+		assert.False(t, res.Ok())
+		assert.Equal(t, 3, res.Passed)
+		assert.Equal(t, 0, res.Failed)
+		assert.Equal(t, 1, res.Errored)
+		assert.Equal(t, []string{OutcomePass, OutcomePass, OutcomeError, OutcomePass}, outcomes(res))
+		// the entry of the passing case is stale while that of cases not in
+		// the suite is not.
+		assert.Equal(t, 1, len(res.Stale))
+		assert.Equal(t, "pass", res.Stale[0].Case)
+		for i, cr := range res.Cases {
+			assert.Equal(t, i, cr.Index)
+			assert.Equal(t, cases[i].(*fakeCase).name, cr.Info.Name)
+		}
+
+		var cnt int
+		assert.NoError(t, store.db.QueryRow("select count(distinct task_id) from result").Scan(&cnt))
+		assert.Equal(t, 3, cnt)
+	})
+
+	t.Run("Isolated", func(t *testing.T) {
+		suite := Suite{Cases: cases, Concurrency: 4, Stores: IsolatedStores(func(i int) (ResultStore, error) {
+			if i%2 == 1 {
+				return NewMemResultStore(), nil
+			}
+			return NewSQLiteResultStore(filepath.Join(dir, "case"+strconv.Itoa(i)+".db"))
+		})}
+		res, err := suite.Run()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{OutcomePass, OutcomeFail, OutcomeError, OutcomePass}, outcomes(res))
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		store, err := NewSQLiteResultStore(filepath.Join(dir, "retry.db"))
+		assert.NoError(t, err)
+		defer store.Close()
+
+		mc := &rerunCase{
+			fakeCase: fakeCase{name: "flaky", results: map[string][]string{"k1": {"a", "b"}}},
+			reruns:   map[string][][]string{"k1": {{"a", "a"}}},
+		}
+		suite := Suite{Cases: []MyCase{mc}, Stores: SharedSQLiteStore(store), Options: []RunOption{WithRetry(2, time.Millisecond)}}
+		res, err := suite.Run()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{OutcomePass}, outcomes(res))
+		assert.Equal(t, "flaky", res.Cases[0].Info.Name)
+		assert.Equal(t, 1, mc.nReruns)
+		// the pool of the store is left as is.
+		assert.Equal(t, 0, store.db.Stats().MaxOpenConnections)
+	})
+
+	t.Run("ReleaseError", func(t *testing.T) {
+		suite := Suite{Cases: cases, Stores: func(i int) (ResultStore, func() error, error) {
+			return NewMemResultStore(), func() error { return errors.New("injected") }, nil
+		}}
+		res, err := suite.Run()
+		assert.NoError(t, err)
+		// outcomes of runs are kept with release errors reported along.
+		assert.Equal(t, []string{OutcomeError, OutcomeFail, OutcomeError, OutcomeError}, outcomes(res))
+		for _, i := range []int{0, 3} {
+			assert.EqualError(t, res.Cases[i].Err, "release store: injected")
+		}
+		for _, i := range []int{1, 2} {
+			errs := res.Cases[i].Err.(*RunErrors).Errors()
+			assert.EqualError(t, errs[len(errs)-1], "release store: injected")
+		}
+		assert.Equal(t, "oops", res.Cases[2].Err.(*RunErrors).ExecErr.Error())
+	})
+
+	t.Run("NoProvider", func(t *testing.T) {
+		_, err := (&Suite{Cases: cases}).Run()
+		assert.Error(t, err)
+	})
+}
+
+func outcomes(res *SuiteResult) []string {
+	xs := make([]string, len(res.Cases))
+	for i, cr := range res.Cases {
+		xs[i] = cr.Outcome
+	}
+	return xs
+}
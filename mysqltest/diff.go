@@ -0,0 +1,135 @@
+package mysqltest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxDiffCells bounds the table of the line diff, lines in between are shown
+// as replaced as a whole beyond it.
+const maxDiffCells = 1 << 24
+
+// Diff compares the expected output with the actual one line by line, it
+// returns an empty string if they are the same, otherwise hunks of the unified
+// format with 3 lines of context.
+func Diff(expected string, actual string) string {
+	if expected == actual {
+		return ""
+	}
+	a, b := splitLines(expected), splitLines(actual)
+	ops := diffLines(a, b)
+
+	var sb strings.Builder
+	const ctx = 3
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// a hunk spans changes less than 2*ctx lines apart.
+		start, end := i, i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			} else if j-end > 2*ctx {
+				break
+			}
+		}
+		lo, hi := start-ctx, end+ctx+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(ops) {
+			hi = len(ops)
+		}
+		var na, nb int
+		for _, op := range ops[lo:hi] {
+			if op.kind != '+' {
+				na++
+			}
+			if op.kind != '-' {
+				nb++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", ops[lo].a+1, na, ops[lo].b+1, nb)
+		for _, op := range ops[lo:hi] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+		i = hi
+	}
+	return sb.String()
+}
+
+// diffOp is a line of the diff, a and b are the line numbers of the both sides
+// where it happens.
+type diffOp struct {
+	kind byte
+	line string
+	a, b int
+}
+
+func splitLines(s string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func diffLines(a []string, b []string) []diffOp {
+	var ops []diffOp
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		ops = append(ops, diffOp{' ', a[pre], pre, pre})
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if len(ma)*len(mb) > maxDiffCells {
+		for i, l := range ma {
+			ops = append(ops, diffOp{'-', l, pre + i, pre})
+		}
+		for j, l := range mb {
+			ops = append(ops, diffOp{'+', l, pre + len(ma), pre + j})
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of ma[i:] and mb[j:].
+		lcs := make([][]int, len(ma)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(mb)+1)
+		}
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(ma) || j < len(mb) {
+			switch {
+			case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+				ops = append(ops, diffOp{' ', ma[i], pre + i, pre + j})
+				i, j = i+1, j+1
+			case j == len(mb) || (i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]):
+				ops = append(ops, diffOp{'-', ma[i], pre + i, pre + j})
+				i++
+			default:
+				ops = append(ops, diffOp{'+', mb[j], pre + i, pre + j})
+				j++
+			}
+		}
+	}
+	for k := suf; k > 0; k-- {
+		ops = append(ops, diffOp{' ', a[len(a)-k], len(a) - k, len(b) - k})
+	}
+	return ops
+}
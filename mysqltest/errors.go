@@ -0,0 +1,120 @@
+package mysqltest
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// errorNames are codes of common MySQL errors usable by the error directive.
+var errorNames = map[string]uint16{
+	"ER_DB_CREATE_EXISTS":           1007,
+	"ER_DB_DROP_EXISTS":             1008,
+	"ER_ACCESS_DENIED_ERROR":        1045,
+	"ER_BAD_DB_ERROR":               1049,
+	"ER_TABLE_EXISTS_ERROR":         1050,
+	"ER_BAD_TABLE_ERROR":            1051,
+	"ER_NON_UNIQ_ERROR":             1052,
+	"ER_BAD_FIELD_ERROR":            1054,
+	"ER_WRONG_FIELD_WITH_GROUP":     1055,
+	"ER_DUP_FIELDNAME":              1060,
+	"ER_DUP_KEYNAME":                1061,
+	"ER_DUP_ENTRY":                  1062,
+	"ER_PARSE_ERROR":                1064,
+	"ER_BAD_NULL_ERROR":             1048,
+	"ER_CANT_DROP_FIELD_OR_KEY":     1091,
+	"ER_UNKNOWN_TABLE":              1109,
+	"ER_WRONG_VALUE_COUNT_ON_ROW":   1136,
+	"ER_TABLEACCESS_DENIED_ERROR":   1142,
+	"ER_NO_SUCH_TABLE":              1146,
+	"ER_UNKNOWN_SYSTEM_VARIABLE":    1193,
+	"ER_LOCK_WAIT_TIMEOUT":          1205,
+	"ER_LOCK_DEADLOCK":              1213,
+	"ER_NOT_SUPPORTED_YET":          1235,
+	"ER_OPERAND_COLUMNS":            1241,
+	"ER_SUBQUERY_NO_1_ROW":          1242,
+	"ER_WARN_DATA_OUT_OF_RANGE":     1264,
+	"ER_TRUNCATED_WRONG_VALUE":      1292,
+	"ER_SP_ALREADY_EXISTS":          1304,
+	"ER_SP_DOES_NOT_EXIST":          1305,
+	"ER_TRG_ALREADY_EXISTS":         1359,
+	"ER_NO_DEFAULT_FOR_FIELD":       1364,
+	"ER_DIVISION_BY_ZERO":           1365,
+	"ER_DATA_TOO_LONG":              1406,
+	"ER_ROW_IS_REFERENCED_2":        1451,
+	"ER_NO_REFERENCED_ROW_2":        1452,
+	"ER_WRONG_ARGUMENTS":            1210,
+	"ER_CANT_AGGREGATE_2COLLATIONS": 1267,
+}
+
+// SQLState returns the SQLSTATE reported by the server along with err. An
+// error is returned if there is none, which is the case of servers speaking
+// protocols older than 4.1.
+func SQLState(err *mysql.MySQLError) (string, error) {
+	if err.SQLState == [5]byte{} {
+		return "", errors.New("unknown sqlstate of error " + strconv.Itoa(int(err.Number)))
+	}
+	return string(err.SQLState[:]), nil
+}
+
+// ExpectedError is an error expected by the error directive. It's either a
+// MySQL error code or a SQLSTATE, code 0 stands for success.
+type ExpectedError struct {
+	Code     uint16
+	SQLState string
+}
+
+// ParseErrors parses args of the error directive, errors are separated by
+// commas and given by codes, names like ER_NO_SUCH_TABLE or SQLSTATEs prefixed
+// by "S" like S42S02.
+func ParseErrors(args []string) ([]ExpectedError, error) {
+	var errs []ExpectedError
+	for _, s := range strings.Split(strings.Join(args, ","), ",") {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		if code, ok := errorNames[s]; ok {
+			errs = append(errs, ExpectedError{Code: code})
+		} else if len(s) == 6 && s[0] == 'S' {
+			errs = append(errs, ExpectedError{SQLState: s[1:]})
+		} else if code, err := strconv.ParseUint(s, 10, 16); err == nil {
+			errs = append(errs, ExpectedError{Code: uint16(code)})
+		} else {
+			return nil, errors.New("unknown error: " + s)
+		}
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("missing expected errors")
+	}
+	return errs, nil
+}
+
+// MatchError tells whether err, which is nil for success, is one of the
+// expected errors.
+func MatchError(expected []ExpectedError, err error) bool {
+	var (
+		code  uint16
+		state string
+	)
+	if err != nil {
+		e, ok := err.(*mysql.MySQLError)
+		if !ok {
+			return false
+		}
+		code = e.Number
+		state, _ = SQLState(e)
+	}
+	for _, x := range expected {
+		if len(x.SQLState) > 0 {
+			if len(state) > 0 && state == x.SQLState {
+				return true
+			}
+		} else if x.Code == code {
+			return true
+		}
+	}
+	return false
+}
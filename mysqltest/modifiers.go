@@ -0,0 +1,166 @@
+// Package mysqltest runs statements of mysql-test style .test files and diffs
+// their outputs against .result files. Directives supported are error,
+// sorted_result, replace_column and replace_regex which modify the next
+// statement, echo, enable/disable of warnings, query_log and result_log, and
+// connect, connection and disconnect. Variables defined by let are substituted
+// by mystmt.Vars, and source includes are expanded by the mystmt splitter.
+package mysqltest
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/zyguan/mytest/mystmt"
+	"github.com/zyguan/mytest/resultset"
+)
+
+// Replacement replaces matches of Regexp by Repl, which is expanded like the
+// template of regexp.ReplaceAll.
+type Replacement struct {
+	Regexp *regexp.Regexp
+	Repl   string
+}
+
+// Modifiers are directives which only apply to the next statement.
+type Modifiers struct {
+	Errors  []ExpectedError
+	Sorted  bool
+	Columns map[int]string
+	Regexes []Replacement
+}
+
+// Add records the command if it is a modifier, it returns false otherwise.
+func (m *Modifiers) Add(cmd mystmt.Command) (bool, error) {
+	var err error
+	switch cmd.Name {
+	case "error":
+		m.Errors, err = ParseErrors(cmd.Args)
+	case "sorted_result":
+		m.Sorted = true
+	case "replace_column":
+		m.Columns, err = parseColumns(cmd.Args)
+	case "replace_regex":
+		m.Regexes, err = parseRegexes(strings.Join(cmd.Args, " "))
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, errors.New(cmd.Name + ": " + err.Error())
+	}
+	return true, nil
+}
+
+// Reset clears modifiers once the statement is done.
+func (m *Modifiers) Reset() { *m = Modifiers{} }
+
+// CheckError checks the error of the statement, which is nil if it succeeds,
+// against the expected errors. The error itself is returned if there is no
+// expectation.
+func (m *Modifiers) CheckError(err error) error {
+	if len(m.Errors) == 0 {
+		return err
+	}
+	if MatchError(m.Errors, err) {
+		return nil
+	}
+	if err == nil {
+		return fmt.Errorf("statement succeeded, but %v was expected", m.Errors)
+	}
+	if e, ok := err.(*mysql.MySQLError); ok {
+		_, serr := SQLState(e)
+		for _, x := range m.Errors {
+			if len(x.SQLState) > 0 && serr != nil {
+				return fmt.Errorf("statement failed with %q, but %v was expected: %v", err.Error(), m.Errors, serr)
+			}
+		}
+	}
+	return fmt.Errorf("statement failed with %q, but %v was expected", err.Error(), m.Errors)
+}
+
+// Apply replaces values and then sorts rows of rs in place, so that the output
+// is the same as the one of mysqltest.
+func (m *Modifiers) Apply(rs *resultset.ResultSet) {
+	if rs.IsExecResult() {
+		return
+	}
+	for i := 0; i < rs.NRows(); i++ {
+		for j := 0; j < rs.NCols(); j++ {
+			if v, ok := m.Columns[j]; ok {
+				rs.SetRawValue(i, j, []byte(v))
+				continue
+			}
+			raw, _ := rs.RawValue(i, j)
+			if raw == nil {
+				continue
+			}
+			for _, r := range m.Regexes {
+				raw = r.Regexp.ReplaceAll(raw, []byte(r.Repl))
+			}
+			rs.SetRawValue(i, j, raw)
+		}
+	}
+	if m.Sorted {
+		rs.Sort(func(i int, j int) bool { return formatRow(rs, i) < formatRow(rs, j) })
+	}
+}
+
+// parseColumns parses pairs of 1-based column numbers and replacements.
+func parseColumns(args []string) (map[int]string, error) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return nil, errors.New("expect pairs of column and replacement")
+	}
+	cols := make(map[int]string)
+	for i := 0; i < len(args); i += 2 {
+		n, err := strconv.Atoi(args[i])
+		if err != nil || n < 1 {
+			return nil, errors.New("invalid column: " + args[i])
+		}
+		cols[n-1] = args[i+1]
+	}
+	return cols, nil
+}
+
+var backRef = regexp.MustCompile(`\\(\d)`)
+
+// parseRegexes parses replacements like "/from/to/ /from/to/i", the character
+// following the pattern of a replacement is its delimiter.
+func parseRegexes(s string) ([]Replacement, error) {
+	var rs []Replacement
+	for s = strings.TrimSpace(s); len(s) > 0; s = strings.TrimSpace(s) {
+		var parts [2]string
+		d, rest := s[0], s[1:]
+		for k := range parts {
+			i := 0
+			for ; i < len(rest) && rest[i] != d; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) && rest[i+1] == d {
+					i++
+				}
+			}
+			if i == len(rest) {
+				return nil, errors.New("unterminated replacement: " + s)
+			}
+			parts[k] = strings.Replace(rest[:i], "\\"+string(d), string(d), -1)
+			rest = rest[i+1:]
+		}
+		pattern := parts[0]
+		if strings.HasPrefix(rest, "i") {
+			pattern, rest = "(?i)"+pattern, rest[1:]
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		// mysqltest refers to groups by \N rather than $N.
+		repl := backRef.ReplaceAllString(strings.Replace(parts[1], "$", "$$", -1), "$${$1}")
+		rs = append(rs, Replacement{Regexp: re, Repl: repl})
+		s = rest
+	}
+	if len(rs) == 0 {
+		return nil, errors.New("missing replacements")
+	}
+	return rs, nil
+}
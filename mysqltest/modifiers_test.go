@@ -0,0 +1,107 @@
+package mysqltest
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/zyguan/mytest/mystmt"
+	"github.com/zyguan/mytest/resultset"
+)
+
+func newResultSet(cols []string, rows ...[]interface{}) *resultset.ResultSet {
+	defs := make([]resultset.ColumnDef, len(cols))
+	for i, c := range cols {
+		defs[i].Name = c
+	}
+	rs := resultset.New(defs)
+	for _, row := range rows {
+		for j, p := range rs.AllocateRow() {
+			if v, ok := row[j].(string); ok {
+				*(p.(*[]byte)) = []byte(v)
+			}
+		}
+	}
+	return rs
+}
+
+func TestParseErrors(t *testing.T) {
+	errs, err := ParseErrors([]string{"1146,ER_DUP_ENTRY,", "S42S02", "0"})
+	assert.NoError(t, err)
+	assert.Equal(t, []ExpectedError{{Code: 1146}, {Code: 1062}, {SQLState: "42S02"}, {Code: 0}}, errs)
+	for _, args := range [][]string{nil, {","}, {"ER_WHATEVER"}, {"70000"}} {
+		_, err = ParseErrors(args)
+		assert.Error(t, err, "%v", args)
+	}
+
+	noTable := &mysql.MySQLError{Number: 1146, SQLState: [5]byte{'4', '2', 'S', '0', '2'}, Message: "Table 'test.t' doesn't exist"}
+	assert.True(t, MatchError([]ExpectedError{{Code: 1146}}, noTable))
+	assert.True(t, MatchError([]ExpectedError{{Code: 1050}, {SQLState: "42S02"}}, noTable))
+	assert.False(t, MatchError([]ExpectedError{{Code: 0}}, noTable))
+	assert.False(t, MatchError([]ExpectedError{{Code: 1146}}, errors.New("oops")))
+	assert.True(t, MatchError([]ExpectedError{{Code: 1146}, {Code: 0}}, nil))
+	assert.False(t, MatchError([]ExpectedError{{SQLState: "42S02"}}, nil))
+	state, err := SQLState(noTable)
+	assert.NoError(t, err)
+	assert.Equal(t, "42S02", state)
+
+	// errors without a sqlstate from the server never match sqlstates.
+	noState := &mysql.MySQLError{Number: 1146, Message: noTable.Message}
+	_, err = SQLState(noState)
+	assert.EqualError(t, err, "unknown sqlstate of error 1146")
+	assert.False(t, MatchError([]ExpectedError{{SQLState: "42S02"}}, noState))
+	assert.True(t, MatchError([]ExpectedError{{Code: 1146}}, noState))
+}
+
+func TestModifiers(t *testing.T) {
+	var m Modifiers
+	for _, cmd := range []mystmt.Command{
+		{Name: "error", Args: []string{"ER_NO_SUCH_TABLE"}},
+		{Name: "sorted_result"},
+		{Name: "replace_column", Args: []string{"2", "#"}},
+		{Name: "replace_regex", Args: []string{`/(a+)b/\1-$/`, `#x\#y#z#i`}},
+	} {
+		ok, err := m.Add(cmd)
+		assert.True(t, ok)
+		assert.NoError(t, err)
+	}
+	ok, err := m.Add(mystmt.Command{Name: "echo"})
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	rs := newResultSet([]string{"a", "b", "c"},
+		[]interface{}{"X#Y", "1", nil},
+		[]interface{}{"aab", "2", "x#y"},
+		[]interface{}{nil, nil, "aaab"})
+	m.Apply(rs)
+	var buf bytes.Buffer
+	NewWriter(&buf).Result(rs)
+	assert.Equal(t, "a\tb\tc\nNULL\t#\taaa-$\naa-$\t#\tz\nz\t#\tNULL\n", buf.String())
+
+	assert.NoError(t, m.CheckError(&mysql.MySQLError{Number: 1146}))
+	assert.Error(t, m.CheckError(nil))
+	assert.Error(t, m.CheckError(&mysql.MySQLError{Number: 1050}))
+	m.Reset()
+	assert.NoError(t, m.CheckError(nil))
+	assert.Equal(t, &mysql.MySQLError{Number: 1050}, m.CheckError(&mysql.MySQLError{Number: 1050}))
+	m.Errors = []ExpectedError{{SQLState: "42S02"}}
+	err = m.CheckError(&mysql.MySQLError{Number: 1146})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown sqlstate of error 1146")
+	m.Reset()
+
+	for _, cmd := range []mystmt.Command{
+		{Name: "error"},
+		{Name: "replace_column", Args: []string{"1"}},
+		{Name: "replace_column", Args: []string{"0", "x"}},
+		{Name: "replace_regex", Args: []string{"/a/b"}},
+		{Name: "replace_regex", Args: []string{"/(/b/"}},
+		{Name: "replace_regex"},
+	} {
+		ok, err = m.Add(cmd)
+		assert.True(t, ok)
+		assert.Error(t, err, "%v", cmd)
+	}
+}
@@ -0,0 +1,98 @@
+package mysqltest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/zyguan/mytest/resultset"
+)
+
+// Writer writes outputs of a test in the format of mysqltest .result files.
+// Writing stops at the first error, which is returned by Err.
+type Writer struct {
+	w   io.Writer
+	err error
+
+	QueryLog  bool
+	ResultLog bool
+	Warnings  bool
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, QueryLog: true, ResultLog: true, Warnings: true}
+}
+
+func (w *Writer) Err() error { return w.err }
+
+func (w *Writer) printf(format string, args ...interface{}) {
+	if w.err == nil {
+		_, w.err = fmt.Fprintf(w.w, format, args...)
+	}
+}
+
+// Echo writes the text of an echo directive.
+func (w *Writer) Echo(text string) { w.printf("%s\n", text) }
+
+// Query writes the text of a statement unless the query log is disabled.
+func (w *Writer) Query(text string) {
+	if w.QueryLog {
+		w.printf("%s\n", text)
+	}
+}
+
+// Result writes column names and rows separated by tabs unless the result log
+// is disabled, nothing is written for results of executed statements.
+func (w *Writer) Result(rs *resultset.ResultSet) {
+	if !w.ResultLog || rs.IsExecResult() {
+		return
+	}
+	names := make([]string, rs.NCols())
+	for j := range names {
+		names[j] = rs.ColumnDef(j).Name
+	}
+	w.printf("%s\n", strings.Join(names, "\t"))
+	for i := 0; i < rs.NRows(); i++ {
+		w.printf("%s\n", formatRow(rs, i))
+	}
+}
+
+// ShowWarnings writes rows of SHOW WARNINGS like "Warning\t1365\tDivision by 0"
+// after a "Warnings:" line unless there is none, or warnings or the result log
+// are disabled.
+func (w *Writer) ShowWarnings(rs *resultset.ResultSet) {
+	if !w.Warnings || !w.ResultLog || rs.NRows() == 0 {
+		return
+	}
+	w.printf("Warnings:\n")
+	for i := 0; i < rs.NRows(); i++ {
+		w.printf("%s\n", formatRow(rs, i))
+	}
+}
+
+// Error writes an expected error like "ERROR 42S02: Table 't' doesn't exist".
+// Writing fails if the server reports no SQLSTATE of a MySQL error.
+func (w *Writer) Error(err error) {
+	if e, ok := err.(*mysql.MySQLError); ok {
+		state, serr := SQLState(e)
+		if serr != nil && w.err == nil {
+			w.err = serr
+		}
+		w.printf("ERROR %s: %s\n", state, e.Message)
+		return
+	}
+	w.printf("ERROR HY000: %s\n", err.Error())
+}
+
+func formatRow(rs *resultset.ResultSet, i int) string {
+	vs := make([]string, rs.NCols())
+	for j := range vs {
+		if raw, _ := rs.RawValue(i, j); raw == nil {
+			vs[j] = "NULL"
+		} else {
+			vs[j] = string(raw)
+		}
+	}
+	return strings.Join(vs, "\t")
+}
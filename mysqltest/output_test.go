@@ -0,0 +1,58 @@
+package mysqltest
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/zyguan/mytest/resultset"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Query("select 1, null as x;")
+	w.Result(newResultSet([]string{"1", "x"}, []interface{}{"1", nil}))
+	w.Query("insert into t values (1);")
+	w.Result(resultset.NewExec(resultset.ExecResult{RowsAffected: 1, HasRowsAffected: true}))
+	w.Echo("# done")
+	w.QueryLog, w.ResultLog = false, false
+	w.Query("select 2;")
+	w.Result(newResultSet([]string{"2"}, []interface{}{"2"}))
+	w.Error(&mysql.MySQLError{Number: 1146, SQLState: [5]byte{'4', '2', 'S', '0', '2'}, Message: "Table 'test.t' doesn't exist"})
+	w.Error(errors.New("oops"))
+	assert.NoError(t, w.Err())
+	assert.Equal(t, "select 1, null as x;\n1\tx\n1\tNULL\ninsert into t values (1);\n# done\nERROR 42S02: Table 'test.t' doesn't exist\nERROR HY000: oops\n", buf.String())
+
+	// the sqlstate is taken from the server, writing fails without it.
+	buf.Reset()
+	w.Error(&mysql.MySQLError{Number: 1146, Message: "Table 'test.t' doesn't exist"})
+	assert.EqualError(t, w.Err(), "unknown sqlstate of error 1146")
+	assert.Equal(t, "", buf.String())
+}
+
+func TestWriter_ShowWarnings(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	warnings := newResultSet([]string{"Level", "Code", "Message"}, []interface{}{"Warning", "1365", "Division by 0"})
+	w.ShowWarnings(warnings)
+	w.ShowWarnings(newResultSet([]string{"Level", "Code", "Message"}))
+	w.Warnings = false
+	w.ShowWarnings(warnings)
+	w.Warnings, w.ResultLog = true, false
+	w.ShowWarnings(warnings)
+	assert.NoError(t, w.Err())
+	assert.Equal(t, "Warnings:\nWarning\t1365\tDivision by 0\n", buf.String())
+}
+
+func TestDiff(t *testing.T) {
+	assert.Equal(t, "", Diff("a\nb\n", "a\nb\n"))
+	assert.Equal(t, "@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n", Diff("a\nb\nc\n", "a\nx\nc\n"))
+	assert.Equal(t, "@@ -1,0 +1,1 @@\n+a\n", Diff("", "a\n"))
+
+	exp := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	act := "1\n2\nx\n3\n4\n5\n6\n7\n8\n9\n10\n12\n"
+	assert.Equal(t, "@@ -1,5 +1,6 @@\n 1\n 2\n+x\n 3\n 4\n 5\n@@ -8,5 +9,4 @@\n 8\n 9\n 10\n-11\n 12\n", Diff(exp, act))
+}
@@ -0,0 +1,58 @@
+package mystmt
+
+import "strings"
+
+// Commands of conditional blocks, which may be nested and may span included
+// files.
+const (
+	CmdIf    = "if"
+	CmdElse  = "else"
+	CmdEndif = "endif"
+)
+
+// Cond is the condition of an if command. Statements in the block apply only if
+// Expr holds, or only if it doesn't in the else block where Not is set. Expr is
+// made of args of the command and is evaluated by the runner of statements.
+type Cond struct {
+	Expr string
+	Not  bool
+	File string
+	Pos  Pos
+}
+
+// applyCond updates conditions of enclosing blocks by the if, else or endif
+// command of the file.
+func (in *includer) applyCond(file string, cmd Command) error {
+	fail := func(msg string) error { return &Error{File: file, Pos: cmd.Pos, Msg: msg} }
+	switch cmd.Name {
+	case CmdIf:
+		if len(cmd.Args) == 0 {
+			return fail("if must be followed by a condition")
+		}
+		in.conds = append(in.conds, Cond{Expr: strings.Join(cmd.Args, " "), File: file, Pos: cmd.Pos})
+	case CmdElse:
+		if len(in.conds) == 0 {
+			return fail("else without if")
+		}
+		if in.conds[len(in.conds)-1].Not {
+			return fail("duplicate else")
+		}
+		in.conds[len(in.conds)-1].Not = true
+	case CmdEndif:
+		if len(in.conds) == 0 {
+			return fail("endif without if")
+		}
+		in.conds = in.conds[:len(in.conds)-1]
+	}
+	return nil
+}
+
+// isCondCmd tells whether the command begins, switches or ends a block.
+func isCondCmd(name string) bool { return name == CmdIf || name == CmdElse || name == CmdEndif }
+
+// setConds sets conditions of blocks enclosing the statement.
+func (in *includer) setConds(stmt *Stmt) {
+	if len(in.conds) > 0 {
+		stmt.Conds = append([]Cond(nil), in.conds...)
+	}
+}
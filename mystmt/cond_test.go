@@ -0,0 +1,74 @@
+package mystmt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCond(t *testing.T) {
+	it := SplitText(`select 1;
+--if version >= 8.0
+--query w
+select row_number() over () from t;
+--if label == tidb
+select 2;
+--else
+select 3;
+--endif
+--else
+select 4;
+--endif
+select 5;
+`)
+	var ss []Stmt
+	for it.Scan() {
+		ss = append(ss, it.Stmt())
+	}
+	assert.NoError(t, it.Err())
+	v8, tidb := Cond{Expr: "version >= 8.0"}, Cond{Expr: "label == tidb"}
+	notTiDB, notV8 := tidb, v8
+	notTiDB.Not, notV8.Not = true, true
+	assert.Equal(t, []Stmt{
+		{Text: "select 1;"},
+		{Text: "select row_number() over () from t;", Commands: []Command{{Name: "query", Args: []string{"w"}}}, Conds: []Cond{v8}},
+		{Text: "select 2;", Conds: []Cond{v8, tidb}},
+		{Text: "select 3;", Conds: []Cond{v8, notTiDB}},
+		{Text: "select 4;", Conds: []Cond{notV8}},
+		{Text: "select 5;"},
+	}, withoutPos(ss))
+}
+
+func TestSplitTestCond(t *testing.T) {
+	it := SplitTestText("--if $n > 1\n--echo many\nselect 1;\necho ok;\n--endif\n--echo done\n")
+	var ss []Stmt
+	for it.Scan() {
+		ss = append(ss, it.Stmt())
+	}
+	assert.NoError(t, it.Err())
+	c := Cond{Expr: "$n > 1"}
+	assert.Equal(t, []Stmt{
+		{Text: "select 1;", Commands: []Command{{Name: "echo", Args: []string{"many"}}}, Conds: []Cond{c}},
+		{Commands: []Command{{Name: "echo", Args: []string{"ok"}}}, Conds: []Cond{c}},
+		{Commands: []Command{{Name: "echo", Args: []string{"done"}}}},
+	}, withoutPos(ss))
+}
+
+func TestSplitCondErr(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		err  string
+	}{
+		{"select 1;\n--if\nselect 2;", "2:1: if must be followed by a condition"},
+		{"select 1;\n--else\nselect 2;", "2:1: else without if"},
+		{"--if a\nselect 1;\n--else\n--else\nselect 2;", "4:1: duplicate else"},
+		{"select 1;\n--endif\nselect 2;", "2:1: endif without if"},
+		{"select 1;\n--if a\nselect 2;", "2:1: if without endif"},
+		{"--if a\n--if b\nselect 1;\n--endif\n", "1:1: if without endif"},
+	} {
+		it := SplitText(tt.text)
+		for it.Scan() {
+		}
+		assert.EqualError(t, it.Err(), tt.err, tt.text)
+	}
+}
@@ -0,0 +1,169 @@
+package mystmt
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+)
+
+// CmdSource includes statements of another file in place of the command, the
+// path is relative to the including file. Files are included as statements are
+// split, before conditions are evaluated by the runner, so a file sourced in an
+// if block is always read: it must exist and must not form a cycle even if the
+// block never applies. Its statements take conditions of the enclosing blocks.
+const CmdSource = "source"
+
+type frame struct {
+	it   *iterator
+	file string
+	abs  string
+	// rest is the statement owning the source command which includes the file
+	// of the next frame, it's returned once the included file is done.
+	rest *Stmt
+}
+
+// includer expands source commands recursively. Commands followed by a source
+// command apply to the first statement of the included file, just like they
+// apply to the next statement.
+type includer struct {
+	frames  []frame
+	test    bool
+	pending []Command
+	conds   []Cond
+	stmt    Stmt
+	scanned bool
+	err     error
+}
+
+func newIncluder(it *iterator) *includer {
+	abs := ""
+	if len(it.file) > 0 {
+		abs, _ = filepath.Abs(it.file)
+	}
+	return &includer{frames: []frame{{it: it, file: it.file, abs: abs}}, test: it.test}
+}
+
+func (in *includer) Scan() bool {
+	in.scanned = true
+next:
+	for in.err == nil && len(in.frames) > 0 {
+		f := &in.frames[len(in.frames)-1]
+		var stmt Stmt
+		if f.rest != nil {
+			stmt, f.rest = *f.rest, nil
+		} else if f.it.Scan() {
+			stmt = f.it.Stmt()
+			stmt.IncludedFrom = in.includers()
+		} else {
+			in.err = f.it.Err()
+			in.frames = in.frames[:len(in.frames)-1]
+			continue
+		}
+		// blocks are applied as commands are read, so they're located in the
+		// file they're written in even if the statement is in another one.
+		var cmds []Command
+		for i, cmd := range stmt.Commands {
+			if isCondCmd(cmd.Name) {
+				if in.err = in.applyCond(stmt.File, cmd); in.err != nil {
+					return false
+				}
+				continue
+			}
+			if cmd.Name != CmdSource {
+				cmds = append(cmds, cmd)
+				continue
+			}
+			in.pending = append(in.pending, cmds...)
+			rest := stmt
+			rest.Commands = stmt.Commands[i+1:]
+			f.rest = &rest
+			in.err = in.include(cmd)
+			continue next
+		}
+		if len(stmt.Text) == 0 && !in.test {
+			in.pending = append(in.pending, cmds...)
+			continue
+		}
+		stmt.Commands, in.pending = append(in.pending, cmds...), nil
+		if len(stmt.Text) == 0 && len(stmt.Commands) == 0 {
+			continue
+		}
+		in.setConds(&stmt)
+		in.stmt = stmt
+		return true
+	}
+	if in.err == nil && len(in.pending) > 0 {
+		stmt := Stmt{Commands: in.pending}
+		in.pending = nil
+		in.setConds(&stmt)
+		// commands at the end of a test are returned without a statement.
+		if in.test {
+			in.stmt = stmt
+			return true
+		}
+	}
+	if in.err == nil && len(in.conds) > 0 {
+		c := in.conds[len(in.conds)-1]
+		in.err = &Error{File: c.File, Pos: c.Pos, Msg: "if without endif"}
+	}
+	return false
+}
+
+// include pushes the file included by the source command, which is relative
+// to the file of the top frame.
+func (in *includer) include(cmd Command) error {
+	top := in.frames[len(in.frames)-1]
+	fail := func(msg string) error { return &Error{File: top.file, Pos: cmd.Pos, Msg: msg} }
+	if len(cmd.Args) == 0 {
+		return fail("source must be followed by a file")
+	}
+	file := cmd.Args[0]
+	if !filepath.IsAbs(file) && len(top.file) > 0 {
+		file = filepath.Join(filepath.Dir(top.file), file)
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return fail(err.Error())
+	}
+	for i, f := range in.frames {
+		if f.abs != abs {
+			continue
+		}
+		chain := make([]string, 0, len(in.frames)-i+1)
+		for _, f := range in.frames[i:] {
+			chain = append(chain, f.file)
+		}
+		return fail("include cycle: " + strings.Join(append(chain, file), " -> "))
+	}
+	stream, err := antlr.NewFileStream(file)
+	if err != nil {
+		return fail(err.Error())
+	}
+	in.frames = append(in.frames, frame{it: newIterator(stream, file, in.test), file: file, abs: abs})
+	return nil
+}
+
+// includers returns files including the file of the top frame.
+func (in *includer) includers() []string {
+	if len(in.frames) < 2 {
+		return nil
+	}
+	files := make([]string, len(in.frames)-1)
+	for i := range files {
+		files[i] = in.frames[i].file
+	}
+	return files
+}
+
+func (in *includer) Text() string { return in.stmt.Text }
+
+func (in *includer) Stmt() Stmt { return in.stmt }
+
+func (in *includer) Err() error {
+	if in.err == nil && !in.scanned {
+		return errors.New("scan hasn't been called")
+	}
+	return in.err
+}
@@ -0,0 +1,113 @@
+package mystmt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, text := range files {
+		f := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(f), 0755))
+		assert.NoError(t, ioutil.WriteFile(f, []byte(text), 0644))
+	}
+}
+
+func TestSplitInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mystmt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"main.sql":       "select 1;\n--query k\n--source inc/a.sql\n--execute\nselect 4;\n--source inc/empty.sql\nselect 5;",
+		"inc/a.sql":      "\n  select 2;\n--source ../common.sql\n",
+		"inc/empty.sql":  "# nothing\n",
+		"common.sql":     "select 3;",
+		"cycle/x.sql":    "select 1;\n--source y.sql\nselect 2;",
+		"cycle/y.sql":    "\n--source x.sql\nselect 3;",
+		"bad/main.sql":   "--source missing.sql\nselect 1;",
+		"bad/noarg.sql":  "--source\nselect 1;",
+		"bad/lexer.sql":  "--source ../inc/a.sql\n--source broken.sql\nselect 1;",
+		"bad/broken.sql": "select 'x",
+		"cond/main.sql":  "--if label == a\n--source a.sql\n--endif\nselect 2;",
+		"cond/a.sql":     "select 1;",
+		"cond/bad.sql":   "--if 0\n--source missing.sql\n--endif\nselect 1;",
+	})
+
+	main := filepath.Join(dir, "main.sql")
+	it, err := SplitFile(main)
+	assert.NoError(t, err)
+	out, err := scanAll(it)
+	assert.NoError(t, err)
+	a, common := filepath.Join(dir, "inc/a.sql"), filepath.Join(dir, "common.sql")
+	assert.Equal(t, []Stmt{
+		{Text: "select 1;", File: main, Start: Pos{1, 1, 0}, End: Pos{1, 10, 9}},
+		{Text: "select 2;", Commands: []Command{{Name: "query", Args: []string{"k"}, Pos: Pos{2, 1, 10}}},
+			File: a, Start: Pos{2, 3, 3}, End: Pos{2, 12, 12}, IncludedFrom: []string{main}},
+		{Text: "select 3;", File: common, Start: Pos{1, 1, 0}, End: Pos{1, 10, 9}, IncludedFrom: []string{main, a}},
+		{Text: "select 4;", Commands: []Command{{Name: "execute", Args: []string{}, Pos: Pos{4, 1, 39}}},
+			File: main, Start: Pos{5, 1, 49}, End: Pos{5, 10, 58}},
+		{Text: "select 5;", File: main, Start: Pos{7, 1, 82}, End: Pos{7, 10, 91}},
+	}, out)
+	assert.Equal(t, main, out[2].Root())
+	assert.Equal(t, main, out[0].Root())
+
+	// files sourced in if blocks are included regardless of conditions.
+	it, err = SplitFile(filepath.Join(dir, "cond/main.sql"))
+	assert.NoError(t, err)
+	out, err = scanAll(it)
+	assert.NoError(t, err)
+	assert.Equal(t, []Stmt{
+		{Text: "select 1;", Conds: []Cond{{Expr: "label == a", File: filepath.Join(dir, "cond/main.sql")}}},
+		{Text: "select 2;"},
+	}, withoutFiles(withoutPos(out)))
+
+	for file, msg := range map[string]string{
+		"cycle/x.sql":   "y.sql:2:1: include cycle: %[1]s/cycle/x.sql -> %[1]s/cycle/y.sql -> %[1]s/cycle/x.sql",
+		"bad/main.sql":  "main.sql:1:1: open %[1]s/bad/missing.sql: no such file or directory",
+		"bad/noarg.sql": "noarg.sql:1:1: source must be followed by a file",
+		"bad/lexer.sql": "broken.sql:1:8: unterminated quoted string",
+		"cond/bad.sql":  "bad.sql:2:1: open %[1]s/cond/missing.sql: no such file or directory",
+	} {
+		it, err := SplitFile(filepath.Join(dir, file))
+		assert.NoError(t, err)
+		for it.Scan() {
+		}
+		assert.Error(t, it.Err(), file)
+		if it.Err() != nil {
+			assert.Contains(t, it.Err().Error(), strings.Replace(msg, "%[1]s", dir, -1), file)
+		}
+	}
+}
+
+func TestSplitTestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mystmt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"t/a.test":            "--echo begin\nsource include/setup.inc;\nselect 2;\n--source include/end.inc\n",
+		"t/include/setup.inc": "--disable_warnings\ncreate table t (a int);\n--enable_warnings\n",
+		"t/include/end.inc":   "--echo end\n",
+	})
+	it, err := SplitTestFile(filepath.Join(dir, "t/a.test"))
+	assert.NoError(t, err)
+	out, err := scanAll(it)
+	assert.NoError(t, err)
+	assert.Equal(t, []Stmt{
+		{Text: "create table t (a int);", Commands: []Command{{Name: "echo", Args: []string{"begin"}}, {Name: "disable_warnings", Args: []string{}}}},
+		{Commands: []Command{{Name: "enable_warnings", Args: []string{}}}},
+		{Text: "select 2;"},
+		{Commands: []Command{{Name: "echo", Args: []string{"end"}}}},
+	}, withoutFiles(withoutPos(out)))
+}
+
+func withoutFiles(ss []Stmt) []Stmt {
+	for i := range ss {
+		ss[i].File, ss[i].IncludedFrom = "", nil
+	}
+	return ss
+}
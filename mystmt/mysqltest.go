@@ -0,0 +1,76 @@
+package mystmt
+
+import (
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+)
+
+// TestDirectives are mysqltest commands which can also be written as
+// statements like "echo foo;" in .test files.
+var TestDirectives = map[string]bool{
+	"error":              true,
+	"sorted_result":      true,
+	"replace_column":     true,
+	"replace_regex":      true,
+	"echo":               true,
+	"disable_warnings":   true,
+	"enable_warnings":    true,
+	"disable_query_log":  true,
+	"enable_query_log":   true,
+	"disable_result_log": true,
+	"enable_result_log":  true,
+	"connect":            true,
+	"connection":         true,
+	"disconnect":         true,
+	"let":                true,
+	"source":             true,
+}
+
+// finish completes the statement in the buffer. In mysqltest mode, directives
+// written as statements are turned into commands and a statement without text
+// is returned for them, it returns false if nothing should be returned.
+func (it *iterator) finish() bool {
+	if !it.test {
+		return true
+	}
+	text := it.buf.String()
+	if it.delim == ";" {
+		text = strings.TrimSuffix(text, ";")
+	}
+	ss := strings.Fields(text)
+	name := strings.ToLower(ss[0])
+	if name == "delimiter" {
+		if len(ss) > 1 {
+			it.delim = ss[1]
+		} else {
+			it.err = &Error{File: it.file, Pos: it.start, Msg: "DELIMITER must be followed by a delimiter"}
+		}
+		it.buf.Truncate(0)
+		it.started = false
+		return false
+	}
+	if TestDirectives[name] {
+		cmd := newCommand(text, it.start)
+		cmd.Name = name
+		it.cmds = append(it.cmds, cmd)
+		it.buf.Truncate(0)
+	}
+	return true
+}
+
+// SplitTestText splits a mysqltest .test file. Besides "--cmd args" comments,
+// directives written as statements are returned as commands of statements
+// without text, so are directives at the end of the file. The delimiter is
+// changed by "delimiter X;" or "--delimiter X".
+func SplitTestText(text string) Iterator {
+	return newIncluder(newIterator(antlr.NewInputStream(text), "", true))
+}
+
+func SplitTestFile(file string) (Iterator, error) {
+	in, err := antlr.NewFileStream(file)
+	if err != nil {
+		return nil, err
+	}
+	return newIncluder(newIterator(in, file, true)), nil
+}
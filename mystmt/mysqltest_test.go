@@ -0,0 +1,58 @@
+package mystmt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitTestText(t *testing.T) {
+	text := `# a comment
+--disable_warnings
+drop table if exists t1;
+--enable_warnings
+let $n = 10;
+--error 1146,ER_NO_SUCH_TABLE
+select * from t2;
+delimiter |;
+create procedure p()
+begin
+  select 1;
+end|
+delimiter ;|
+--sorted_result
+--replace_column 1 #
+select * from t1;
+connection con1;
+connect (con2, localhost, u 1,  p  w ,);
+--delimiter //
+select 2 //
+--echo bye
+--echo  a  b	c $d
+delimiter ;//
+echo x   y;
+`
+	out, err := scanAll(SplitTestText(text))
+	assert.NoError(t, err)
+	assert.Equal(t, []Stmt{
+		{Text: "drop table if exists t1;", Commands: []Command{{Name: "disable_warnings", Args: []string{}}}},
+		{Commands: []Command{{Name: "enable_warnings", Args: []string{}}, {Name: "let", Args: []string{"$n", "=", "10"}}}},
+		{Text: "select * from t2;", Commands: []Command{{Name: "error", Args: []string{"1146,ER_NO_SUCH_TABLE"}}}},
+		{Text: "create procedure p()\nbegin\n  select 1;\nend"},
+		{Text: "select * from t1;", Commands: []Command{{Name: "sorted_result", Args: []string{}}, {Name: "replace_column", Args: []string{"1", "#"}}}},
+		{Commands: []Command{{Name: "connection", Args: []string{"con1"}}}},
+		{Commands: []Command{{Name: "connect", Args: []string{"(con2, localhost, u 1,  p  w ,)"}}}},
+		{Text: "select 2"},
+		{Commands: []Command{{Name: "echo", Args: []string{"bye"}}, {Name: "echo", Args: []string{"a  b\tc $d"}}, {Name: "echo", Args: []string{"x   y"}}}},
+	}, withoutPos(out))
+
+	it := SplitTestText("select 1;\ndelimiter ;")
+	assert.True(t, it.Scan())
+	assert.False(t, it.Scan())
+	assert.EqualError(t, it.Err(), "2:1: DELIMITER must be followed by a delimiter")
+
+	// delimiter directives end with the current delimiter rather than a newline.
+	out, err = scanAll(SplitTestText("delimiter //\nselect 1;\nselect 2 //"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Stmt{{Text: "select 2"}}, withoutPos(out))
+}
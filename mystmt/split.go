@@ -3,6 +3,7 @@ package mystmt
 import (
 	"bytes"
 	"errors"
+	"strconv"
 	"strings"
 
 	"github.com/antlr/antlr4/runtime/Go/antlr"
@@ -13,11 +14,91 @@ import (
 type Command struct {
 	Name string
 	Args []string
+	Pos  Pos
 }
 
+// lineCommands take the rest of the line as their only arg, spaces in it are
+// kept as is.
+var lineCommands = map[string]bool{"echo": true, "connect": true}
+
+// newCommand parses a command from s like "--name arg1 arg2".
+func newCommand(s string, pos Pos) Command {
+	ss := strings.Fields(s)
+	cmd := Command{Name: strings.TrimLeft(ss[0], "-"), Args: ss[1:], Pos: pos}
+	if lineCommands[strings.ToLower(cmd.Name)] && len(cmd.Args) > 0 {
+		cmd.Args = []string{strings.TrimSpace(s[strings.Index(s, ss[0])+len(ss[0]):])}
+	}
+	return cmd
+}
+
+// Pos is a position in the input, Line and Column are 1-based and Column counts
+// characters, Offset is the 0-based byte offset.
+type Pos struct {
+	Line   int
+	Column int
+	Offset int
+}
+
+func (p Pos) String() string {
+	return strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column)
+}
+
+func (p *Pos) advance(s string) {
+	for _, r := range s {
+		if r == '\n' {
+			p.Line, p.Column = p.Line+1, 1
+		} else {
+			p.Column++
+		}
+	}
+	p.Offset += len(s)
+}
+
+// Stmt is a statement split from File. Start and End delimit the statement in
+// the input without leading spaces and comments, End is right after its
+// delimiter if any, otherwise the last character of it.
 type Stmt struct {
 	Text     string
 	Commands []Command
+	File     string
+	Start    Pos
+	End      Pos
+	// IncludedFrom lists files including File by source commands, from the
+	// outermost one.
+	IncludedFrom []string
+	// Conds are conditions of blocks enclosing the statement, from the
+	// outermost one, the statement applies only if all of them hold.
+	Conds []Cond
+}
+
+// Root returns the outermost file the statement comes from.
+func (s Stmt) Root() string {
+	if len(s.IncludedFrom) > 0 {
+		return s.IncludedFrom[0]
+	}
+	return s.File
+}
+
+// Location returns where the statement starts like "file.sql:42".
+func (s Stmt) Location() string {
+	if len(s.File) == 0 {
+		return "line " + strconv.Itoa(s.Start.Line)
+	}
+	return s.File + ":" + strconv.Itoa(s.Start.Line)
+}
+
+// Error is a lexical error at Pos of File.
+type Error struct {
+	File string
+	Pos  Pos
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if len(e.File) == 0 {
+		return e.Pos.String() + ": " + e.Msg
+	}
+	return e.File + ":" + e.Pos.String() + ": " + e.Msg
 }
 
 type Iterator interface {
@@ -27,40 +108,161 @@ type Iterator interface {
 	Err() error
 }
 
+const spaces = " \t\r\n"
+
+// iterator splits statements on the current delimiter, which is ";" by default
+// and can be changed by DELIMITER commands like the mysql client does. The ";"
+// delimiter is kept in the text of statements, while others are not.
 type iterator struct {
-	lexer *MyStmt
-	head  antlr.Token
-	buf   bytes.Buffer
-	cmds  []Command
+	lexer   *MyStmt
+	test    bool
+	delim   string
+	file    string
+	head    antlr.Token
+	buf     bytes.Buffer
+	cmds    []Command
+	pos     Pos
+	started bool
+	// firstLine is true until the statement spans multiple lines.
+	firstLine bool
+	start     Pos
+	end       Pos
+	err       error
+}
+
+func newIterator(in antlr.CharStream, file string, test bool) *iterator {
+	it := &iterator{lexer: NewMyStmt(in), file: file, test: test, delim: ";", pos: Pos{Line: 1, Column: 1}}
+	it.lexer.RemoveErrorListeners()
+	it.lexer.AddErrorListener(&errorListener{it: it})
+	return it
 }
 
 func (it *iterator) Scan() bool {
 	it.buf.Truncate(0)
-	it.cmds = nil
-	for {
+	it.cmds, it.started, it.start = nil, false, it.pos
+	for it.err == nil {
 		it.head = it.lexer.NextToken()
+		if it.err != nil {
+			break
+		}
 		tt := it.head.GetTokenType()
 		if tt == antlr.TokenEOF {
-			if it.buf.Len() > 0 && len(strings.TrimSpace(it.buf.String())) > 0 {
+			if it.delimiterLine() {
+				it.setDelimiter()
+				return false
+			}
+			if it.started && it.finish() {
 				return true
 			}
-			return false
-		} else if tt == MyStmtCOMMAND_COMMENT {
-			s := it.head.GetText()
+			// trailing commands are returned without a statement, the includer
+			// keeps the ones that matter.
+			return len(it.cmds) > 0
+		}
+		s, pos := it.head.GetText(), it.pos
+		it.pos.advance(s)
+		if tt == MyStmtCOMMAND_COMMENT {
 			if !strings.HasPrefix(s, "--") {
 				continue
 			}
-			ss := strings.Fields(s)
-			cmd := Command{strings.TrimLeft(ss[0], "-"), ss[1:]}
+			cmd := newCommand(s, pos)
+			if it.test && cmd.Name == "delimiter" && len(cmd.Args) > 0 {
+				it.delim = cmd.Args[0]
+				continue
+			}
 			it.cmds = append(it.cmds, cmd)
-		} else if tt == MyStmtSEMI {
-			if it.buf.Len() == 0 {
+			continue
+		}
+		if tt < antlr.TokenMinUserTokenType || it.head.GetChannel() == antlr.TokenHiddenChannel {
+			continue
+		}
+		if tt == MyStmtSEMI && it.delim == ";" && !it.delimiterLine() {
+			if !it.started {
 				continue
 			}
 			it.buf.WriteByte(';')
-			return true
-		} else if tt >= antlr.TokenMinUserTokenType && it.head.GetChannel() != antlr.TokenHiddenChannel {
-			it.buf.WriteString(it.head.GetText())
+			it.end = pos
+			it.end.advance(";")
+			if it.finish() {
+				return true
+			}
+			continue
+		}
+		if tt == MyStmtANY {
+			it.checkUnterminated(s, pos)
+		}
+		if tt == MyStmtSPACE {
+			if !it.started {
+				continue
+			}
+		} else {
+			if !it.started {
+				it.started, it.firstLine, it.start = true, true, pos
+			}
+			it.end = it.pos
+		}
+		it.buf.WriteString(s)
+		if it.firstLine && strings.ContainsRune(s, '\n') {
+			if it.delimiterLine() {
+				it.setDelimiter()
+				continue
+			}
+			it.firstLine = false
+		}
+		if it.delim != ";" && (tt == MyStmtANY || tt == MyStmtSEMI) && !it.delimiterLine() && it.cutDelimiter() {
+			it.end = pos
+			it.end.advance(strings.TrimRight(s, spaces))
+			if it.buf.Len() > 0 && it.finish() {
+				return true
+			}
+			it.buf.Truncate(0)
+			it.started = false
+		}
+	}
+	return false
+}
+
+// delimiterLine tells whether the current statement is a DELIMITER command,
+// which is recognized on the first line of a statement only.
+func (it *iterator) delimiterLine() bool {
+	if it.test || !it.started || !it.firstLine {
+		return false
+	}
+	b := it.buf.Bytes()
+	return len(b) > 9 && bytes.EqualFold(b[:9], []byte("delimiter")) && (b[9] == ' ' || b[9] == '\t')
+}
+
+// setDelimiter changes the delimiter to the one given by the DELIMITER command
+// in the buffer, and then drops the command.
+func (it *iterator) setDelimiter() {
+	if args := strings.Fields(it.buf.String())[1:]; len(args) > 0 {
+		it.delim = args[0]
+	} else {
+		it.err = &Error{File: it.file, Pos: it.start, Msg: "DELIMITER must be followed by a delimiter"}
+	}
+	it.buf.Truncate(0)
+	it.started, it.firstLine = false, false
+}
+
+// cutDelimiter removes the delimiter and spaces before it from the end of the
+// buffer, it returns false if the buffer does not end with the delimiter.
+func (it *iterator) cutDelimiter() bool {
+	b := bytes.TrimRight(it.buf.Bytes(), spaces)
+	if !bytes.HasSuffix(b, []byte(it.delim)) {
+		return false
+	}
+	it.buf.Truncate(len(bytes.TrimRight(b[:len(b)-len(it.delim)], spaces)))
+	return true
+}
+
+// checkUnterminated reports the error if s is the beginning of a quoted string
+// or a comment, which can only be matched by ANY if it's never closed.
+func (it *iterator) checkUnterminated(s string, pos Pos) {
+	switch s {
+	case "'", "\"", "`":
+		it.err = &Error{File: it.file, Pos: pos, Msg: "unterminated quoted string"}
+	case "/":
+		if it.lexer.GetInputStream().LA(1) == '*' {
+			it.err = &Error{File: it.file, Pos: pos, Msg: "unterminated comment"}
 		}
 	}
 }
@@ -70,19 +272,36 @@ func (it *iterator) Text() string {
 }
 
 func (it *iterator) Stmt() Stmt {
-	return Stmt{Text: it.buf.String(), Commands: it.cmds}
+	return Stmt{Text: it.buf.String(), Commands: it.cmds, File: it.file, Start: it.start, End: it.end}
 }
 
 func (it *iterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
 	if it.head == nil {
 		return errors.New("scan hasn't been called")
 	}
 	return nil
 }
 
+// errorListener records the first error of the lexer, its position is where
+// the lexer stops, which is right after the last token.
+type errorListener struct {
+	antlr.DefaultErrorListener
+	it *iterator
+}
+
+func (l *errorListener) SyntaxError(recognizer antlr.Recognizer, offendingSymbol interface{}, line, column int, msg string, e antlr.RecognitionException) {
+	if l.it.err == nil {
+		l.it.err = &Error{File: l.it.file, Pos: l.it.pos, Msg: msg}
+	}
+}
+
+// SplitText splits statements of the text, files included by source commands
+// are relative to the working directory.
 func SplitText(text string) Iterator {
-	in := antlr.NewInputStream(text)
-	return &iterator{lexer: NewMyStmt(in)}
+	return newIncluder(newIterator(antlr.NewInputStream(text), "", false))
 }
 
 func SplitFile(file string) (Iterator, error) {
@@ -90,5 +309,5 @@ func SplitFile(file string) (Iterator, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &iterator{lexer: NewMyStmt(in)}, nil
+	return newIncluder(newIterator(in, file, false)), nil
 }
@@ -35,6 +35,19 @@ PARTITION p3 VALUES LESS THAN (2005));`,
 	`CREATE TABLE t2 ( id INT NOT NULL AUTO_INCREMENT, name VARCHAR(50), purchased DATE, KEY(id)) PARTITION BY HASH( YEAR(purchased) ) PARTITIONS 4;`,
 }
 
+func withoutPos(ss []Stmt) []Stmt {
+	for i := range ss {
+		ss[i].Start, ss[i].End = Pos{}, Pos{}
+		for j := range ss[i].Commands {
+			ss[i].Commands[j].Pos = Pos{}
+		}
+		for j := range ss[i].Conds {
+			ss[i].Conds[j].Pos = Pos{}
+		}
+	}
+	return ss
+}
+
 func toStmts(texts ...string) []Stmt {
 	stmts := make([]Stmt, len(texts))
 	for i := range stmts {
@@ -55,6 +68,7 @@ func TestSplitText(t *testing.T) {
 		{"select 1;", toStmts("select 1;")},
 		{"select 1;\n\n# xxx\n ", toStmts("select 1;")},
 		{";;select 1;;", toStmts("select 1;")},
+		{"/* c */ ; select 1;", toStmts("select 1;")},
 		{"select 'foo;';", toStmts("select 'foo;';")},
 		{"--select 'foo;';", nil},
 		{"-- select 'foo;';", nil},
@@ -66,19 +80,111 @@ func TestSplitText(t *testing.T) {
 		{"select 1;\nselect 2;", toStmts("select 1;", "select 2;")},
 		{"# foo;\nselect 1;\nselect /* bar; */ 2\n;\n", toStmts("select 1;", "select  2\n;")},
 		{strings.Join(texts, "\n"), toStmts(texts...)},
-		{"--foo\nselect 1;", []Stmt{{"select 1;", []Command{{"foo", []string{}}}}}},
-		{"--foo 1\nselect 1;", []Stmt{{"select 1;", []Command{{"foo", []string{"1"}}}}}},
-		{"--foo --bar\nselect 1;", []Stmt{{"select 1;", []Command{{"foo", []string{"--bar"}}}}}},
-		{"--foo\n--bar\nselect 1;", []Stmt{{"select 1;", []Command{{"foo", []string{}}, {"bar", []string{}}}}}},
+		{"--foo\nselect 1;", []Stmt{{Text: "select 1;", Commands: []Command{{Name: "foo", Args: []string{}}}}}},
+		{"--foo 1\nselect 1;", []Stmt{{Text: "select 1;", Commands: []Command{{Name: "foo", Args: []string{"1"}}}}}},
+		{"--foo --bar\nselect 1;", []Stmt{{Text: "select 1;", Commands: []Command{{Name: "foo", Args: []string{"--bar"}}}}}},
+		{"--foo\n--bar\nselect 1;", []Stmt{{Text: "select 1;", Commands: []Command{{Name: "foo", Args: []string{}}, {Name: "bar", Args: []string{}}}}}},
 		{"--foo\n--bar\nselect 1; select 2; --query 1 \nselect 'x'", []Stmt{
-			{"select 1;", []Command{{"foo", []string{}}, {"bar", []string{}}}},
-			{"select 2;", nil},
-			{"select 'x'", []Command{{"query", []string{"1"}}}},
+			{Text: "select 1;", Commands: []Command{{Name: "foo", Args: []string{}}, {Name: "bar", Args: []string{}}}},
+			{Text: "select 2;", Commands: nil},
+			{Text: "select 'x'", Commands: []Command{{Name: "query", Args: []string{"1"}}}},
 		}},
 	} {
 		it := SplitText(tt.text)
 		out, err := scanAll(it)
 		assert.NoError(t, err, "split: "+tt.text)
-		assert.Equal(t, tt.out, out)
+		assert.Equal(t, tt.out, withoutPos(out))
+	}
+}
+
+func TestSplitPos(t *testing.T) {
+	text := "--query k\n  select 1;\n/* c */ select\n  'é', 2 ;  select 3\n"
+	out, err := scanAll(SplitText(text))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(out))
+	assert.Equal(t, Pos{Line: 2, Column: 3, Offset: 12}, out[0].Start)
+	assert.Equal(t, Pos{Line: 2, Column: 12, Offset: 21}, out[0].End)
+	assert.Equal(t, Pos{Line: 3, Column: 9, Offset: 30}, out[1].Start)
+	assert.Equal(t, Pos{Line: 4, Column: 11, Offset: 48}, out[1].End)
+	assert.Equal(t, Pos{Line: 4, Column: 13, Offset: 50}, out[2].Start)
+	assert.Equal(t, Pos{Line: 4, Column: 21, Offset: 58}, out[2].End)
+	for _, s := range out {
+		assert.Equal(t, strings.TrimSpace(s.Text), strings.Replace(text[s.Start.Offset:s.End.Offset], "/* c */ ", "", 1))
 	}
+	assert.Equal(t, "line 3", out[1].Location())
+	out[1].File = "a.sql"
+	assert.Equal(t, "a.sql:3", out[1].Location())
+}
+
+func TestSplitErr(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		n    int
+		err  string
+	}{
+		{"select 1;\nselect 'foo;\nselect 2;", 1, "2:8: unterminated quoted string"},
+		{"select \"x", 0, "1:8: unterminated quoted string"},
+		{"select `x;", 0, "1:8: unterminated quoted string"},
+		{"select 1; /* foo;\nselect 2;", 1, "1:11: unterminated comment"},
+		{"select 1; /*+ foo", 1, "1:11: unterminated comment"},
+		{"select 4 / 2;", 1, ""},
+	} {
+		it := SplitText(tt.text)
+		n := 0
+		for it.Scan() {
+			n++
+		}
+		assert.Equal(t, tt.n, n, tt.text)
+		if len(tt.err) == 0 {
+			assert.NoError(t, it.Err(), tt.text)
+			continue
+		}
+		assert.EqualError(t, it.Err(), tt.err, tt.text)
+	}
+
+	it := SplitText("select 'x")
+	assert.EqualError(t, it.Err(), "scan hasn't been called")
+	assert.False(t, it.Scan())
+	assert.False(t, it.Scan())
+	err := it.Err().(*Error)
+	err.File = "a.sql"
+	assert.EqualError(t, err, "a.sql:1:8: unterminated quoted string")
+}
+
+func TestSplitDelimiter(t *testing.T) {
+	proc := "CREATE PROCEDURE p(IN n INT)\nBEGIN\n  DECLARE i INT DEFAULT 0;\n  WHILE i < n DO\n    BEGIN\n      INSERT INTO t VALUES (i);\n      SET i = i + 1;\n    END;\n  END WHILE;\nEND"
+	trig := "CREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW\nBEGIN\n  IF NEW.v < 0 THEN\n    SET NEW.v = 0;\n  END IF;\nEND"
+	for _, tt := range []struct {
+		text string
+		out  []Stmt
+	}{
+		{"delimiter //\n" + proc + " //\ndelimiter ;\nselect 1;", toStmts(proc, "select 1;")},
+		{"DELIMITER $$\n" + proc + "$$\n" + trig + "\n$$\nDELIMITER ;\n", toStmts(proc, trig)},
+		{"DELIMITER ;;\nselect 1;\nselect 2;;\nselect ';;';;\nDELIMITER ;", toStmts("select 1;\nselect 2", "select ';;'")},
+		{"delimiter //\nselect 1 // select 2 //\n//\nselect '//' /* // */ //", toStmts("select 1", "select 2", "select '//'")},
+		{"delimiter //\nselect 1; select 2", toStmts("select 1; select 2")},
+		{"select 1;\n  Delimiter\t|\n--query k\nselect 2|\ndelimiter |\nselect 3|", []Stmt{
+			{Text: "select 1;"},
+			{Text: "select 2", Commands: []Command{{Name: "query", Args: []string{"k"}}}},
+			{Text: "select 3"},
+		}},
+		{"select delimiter, 1\nfrom t;", toStmts("select delimiter, 1\nfrom t;")},
+		{"select 1\ndelimiter //\n;", toStmts("select 1\ndelimiter //\n;")},
+	} {
+		out, err := scanAll(SplitText(tt.text))
+		assert.NoError(t, err, "split: "+tt.text)
+		assert.Equal(t, tt.out, withoutPos(out), "split: "+tt.text)
+	}
+
+	out, err := scanAll(SplitText("delimiter //\nselect 1\n  //\nselect 2;"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(out))
+	assert.Equal(t, Pos{Line: 2, Column: 1, Offset: 13}, out[0].Start)
+	assert.Equal(t, Pos{Line: 3, Column: 5, Offset: 26}, out[0].End)
+	assert.Equal(t, Pos{Line: 4, Column: 1, Offset: 27}, out[1].Start)
+
+	it := SplitText("select 1;\ndelimiter \nselect 2;")
+	assert.True(t, it.Scan())
+	assert.False(t, it.Scan())
+	assert.EqualError(t, it.Err(), "2:1: DELIMITER must be followed by a delimiter")
 }
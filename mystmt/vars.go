@@ -0,0 +1,106 @@
+package mystmt
+
+import (
+	"errors"
+	"strings"
+)
+
+// CmdLet defines a variable by args like "$name = value".
+const CmdLet = "let"
+
+// Vars are variables of statements, they are referred as ${name} in the text
+// of statements outside quoted literals, and also as $name anywhere in args of
+// commands and statements of .test files like mysqltest.
+type Vars map[string]string
+
+// Expand substitutes ${name} outside quoted literals in s by values of
+// variables. It is the rule for statements split by SplitText and SplitFile,
+// where literals like '${name}' are kept as is. Statements split by
+// SplitTestText and SplitTestFile follow mysqltest instead, they are expanded
+// by ExpandArg, which substitutes variables in quoted literals as well.
+func (vs Vars) Expand(s string) (string, error) { return vs.expand(s, false) }
+
+// ExpandArg substitutes both ${name} and $name in s by values of variables,
+// including those in quoted literals.
+func (vs Vars) ExpandArg(s string) (string, error) { return vs.expand(s, true) }
+
+func (vs Vars) expand(s string, arg bool) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if !arg && (s[i] == '\'' || s[i] == '"' || s[i] == '`') {
+			n := quotedLen(s[i:])
+			sb.WriteString(s[i : i+n])
+			i += n - 1
+			continue
+		}
+		if s[i] != '$' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		var name string
+		if s[i+1] == '{' {
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				return "", errors.New("unterminated variable: " + s[i:])
+			}
+			name = s[i+2 : i+2+end]
+			i += end + 2
+		} else if n := nameLen(s[i+1:]); arg && n > 0 {
+			name = s[i+1 : i+1+n]
+			i += n
+		} else {
+			sb.WriteByte(s[i])
+			continue
+		}
+		v, ok := vs[name]
+		if !ok {
+			return "", errors.New("undefined variable: " + name)
+		}
+		sb.WriteString(v)
+	}
+	return sb.String(), nil
+}
+
+// quotedLen returns the length of the literal quoted by the first byte of s,
+// backslashes escape the next byte except in identifiers quoted by backticks.
+func quotedLen(s string) int {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		if s[i] == '\\' && q != '`' {
+			i++
+		} else if s[i] == q {
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// nameLen returns the length of the variable name at the beginning of s.
+func nameLen(s string) int {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9' {
+			continue
+		}
+		return i
+	}
+	return len(s)
+}
+
+// ParseLet parses args of a let command, it returns the name of the variable
+// and its value which is left unexpanded.
+func ParseLet(args []string) (string, string, error) {
+	s := strings.Join(args, " ")
+	eq := strings.IndexByte(s, '=')
+	if eq < 0 {
+		return "", "", errors.New("usage: let $name = value")
+	}
+	name := strings.TrimPrefix(strings.TrimSpace(s[:eq]), "$")
+	if len(name) == 0 || nameLen(name) != len(name) {
+		return "", "", errors.New("invalid variable name: " + s[:eq])
+	}
+	return name, strings.TrimSpace(s[eq+1:]), nil
+}
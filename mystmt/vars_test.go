@@ -0,0 +1,62 @@
+package mystmt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVarsExpand(t *testing.T) {
+	vs := Vars{"n": "1000", "db": "test", "x_1": "x"}
+	for _, tt := range []struct {
+		s    string
+		text string
+		arg  string
+	}{
+		{"select 1", "select 1", "select 1"},
+		{"select * from ${db}.t limit ${n}", "select * from test.t limit 1000", "select * from test.t limit 1000"},
+		{"$n ${x_1}$x_1", "$n x$x_1", "1000 xx"},
+		{"select '$', '$ n', $", "select '$', '$ n', $", "select '$', '$ n', $"},
+		{"select '${n}', \"${db}\", `${x_1}`, ${n}", "select '${n}', \"${db}\", `${x_1}`, 1000", "select '1000', \"test\", `x`, 1000"},
+		{`select 'it\'s ${n}', 'a''${n}', ${n}`, `select 'it\'s ${n}', 'a''${n}', 1000`, `select 'it\'s 1000', 'a''1000', 1000`},
+		{"select ${n}, '${n}", "select 1000, '${n}", "select 1000, '1000"},
+	} {
+		s, err := vs.Expand(tt.s)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.text, s)
+		s, err = vs.ExpandArg(tt.s)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.arg, s)
+	}
+
+	_, err := vs.Expand("select ${m}")
+	assert.EqualError(t, err, "undefined variable: m")
+	_, err = vs.ExpandArg("$m")
+	assert.EqualError(t, err, "undefined variable: m")
+	_, err = vs.Expand("select ${n")
+	assert.EqualError(t, err, "unterminated variable: ${n")
+}
+
+func TestParseLet(t *testing.T) {
+	for _, tt := range []struct {
+		args  []string
+		name  string
+		value string
+	}{
+		{[]string{"$n", "=", "1000"}, "n", "1000"},
+		{[]string{"$n=1000"}, "n", "1000"},
+		{[]string{"$c", "=", "`select", "count(*)", "from", "t`"}, "c", "`select count(*) from t`"},
+		{[]string{"$s", "="}, "s", ""},
+		{[]string{"$e", "=", "a=b"}, "e", "a=b"},
+	} {
+		name, value, err := ParseLet(tt.args)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.name, name)
+		assert.Equal(t, tt.value, value)
+	}
+
+	for _, args := range [][]string{nil, {"$n"}, {"=", "1"}, {"$1n", "=", "1"}, {"$a", "b", "=", "1"}} {
+		_, _, err := ParseLet(args)
+		assert.Error(t, err, "%v", args)
+	}
+}
@@ -0,0 +1,216 @@
+package reporter
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/zyguan/mytest/mycase"
+)
+
+const (
+	FormatJUnit = "junit"
+	FormatTAP   = "tap"
+	FormatJSON  = "json"
+)
+
+func Write(w io.Writer, format string, r *Report) error {
+	switch format {
+	case FormatJUnit:
+		return WriteJUnit(w, r)
+	case FormatTAP:
+		return WriteTAP(w, r)
+	case FormatJSON:
+		return WriteJSON(w, r)
+	default:
+		return errors.New("unknown report format: " + format)
+	}
+}
+
+func WriteJSON(w io.Writer, r *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	ID        string          `xml:"id,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+func WriteJUnit(w io.Writer, r *Report) error {
+	out := junitTestSuites{Name: r.Name, Time: seconds(r.Duration)}
+	for _, c := range r.Cases {
+		ts := junitTestSuite{Name: c.Name, ID: c.ID, Time: seconds(c.Duration)}
+		if !c.Time.IsZero() {
+			ts.Timestamp = c.Time.Format("2006-01-02T15:04:05")
+		}
+		for _, k := range c.Keys {
+			tc := junitTestCase{Name: k.Key, ClassName: c.Name, Time: seconds(0)}
+			switch k.State {
+			case mycase.StateFail:
+				tc.Failure = &junitMessage{Message: firstLine(k.Diff), Type: k.State, Text: k.Diff}
+				ts.Failures++
+			case mycase.StateKnown:
+				tc.Skipped = &junitMessage{Message: "known failure"}
+				ts.Skipped++
+			}
+			ts.Cases = append(ts.Cases, tc)
+		}
+		if len(c.Error) > 0 {
+			ts.Cases = append(ts.Cases, junitTestCase{
+				Name:      c.Stage,
+				ClassName: c.Name,
+				Time:      seconds(c.Duration),
+				Error:     &junitMessage{Message: firstLine(c.Error), Type: c.Stage, Text: c.Error},
+			})
+			ts.Errors++
+		}
+		if len(ts.Cases) == 0 {
+			tc := junitTestCase{Name: c.Name, ClassName: c.Name, Time: seconds(c.Duration)}
+			if c.Outcome != mycase.OutcomePass {
+				tc.Failure = &junitMessage{Message: "case outcome is " + c.Outcome, Type: c.Outcome}
+				ts.Failures++
+			}
+			ts.Cases = append(ts.Cases, tc)
+		}
+		ts.Tests = len(ts.Cases)
+		out.Tests += ts.Tests
+		out.Failures += ts.Failures
+		out.Errors += ts.Errors
+		out.Suites = append(out.Suites, ts)
+	}
+	if len(r.Stale) > 0 {
+		// stale entries are reported as skipped so that they are visible
+		// without failing the report.
+		ts := junitTestSuite{Name: "allowlist", Time: seconds(0)}
+		for _, e := range r.Stale {
+			ts.Cases = append(ts.Cases, junitTestCase{
+				Name:      e.Case + " " + e.Key,
+				ClassName: "allowlist",
+				Time:      seconds(0),
+				Skipped:   &junitMessage{Message: staleText(e)},
+			})
+		}
+		ts.Tests, ts.Skipped = len(ts.Cases), len(ts.Cases)
+		out.Tests += ts.Tests
+		out.Suites = append(out.Suites, ts)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func WriteTAP(w io.Writer, r *Report) error {
+	type point struct {
+		ok    bool
+		desc  string
+		diag  string
+		state string
+		perf  string
+	}
+	var ps []point
+	for _, c := range r.Cases {
+		for _, k := range c.Keys {
+			ps = append(ps, point{k.State != mycase.StateFail && k.State != mycase.StateKnown, c.Name + " " + k.Key, k.Diff, k.State, k.Perf})
+		}
+		if len(c.Error) > 0 {
+			ps = append(ps, point{false, c.Name + " " + c.Stage, c.Error, c.Outcome, ""})
+		} else if len(c.Keys) == 0 {
+			ps = append(ps, point{c.Outcome == mycase.OutcomePass, c.Name, "", c.Outcome, ""})
+		}
+	}
+	var b strings.Builder
+	b.WriteString("TAP version 13\n")
+	fmt.Fprintf(&b, "1..%d\n", len(ps))
+	for i, p := range ps {
+		status := "ok"
+		if !p.ok {
+			status = "not ok"
+		}
+		if p.state == mycase.StateKnown {
+			fmt.Fprintf(&b, "%s %d - %s # TODO known failure\n", status, i+1, p.desc)
+		} else {
+			fmt.Fprintf(&b, "%s %d - %s\n", status, i+1, p.desc)
+		}
+		if len(p.diag) > 0 || len(p.perf) > 0 {
+			b.WriteString("  ---\n")
+			fmt.Fprintf(&b, "  state: %s\n", p.state)
+			if len(p.perf) > 0 {
+				fmt.Fprintf(&b, "  perf: %s\n", p.perf)
+			}
+			if len(p.diag) > 0 {
+				b.WriteString("  message: |\n")
+				for _, line := range strings.Split(p.diag, "\n") {
+					b.WriteString("    " + line + "\n")
+				}
+			}
+			b.WriteString("  ...\n")
+		}
+	}
+	for _, e := range r.Stale {
+		fmt.Fprintf(&b, "# %s: %s %s\n", staleText(e), e.Case, e.Key)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// staleText describes a stale allowlist entry.
+func staleText(e mycase.KnownFailure) string {
+	s := "stale known failure"
+	if len(e.Ticket) > 0 {
+		s += " of " + e.Ticket
+	}
+	return s
+}
+
+func seconds(d float64) string { return fmt.Sprintf("%.3f", d) }
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
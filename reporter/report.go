@@ -0,0 +1,207 @@
+package reporter
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zyguan/mytest/mycase"
+)
+
+// States lists the key states collected from a store, in reporting order.
+var States = []string{mycase.StateOK, mycase.StateFlaky, mycase.StateKnown, mycase.StateFail}
+
+type KeyReport struct {
+	Key         string     `json:"key"`
+	State       string     `json:"state"`
+	Perf        string     `json:"perf,omitempty"`
+	Diff        string     `json:"diff,omitempty"`
+	Fingerprint string     `json:"fingerprint,omitempty"`
+	Classes     [][]string `json:"classes,omitempty"`
+}
+
+type CaseReport struct {
+	Index    int         `json:"-"`
+	ID       string      `json:"id"`
+	Name     string      `json:"name"`
+	Time     time.Time   `json:"time"`
+	Outcome  string      `json:"outcome"`
+	Stage    string      `json:"stage,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Duration float64     `json:"duration"`
+	Keys     []KeyReport `json:"keys"`
+}
+
+type Report struct {
+	Name     string       `json:"name"`
+	Time     time.Time    `json:"time"`
+	Passed   int          `json:"passed"`
+	Failed   int          `json:"failed"`
+	Errored  int          `json:"errored"`
+	Duration float64      `json:"duration"`
+	Cases    []CaseReport `json:"cases"`
+	// Stale lists allowlist entries matching no failure any more.
+	Stale []mycase.KnownFailure `json:"stale,omitempty"`
+
+	mu sync.Mutex
+}
+
+func New(name string) *Report {
+	return &Report{Name: name, Time: time.Now()}
+}
+
+// Add collects the outcome of a finished case. Key states are read back from the
+// task of the case in the store, or taken from errors of the case if the store
+// has none of them.
+func (r *Report) Add(cr mycase.CaseResult, rc mycase.ResultStore) error {
+	c := CaseReport{
+		Index:    cr.Index,
+		ID:       cr.Info.ID,
+		Name:     cr.Info.Name,
+		Time:     cr.Info.Time,
+		Outcome:  cr.Outcome,
+		Duration: cr.Duration.Seconds(),
+	}
+	if len(c.Outcome) == 0 {
+		c.Outcome = mycase.Outcome(cr.Err)
+	}
+	diffs := make(map[string]string)
+	diffErrs := make(map[string][]error)
+	var classes map[string][][]string
+	if e, ok := cr.Err.(*mycase.RunErrors); ok {
+		c.Stage = e.Stage
+		classes = e.Classes
+		var msgs []string
+		if e.ExecErr != nil {
+			msgs = append(msgs, e.ExecErr.Error())
+		}
+		for _, err := range e.StoreErrs {
+			msgs = append(msgs, err.Error())
+		}
+		c.Error = strings.Join(msgs, "\n")
+		for i, key := range e.DiffKeys {
+			if i >= len(e.DiffErrs) {
+				break
+			}
+			if len(diffs[key]) > 0 {
+				diffs[key] += "\n"
+			}
+			diffs[key] += DiffText(e.DiffErrs[i])
+			diffErrs[key] = append(diffErrs[key], e.DiffErrs[i])
+		}
+	} else if cr.Err != nil {
+		c.Error = cr.Err.Error()
+	}
+
+	var err error
+	if rc != nil && len(c.ID) > 0 {
+		err = r.collectKeys(&c, rc, diffs, classes)
+	}
+	if len(c.Keys) == 0 {
+		for key, diff := range diffs {
+			c.Keys = append(c.Keys, KeyReport{Key: key, State: mycase.StateFail, Diff: diff, Classes: classes[key]})
+		}
+		sort.Slice(c.Keys, func(i, j int) bool { return c.Keys[i].Key < c.Keys[j].Key })
+	}
+	for i, k := range c.Keys {
+		if len(diffErrs[k.Key]) > 0 {
+			c.Keys[i].Fingerprint = mycase.DiffFingerprint(diffErrs[k.Key])
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Cases = append(r.Cases, c)
+	sort.SliceStable(r.Cases, func(i, j int) bool { return r.Cases[i].Index < r.Cases[j].Index })
+	switch c.Outcome {
+	case mycase.OutcomePass:
+		r.Passed++
+	case mycase.OutcomeFail:
+		r.Failed++
+	default:
+		r.Errored++
+	}
+	r.Duration = time.Since(r.Time).Seconds()
+	return err
+}
+
+// AddSuite collects every case of a finished suite, key states are read from rc.
+func (r *Report) AddSuite(res *mycase.SuiteResult, rc mycase.ResultStore) error {
+	var fstErr error
+	for _, cr := range res.Cases {
+		if err := r.Add(cr, rc); err != nil && fstErr == nil {
+			fstErr = err
+		}
+	}
+	r.mu.Lock()
+	r.Duration = res.Duration.Seconds()
+	r.Stale = append(r.Stale, res.Stale...)
+	r.mu.Unlock()
+	return fstErr
+}
+
+// collectKeys reads key states of the task of the case from rc, cases whose
+// task is not in the store are skipped.
+func (r *Report) collectKeys(c *CaseReport, rc mycase.ResultStore, diffs map[string]string, classes map[string][][]string) error {
+	_, err := rc.GetTask(c.ID)
+	if err == mycase.ErrTaskNotFound {
+		return nil
+	} else if err != nil {
+		return errors.New("get task: " + err.Error())
+	}
+	slow, err := rc.TaskKeysByState(c.ID, mycase.StateSlow)
+	if err != nil {
+		return errors.New("list " + mycase.StateSlow + " keys: " + err.Error())
+	}
+	isSlow := make(map[string]bool)
+	for _, key := range slow {
+		isSlow[key] = true
+	}
+	for _, state := range States {
+		keys, err := rc.TaskKeysByState(c.ID, state)
+		if err != nil {
+			return errors.New("list " + state + " keys: " + err.Error())
+		}
+		for _, key := range keys {
+			k := KeyReport{Key: key, State: state, Diff: diffs[key], Classes: classes[key]}
+			if isSlow[key] {
+				k.Perf = mycase.StateSlow
+			}
+			if len(k.Diff) == 0 && state != mycase.StateOK {
+				if k.Diff, err = storedDiff(rc, c.ID, key); err != nil {
+					return err
+				}
+			}
+			c.Keys = append(c.Keys, k)
+		}
+	}
+	sort.SliceStable(c.Keys, func(i, j int) bool { return c.Keys[i].Key < c.Keys[j].Key })
+	return nil
+}
+
+// storedDiff renders the diffs recorded by the latest check of the key.
+func storedDiff(rc mycase.ResultStore, taskID string, key string) (string, error) {
+	checks, err := rc.TaskKeyChecks(taskID, key)
+	if err != nil {
+		return "", errors.New("read checks of " + key + ": " + err.Error())
+	}
+	if len(checks) == 0 {
+		return "", nil
+	}
+	diffs := checks[len(checks)-1].Diffs
+	lines := make([]string, len(diffs))
+	for i, d := range diffs {
+		lines[i] = d.String()
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// DiffText renders an error returned by resultset.Checker in a human readable form.
+func DiffText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return mycase.DescribeDiff(err).String()
+}
@@ -0,0 +1,192 @@
+package reporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zyguan/mytest/mycase"
+	"github.com/zyguan/mytest/resultset"
+)
+
+var diff = resultset.DataMismatch{{Pos: [2]int{0, 1}, Val1: []byte("1"), Val2: nil, Assertion: resultset.RawBytesAssertion{}}}
+
+func newReport(t *testing.T) *Report {
+	store, err := mycase.NewSQLiteResultStore(":memory:")
+	assert.NoError(t, err)
+	defer store.Close()
+
+	info := mycase.TaskInfo{ID: "t1", Name: "c1", Time: time.Unix(1573430400, 0)}
+	assert.NoError(t, store.Setup(info))
+	assert.NoError(t, store.MarkCheck(mycase.KeyCheck{Key: "k1", State: mycase.StateOK, Perf: &mycase.PerfCheck{State: mycase.StateSlow}}))
+	assert.NoError(t, store.Mark("k2", mycase.StateFail))
+	assert.NoError(t, store.MarkCheck(mycase.KeyCheck{Key: "k3", State: mycase.StateKnown, Diffs: []mycase.DiffDetail{{Message: "oops"}}}))
+
+	r := New("nightly")
+	assert.NoError(t, r.Add(mycase.CaseResult{
+		Index:    1,
+		Info:     info,
+		Outcome:  mycase.OutcomeFail,
+		Err:      &mycase.RunErrors{Info: info, Stage: mycase.StageCheck, DiffKeys: []string{"k2"}, DiffErrs: []error{diff}},
+		Duration: time.Second,
+	}, store))
+
+	info2 := mycase.TaskInfo{ID: "t2", Name: "c2"}
+	assert.NoError(t, r.Add(mycase.CaseResult{
+		Index: 0,
+		Info:  info2,
+		Err:   &mycase.RunErrors{Info: info2, Stage: mycase.StageSetup, ExecErr: errors.New("oops")},
+	}, store))
+	// reporting must not add unknown tasks to the store.
+	_, err = store.GetTask(info2.ID)
+	assert.Equal(t, mycase.ErrTaskNotFound, err)
+	return r
+}
+
+func TestReport_Add(t *testing.T) {
+	r := newReport(t)
+	assert.Equal(t, 0, r.Passed)
+	assert.Equal(t, 1, r.Failed)
+	assert.Equal(t, 1, r.Errored)
+	assert.Equal(t, 2, len(r.Cases))
+	assert.Equal(t, "c2", r.Cases[0].Name)
+	assert.Equal(t, mycase.OutcomeError, r.Cases[0].Outcome)
+	assert.Equal(t, "oops", r.Cases[0].Error)
+	assert.Empty(t, r.Cases[0].Keys)
+	assert.Equal(t, []KeyReport{
+		{Key: "k1", State: mycase.StateOK, Perf: mycase.StateSlow},
+		{Key: "k2", State: mycase.StateFail, Diff: "1 cells mismatch\n[0:1] \"1\" <> NULL by resultset.RawBytesAssertion", Fingerprint: mycase.DiffFingerprint([]error{diff})},
+		{Key: "k3", State: mycase.StateKnown, Diff: "oops"},
+	}, r.Cases[1].Keys)
+}
+
+func TestReport_StoreErrorsAlongExecError(t *testing.T) {
+	r := New("nightly")
+	info := mycase.TaskInfo{ID: "t1", Name: "c1"}
+	assert.NoError(t, r.Add(mycase.CaseResult{
+		Info:    info,
+		Outcome: mycase.OutcomeError,
+		Err:     &mycase.RunErrors{Info: info, Stage: mycase.StageTest, ExecErr: errors.New("oops"), StoreErrs: []error{errors.New("release store: injected")}},
+	}, nil))
+	assert.Equal(t, "oops\nrelease store: injected", r.Cases[0].Error)
+}
+
+func TestWriteJUnit(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Write(buf, FormatJUnit, newReport(t)))
+	var out junitTestSuites
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &out))
+	assert.Equal(t, 4, out.Tests)
+	assert.Equal(t, 1, out.Failures)
+	assert.Equal(t, 1, out.Errors)
+	assert.Equal(t, 2, len(out.Suites))
+	assert.Equal(t, "SETUP", out.Suites[0].Cases[0].Name)
+	assert.Equal(t, "oops", out.Suites[0].Cases[0].Error.Message)
+	assert.Nil(t, out.Suites[1].Cases[0].Failure)
+	assert.Equal(t, "1 cells mismatch", out.Suites[1].Cases[1].Failure.Message)
+	assert.Contains(t, out.Suites[1].Cases[1].Failure.Text, "[0:1]")
+	assert.Equal(t, 1, out.Suites[1].Skipped)
+	assert.NotNil(t, out.Suites[1].Cases[2].Skipped)
+}
+
+func TestWriteTAP(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Write(buf, FormatTAP, newReport(t)))
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal(t, "TAP version 13", lines[0])
+	assert.Equal(t, "1..4", lines[1])
+	assert.Equal(t, "not ok 1 - c2 SETUP", lines[2])
+	assert.Contains(t, buf.String(), "ok 2 - c1 k1\n  ---\n  state: OK\n  perf: SLOW\n  ...\nnot ok 3 - c1 k2\n  ---\n  state: FAIL\n")
+	assert.Contains(t, buf.String(), "\nnot ok 4 - c1 k3 # TODO known failure\n")
+}
+
+func TestWriteJSON(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Write(buf, FormatJSON, newReport(t)))
+	var out Report
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &out))
+	assert.Equal(t, "nightly", out.Name)
+	assert.Equal(t, 2, len(out.Cases))
+	assert.Equal(t, "k2", out.Cases[1].Keys[1].Key)
+
+	assert.Error(t, Write(buf, "html", &out))
+}
+
+func TestReport_NoStoredStates(t *testing.T) {
+	store, err := mycase.NewSQLiteResultStore(":memory:")
+	assert.NoError(t, err)
+	defer store.Close()
+	info := mycase.TaskInfo{ID: "t1", Name: "c1"}
+	assert.NoError(t, store.Setup(info))
+	assert.NoError(t, store.Mark("k1", mycase.StateOK))
+	assert.NoError(t, store.Setup(mycase.TaskInfo{ID: "t9"}))
+
+	r := New("nightly")
+	assert.NoError(t, r.Add(mycase.CaseResult{Index: 0, Info: info, Outcome: mycase.OutcomePass}, store))
+	// keys are read without switching the current task.
+	assert.Equal(t, "t9", store.CurrentTask.ID)
+	info2 := mycase.TaskInfo{ID: "t2", Name: "c2"}
+	assert.NoError(t, r.Add(mycase.CaseResult{
+		Index: 1,
+		Info:  info2,
+		Err:   &mycase.RunErrors{Info: info2, Stage: mycase.StageCheck, DiffKeys: []string{"k2"}, DiffErrs: []error{diff}},
+	}, store))
+	info3 := mycase.TaskInfo{ID: "t3", Name: "c3"}
+	assert.NoError(t, r.Add(mycase.CaseResult{Index: 2, Info: info3, Outcome: mycase.OutcomeFail}, nil))
+	assert.Equal(t, []KeyReport{{Key: "k1", State: mycase.StateOK}}, r.Cases[0].Keys)
+	assert.Equal(t, "k2", r.Cases[1].Keys[0].Key)
+	assert.Equal(t, mycase.StateFail, r.Cases[1].Keys[0].State)
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, WriteJUnit(buf, r))
+	var out junitTestSuites
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &out))
+	assert.Equal(t, 3, out.Tests)
+	assert.Equal(t, 2, out.Failures)
+	assert.Equal(t, "k2", out.Suites[1].Cases[0].Name)
+	assert.NotNil(t, out.Suites[1].Cases[0].Failure)
+	assert.Equal(t, "case outcome is FAIL", out.Suites[2].Cases[0].Failure.Message)
+
+	buf.Reset()
+	assert.NoError(t, WriteTAP(buf, r))
+	assert.Contains(t, buf.String(), "ok 1 - c1 k1\n")
+	assert.Contains(t, buf.String(), "not ok 2 - c2 k2\n")
+	assert.Contains(t, buf.String(), "not ok 3 - c3\n")
+}
+
+func TestReport_Stale(t *testing.T) {
+	r := New("nightly")
+	stale := []mycase.KnownFailure{{Case: "c1", Key: "^k1$", Ticket: "#42"}}
+	assert.NoError(t, r.AddSuite(&mycase.SuiteResult{
+		Cases:  []mycase.CaseResult{{Info: mycase.TaskInfo{ID: "t1", Name: "c1"}, Outcome: mycase.OutcomePass}},
+		Passed: 1,
+		Stale:  stale,
+	}, nil))
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Write(buf, FormatJSON, r))
+	var out Report
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &out))
+	assert.Equal(t, stale, out.Stale)
+
+	buf.Reset()
+	assert.NoError(t, Write(buf, FormatJUnit, r))
+	var junit junitTestSuites
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &junit))
+	assert.Equal(t, 2, junit.Tests)
+	assert.Equal(t, 0, junit.Failures)
+	assert.Equal(t, "allowlist", junit.Suites[1].Name)
+	assert.Equal(t, 1, junit.Suites[1].Skipped)
+	assert.Equal(t, "c1 ^k1$", junit.Suites[1].Cases[0].Name)
+	assert.Equal(t, "stale known failure of #42", junit.Suites[1].Cases[0].Skipped.Message)
+
+	buf.Reset()
+	assert.NoError(t, Write(buf, FormatTAP, r))
+	assert.Contains(t, buf.String(), "1..1\n")
+	assert.Contains(t, buf.String(), "\n# stale known failure of #42: c1 ^k1$\n")
+}
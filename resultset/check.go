@@ -150,29 +150,130 @@ func (c Checker) Diff(rs1 *ResultSet, rs2 *ResultSet) error {
 	}
 	var cms []CellMismatch
 	for i := 0; i < rs1.NRows(); i++ {
-		for j := 0; j < rs1.NCols(); j++ {
-			v1, _ := rs1.RawValue(i, j)
-			v2, _ := rs2.RawValue(i, j)
-			for _, va := range c.Assertions {
-				if !va.Available(j, rs1.cols[j]) {
-					continue
-				}
-				if eq, ok := va.Equal(v1, v2); ok && !eq {
-					cm := CellMismatch{Pos: [2]int{i, j}, Val1: v1, Val2: v2, Assertion: va}
-					if c.FailFast {
-						return cm
-					}
-					cms = append(cms, cm)
+		if cms = c.diffRow(i, rs1.cols, rs1.data[i], rs2.data[i], cms); c.FailFast && len(cms) > 0 {
+			return cms[0]
+		}
+	}
+	if len(cms) > 0 {
+		return DataMismatch(cms)
+	}
+	return nil
+}
+
+// diffRow appends mismatched cells of the i-th rows to cms, it stops at the
+// first mismatch if FailFast is set.
+func (c Checker) diffRow(i int, cols []ColumnDef, row1 [][]byte, row2 [][]byte, cms []CellMismatch) []CellMismatch {
+	for j := range cols {
+		for _, va := range c.Assertions {
+			if !va.Available(j, cols[j]) {
+				continue
+			}
+			if eq, ok := va.Equal(row1[j], row2[j]); ok && !eq {
+				cms = append(cms, CellMismatch{Pos: [2]int{i, j}, Val1: row1[j], Val2: row2[j], Assertion: va})
+				if c.FailFast {
+					return cms
 				}
 			}
 		}
 	}
+	return cms
+}
+
+// Rows is a stream of rows compared by StreamDiff.
+type Rows interface {
+	Columns() ([]ColumnDef, error)
+	Next() bool
+	// Scan copies values of the current row into row.
+	Scan(row [][]byte) error
+	Err() error
+}
+
+// SQLRows returns rows of a query as Rows.
+func SQLRows(rows *sql.Rows) Rows { return sqlRows{rows: rows} }
+
+type sqlRows struct{ rows *sql.Rows }
+
+func (r sqlRows) Columns() ([]ColumnDef, error) { return columnDefs(r.rows) }
+
+func (r sqlRows) Next() bool { return r.rows.Next() }
+
+func (r sqlRows) Scan(row [][]byte) error { return scanRow(r.rows, row) }
+
+func (r sqlRows) Err() error { return r.rows.Err() }
+
+// StreamDiff is like Diff but compares rows as they are read, so that large
+// results need not be loaded into memory. Both rows are always consumed, so
+// that mismatches are reported in the same order and with the same row counts
+// as Diff. Results of statements are not supported.
+func (c Checker) StreamDiff(rs1 Rows, rs2 Rows) error {
+	cols1, err := rs1.Columns()
+	if err != nil {
+		return err
+	}
+	cols2, err := rs2.Columns()
+	if err != nil {
+		return err
+	}
+	sm := ShapeMismatch{Schema1: cols1, Schema2: cols2}
+	if len(cols1) != len(cols2) {
+		sm.Reason = fmt.Sprintf("len(cols): %d <> %d", len(cols1), len(cols2))
+	} else if c.CheckSchema {
+		sm.Reason = c.diffCols(cols1, cols2)
+	}
+	var (
+		cms   []CellMismatch
+		row1  = make([][]byte, len(cols1))
+		row2  = make([][]byte, len(cols2))
+		next1 = rs1.Next()
+		next2 = rs2.Next()
+	)
+	for ; next1 && next2; next1, next2 = rs1.Next(), rs2.Next() {
+		// cells are compared only if no shape mismatch can be reported and
+		// the first mismatch is kept if FailFast is set.
+		if len(sm.Reason) == 0 && !(c.FailFast && len(cms) > 0) {
+			if err = rs1.Scan(row1); err != nil {
+				return err
+			}
+			if err = rs2.Scan(row2); err != nil {
+				return err
+			}
+			cms = c.diffRow(sm.NRows1, cols1, row1, row2, cms)
+		}
+		sm.NRows1++
+		sm.NRows2++
+	}
+	for ; next1; next1 = rs1.Next() {
+		sm.NRows1++
+	}
+	for ; next2; next2 = rs2.Next() {
+		sm.NRows2++
+	}
+	if err = rs1.Err(); err != nil {
+		return err
+	}
+	if err = rs2.Err(); err != nil {
+		return err
+	}
+	if sm.NRows1 != sm.NRows2 {
+		sm.Reason = fmt.Sprintf("len(rows): %d <> %d", sm.NRows1, sm.NRows2)
+		return sm
+	}
+	if len(sm.Reason) > 0 {
+		return sm
+	}
+	if c.FailFast && len(cms) > 0 {
+		return cms[0]
+	}
 	if len(cms) > 0 {
 		return DataMismatch(cms)
 	}
 	return nil
 }
 
-func (c Checker) StreamDiff(rs1 *sql.Rows, rs2 *sql.Rows) error {
-	panic("TODO")
+func scanRow(rows *sql.Rows, row [][]byte) error {
+	dest := make([]interface{}, len(row))
+	for j := range row {
+		dest[j] = &row[j]
+	}
+	return rows.Scan(dest...)
 }
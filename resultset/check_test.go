@@ -0,0 +1,66 @@
+package resultset
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamDiff(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	q := "select 1 as a, 'x' as b union all select 2, 'y' union all select 3, null"
+	checker := Checker{Assertions: []ValueAssertion{RawBytesAssertion{}}}
+	for _, tt := range []struct {
+		q       string
+		checker Checker
+		err     string
+	}{
+		{q, checker, ""},
+		{"select 1 as a, 'x' as b union all select 2, 'z' union all select 3, 'v'", checker, "2 cells mismatch"},
+		{"select 1 as a, 'x' as b union all select 2, 'z' union all select 3, 'v'", Checker{FailFast: true, Assertions: checker.Assertions}, "[1:1] [121] <> [122] by resultset.RawBytesAssertion"},
+		{"select 1 as a, 'x' as b", checker, "len(rows): 3 <> 1"},
+		{q + " union all select 4, 'w'", checker, "len(rows): 3 <> 4"},
+		{"select 1 as a union all select 2 union all select 3", checker, "len(cols): 2 <> 1"},
+		{"select 1 as a union all select 2", checker, "len(rows): 3 <> 2"},
+		{"select 1 as a, 'x' as c union all select 2, 'y' union all select 3, null", Checker{CheckSchema: true}, "cols[1].name: b <> c"},
+		{"select 1 as a, 'x' as c union all select 2, 'z'", Checker{CheckSchema: true, FailFast: true, Assertions: checker.Assertions}, "len(rows): 3 <> 2"},
+	} {
+		diff := func(read func(rows1 *sql.Rows, rows2 *sql.Rows) error) error {
+			rows1, err := db.Query(q)
+			assert.NoError(t, err)
+			defer rows1.Close()
+			rows2, err := db.Query(tt.q)
+			assert.NoError(t, err)
+			defer rows2.Close()
+			return read(rows1, rows2)
+		}
+		var err3 error
+		err1 := diff(func(rows1 *sql.Rows, rows2 *sql.Rows) error {
+			return tt.checker.StreamDiff(SQLRows(rows1), SQLRows(rows2))
+		})
+		err2 := diff(func(rows1 *sql.Rows, rows2 *sql.Rows) error {
+			rs1, err := ReadFromRows(rows1)
+			assert.NoError(t, err)
+			rs2, err := ReadFromRows(rows2)
+			assert.NoError(t, err)
+			err3 = tt.checker.StreamDiff(rs1.Rows(), rs2.Rows())
+			return tt.checker.Diff(rs1, rs2)
+		})
+		if len(tt.err) == 0 {
+			assert.NoError(t, err1, tt.q)
+			assert.NoError(t, err2, tt.q)
+			assert.NoError(t, err3, tt.q)
+			continue
+		}
+		assert.EqualError(t, err2, tt.err, tt.q)
+		// both ways of diffing must agree on the whole error, otherwise
+		// fingerprints of allowed diffs would change with the way.
+		assert.Equal(t, err2, err1, tt.q)
+		assert.Equal(t, err2, err3, tt.q)
+	}
+}
@@ -56,7 +56,25 @@ func NewFromResult(res sql.Result) *ResultSet {
 	return rs
 }
 
+func NewExec(exec ExecResult) *ResultSet {
+	return &ResultSet{exec: exec}
+}
+
 func ReadFromRows(rows *sql.Rows) (*ResultSet, error) {
+	cols, err := columnDefs(rows)
+	if err != nil {
+		return nil, err
+	}
+	rs := New(cols)
+	for rows.Next() {
+		if err = rows.Scan(rs.AllocateRow()...); err != nil {
+			return rs, err
+		}
+	}
+	return rs, rows.Err()
+}
+
+func columnDefs(rows *sql.Rows) ([]ColumnDef, error) {
 	types, err := rows.ColumnTypes()
 	if err != nil {
 		return nil, err
@@ -69,13 +87,7 @@ func ReadFromRows(rows *sql.Rows) (*ResultSet, error) {
 		cols[i].Length, cols[i].HasLength = t.Length()
 		cols[i].Precision, cols[i].Scale, cols[i].HasPrecisionScale = t.DecimalSize()
 	}
-	rs := New(cols)
-	for rows.Next() {
-		if err = rows.Scan(rs.AllocateRow()...); err != nil {
-			return rs, err
-		}
-	}
-	return rs, rows.Err()
+	return cols, nil
 }
 
 func (rs *ResultSet) IsExecResult() bool { return len(rs.cols) == 0 }
@@ -96,6 +108,30 @@ func (rs *ResultSet) ColumnDef(i int) ColumnDef {
 	return rs.cols[i]
 }
 
+// Rows returns rows of the result set as Rows.
+func (rs *ResultSet) Rows() Rows { return &rsRows{rs: rs, pos: -1} }
+
+type rsRows struct {
+	rs  *ResultSet
+	pos int
+}
+
+func (r *rsRows) Columns() ([]ColumnDef, error) { return r.rs.cols, nil }
+
+func (r *rsRows) Next() bool {
+	if r.pos < len(r.rs.data) {
+		r.pos++
+	}
+	return r.pos < len(r.rs.data)
+}
+
+func (r *rsRows) Scan(row [][]byte) error {
+	copy(row, r.rs.data[r.pos])
+	return nil
+}
+
+func (r *rsRows) Err() error { return nil }
+
 func (rs *ResultSet) Sort(less func(i int, j int) bool) { sort.SliceStable(rs.data, less) }
 
 func (rs *ResultSet) RawValue(i int, j int) ([]byte, bool) {
@@ -109,10 +145,29 @@ func (rs *ResultSet) RawValue(i int, j int) ([]byte, bool) {
 	if j < 0 {
 		j += len(row)
 	}
-	if j < 0 || j >= len(rs.data) {
+	if j < 0 || j >= len(row) {
 		return nil, false
 	}
-	return rs.data[i][j], true
+	return row[j], true
+}
+
+// SetRawValue sets the value at (i, j), nil stands for NULL.
+func (rs *ResultSet) SetRawValue(i int, j int, v []byte) bool {
+	if i < 0 {
+		i += len(rs.data)
+	}
+	if i < 0 || i >= len(rs.data) {
+		return false
+	}
+	row := rs.data[i]
+	if j < 0 {
+		j += len(row)
+	}
+	if j < 0 || j >= len(row) {
+		return false
+	}
+	row[j] = v
+	return true
 }
 
 func (rs *ResultSet) AllocateRow() []interface{} {
@@ -27,6 +27,12 @@ var rss = []ResultSet{
 		{nil},
 		{{}},
 	}, ExecResult{1, 1, true, true}},
+	{[]ColumnDef{
+		{Name: "foo", Type: "TEXT"},
+		{Name: "bar", Type: "INT"},
+	}, [][][]byte{
+		{{0x1}, []byte("1")},
+	}, ExecResult{}},
 }
 
 func init() {
@@ -59,6 +65,38 @@ func TestEncodeDecodeWithMySQLDataSource(t *testing.T) {
 	}
 }
 
+func TestRawValue(t *testing.T) {
+	rs := rss[4]
+	for _, tt := range []struct {
+		i, j int
+		v    []byte
+		ok   bool
+	}{
+		{0, 0, []byte{0x1}, true},
+		{0, 1, []byte("1"), true},
+		{-1, -1, []byte("1"), true},
+		{0, 2, nil, false},
+		{1, 0, nil, false},
+	} {
+		v, ok := rs.RawValue(tt.i, tt.j)
+		assert.Equal(t, tt.v, v)
+		assert.Equal(t, tt.ok, ok)
+	}
+}
+
+func TestSetRawValue(t *testing.T) {
+	rs := New([]ColumnDef{{Name: "a"}, {Name: "b"}})
+	*(rs.AllocateRow()[0].(*[]byte)) = []byte("x")
+	assert.True(t, rs.SetRawValue(0, -1, []byte("y")))
+	assert.True(t, rs.SetRawValue(-1, 0, nil))
+	assert.False(t, rs.SetRawValue(1, 0, []byte("z")))
+	assert.False(t, rs.SetRawValue(0, 2, []byte("z")))
+	v, _ := rs.RawValue(0, 0)
+	assert.Nil(t, v)
+	v, _ = rs.RawValue(0, 1)
+	assert.Equal(t, []byte("y"), v)
+}
+
 func tEncodeDecodeCheck(rs1 *ResultSet) func(t *testing.T) {
 	return func(t *testing.T) {
 		bs, err := rs1.Encode()